@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/nixfile"
+	"focus/internal/safefile"
+)
+
+// 既定の nixpkgs のチャンネル名（--channel unstable は既定と同じ）
+const defaultChannel = "unstable"
+
+// parseChannel は --channel の値を要素に付けるチャンネル名に変換する（既定なら空）
+func parseChannel(channel string) (string, error) {
+	switch channel {
+	case "", defaultChannel:
+		return "", nil
+	case nixfile.StableChannel:
+		return nixfile.StableChannel, nil
+	}
+	return "", fmt.Errorf("チャンネル '%s' は指定できません（%s または %s）", channel, nixfile.StableChannel, defaultChannel)
+}
+
+// channelLabel は表示用のチャンネル名を返す
+func channelLabel(channel string) string {
+	if channel == "" {
+		return defaultChannel
+	}
+	return channel
+}
+
+// stableNixpkgsRef は安定版の nixpkgs のフレーク参照を返す
+// Flake環境で入力がロック済みならそのリビジョンを、なければブランチを使う
+func stableNixpkgsRef(cfg *config.Config) string {
+	if cfg.UseFlake {
+		if locked, err := nix.ReadLockedInput(cfg.FlakePath, nixfile.StableInput); err == nil {
+			return locked.FlakeRef()
+		}
+	}
+	if cfg.StableRev != "" {
+		return nixfile.StableFlakeURL(cfg.StableRev)
+	}
+	return nixfile.StableFlakeURL(cfg.StableNixpkgsBranch())
+}
+
+// channelClient は channel のパッケージを評価するクライアントを返す
+func channelClient(cfg *config.Config, nixClient nix.NixClient, channel string) nix.NixClient {
+	if channel == nixfile.StableChannel {
+		return nixClient.WithNixpkgs(stableNixpkgsRef(cfg))
+	}
+	return nixClient
+}
+
+// ensureStableChannel は pkgs.stable を使えるようにする
+// Flake環境では flake.nix に nixpkgs-stable の入力を追加してロックし、
+// オーバーレイのモジュールを書いて home.nix から import する
+// 戻り値の関数は変更したファイルを元に戻す（switch しなかった場合に使う）
+func ensureStableChannel(cfg *config.Config, nixClient nix.NixClient) (func(), error) {
+	modulePath := cfg.ChannelsModulePath()
+	flakeNix := filepath.Join(cfg.FlakePath, "flake.nix")
+
+	paths := []string{modulePath, cfg.HomeNixPath, getConfigPath()}
+	if cfg.UseFlake {
+		paths = append(paths, flakeNix, filepath.Join(cfg.FlakePath, "flake.lock"))
+	}
+
+	snapshots, err := takeSnapshots(paths...)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := config.Load(getConfigPath())
+	if err != nil {
+		return nil, err
+	}
+
+	undo := func() {
+		cfg.StableBranch, cfg.StableRev, cfg.StableHash = saved.StableBranch, saved.StableRev, saved.StableHash
+		restoreSnapshots(cfg, snapshots)
+		fmt.Fprintln(messageOut, "pkgs.stable の設定を元に戻しました")
+	}
+
+	// ブランチは日付から決まるので、最初に使うときに設定ファイルに保存して以後は変えない
+	cfg.ChooseStableBranch(time.Now())
+	branch := cfg.StableBranch
+
+	// Flakeを使わない環境では flake.lock の代わりに、focus pin と同じくコミットとハッシュで固定する
+	if !cfg.UseFlake && (cfg.StableRev == "" || cfg.StableHash == "") {
+		fmt.Fprintf(messageOut, "%s のコミットを固定しています...\n", branch)
+		rev, err := nixClient.WithNixpkgs(nixfile.StableFlakeURL(branch)).NixpkgsRevision()
+		if err != nil {
+			undo()
+			return nil, fmt.Errorf("%s のリビジョンの取得に失敗: %w", branch, err)
+		}
+		hash, err := nixClient.PrefetchTarball(nixfile.NixpkgsTarballURL(rev))
+		if err != nil {
+			undo()
+			return nil, fmt.Errorf("nixpkgs のソースの取得に失敗: %w", err)
+		}
+		cfg.StableRev, cfg.StableHash = rev, hash
+	}
+
+	if saved.StableBranch != branch || saved.StableRev != cfg.StableRev || saved.StableHash != cfg.StableHash {
+		if err := config.Save(getConfigPath(), cfg); err != nil {
+			undo()
+			return nil, fmt.Errorf("設定ファイルの保存に失敗: %w", err)
+		}
+		if saved.StableBranch != branch {
+			fmt.Fprintf(messageOut, "☑️ 安定版の nixpkgs のブランチを %s に固定しました（設定ファイルの stable_branch）\n", branch)
+		}
+		if saved.StableRev != cfg.StableRev {
+			fmt.Fprintf(messageOut, "☑️ pkgs.stable を %s のコミット %s に固定しました（設定ファイルの stable_rev）\n", branch, cfg.StableRev)
+		}
+	}
+
+	if cfg.UseFlake {
+		added, err := nixfile.AddFlakeInput(flakeNix, nixfile.StableInput, nixfile.StableFlakeURL(branch))
+		if err != nil {
+			undo()
+			return nil, err
+		}
+
+		if added {
+			fmt.Fprintf(messageOut, "☑️ flake.nix に入力 %s (%s) を追加しました\n", nixfile.StableInput, branch)
+			if err := gitAddFile(cfg, flakeNix); err != nil {
+				fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+			}
+
+			fmt.Fprintf(messageOut, "%s をロックしています...\n", nixfile.StableInput)
+			if err := nixClient.UpdateFlakeInput(cfg.FlakePath, nixfile.StableInput); err != nil {
+				undo()
+				return nil, fmt.Errorf("%s のロックに失敗: %w", nixfile.StableInput, err)
+			}
+		}
+	}
+
+	source := nixfile.StableSource{Branch: branch, Rev: cfg.StableRev, Hash: cfg.StableHash}
+	module := nixfile.ChannelsModule(cfg.UseFlake, source)
+	if current, err := os.ReadFile(modulePath); err != nil || !bytes.Equal(current, module) {
+		if err := nixfile.WriteChannelsModule(modulePath, cfg.UseFlake, source); err != nil {
+			undo()
+			return nil, err
+		}
+		if err := gitAddFile(cfg, modulePath); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+		}
+	}
+
+	if err := addImportToHomeNix(cfg.HomeNixPath, modulePath); err != nil {
+		undo()
+		return nil, fmt.Errorf("home.nixへのimport追加に失敗: %w", err)
+	}
+
+	return undo, nil
+}
+
+// fileSnapshot は元に戻すために保存したファイルの内容
+type fileSnapshot struct {
+	path    string
+	content []byte
+	exists  bool
+}
+
+// takeSnapshots は paths の現在の内容を保存する（ないファイルは元に戻すときに削除する）
+func takeSnapshots(paths ...string) ([]fileSnapshot, error) {
+	snapshots := make([]fileSnapshot, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			snapshots = append(snapshots, fileSnapshot{path: path, content: content, exists: true})
+		case os.IsNotExist(err):
+			snapshots = append(snapshots, fileSnapshot{path: path})
+		default:
+			return nil, fmt.Errorf("%s の読み込みに失敗: %w", path, err)
+		}
+	}
+	return snapshots, nil
+}
+
+// restoreSnapshots は保存した内容にファイルを戻す。失敗しても他のファイルは戻す
+func restoreSnapshots(cfg *config.Config, snapshots []fileSnapshot) {
+	for _, snapshot := range snapshots {
+		if current, err := os.ReadFile(snapshot.path); err == nil && snapshot.exists && bytes.Equal(current, snapshot.content) {
+			continue
+		}
+
+		var err error
+		if snapshot.exists {
+			err = safefile.WriteFile(snapshot.path, snapshot.content, 0644)
+		} else {
+			err = os.Remove(snapshot.path)
+			if os.IsNotExist(err) {
+				continue
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "警告: %s の復元に失敗しました: %v\n", snapshot.path, err)
+			continue
+		}
+
+		if err := gitAddFile(cfg, snapshot.path); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/nixfile"
+)
+
+const testFlake = `{
+  inputs = {
+    nixpkgs.url = "github:nixos/nixpkgs?ref=nixos-unstable";
+  };
+
+  outputs = {
+    self,
+    nixpkgs,
+  }: { };
+}
+`
+
+// setupChannelTest は Flake環境で flake.nix と home.nix を用意し、--channel stable を指定する
+func setupChannelTest(t *testing.T) (*config.Config, *nix.MockClient) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "flake.nix"), []byte(testFlake), 0644); err != nil {
+		t.Fatalf("Failed to write flake.nix: %v", err)
+	}
+	homeNix := filepath.Join(dir, "home.nix")
+	if err := os.WriteFile(homeNix, []byte("{ pkgs, ... }: {\n  imports = [\n    ./focus-packages.nix\n  ];\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write home.nix: %v", err)
+	}
+
+	cfg := &config.Config{
+		UseFlake:         true,
+		FlakePath:        dir,
+		FlakeConfig:      "user",
+		HomeNixPath:      homeNix,
+		PackagesFilePath: filepath.Join(dir, "focus-packages.nix"),
+		StableBranch:     "nixos-26.05",
+	}
+	mock := setupCommandTest(t, cfg)
+	mock.NixpkgsVersions = map[string]map[string]string{
+		nixfile.StableFlakeURL("nixos-26.05"): {"ripgrep": "14.1.0"},
+	}
+
+	saved := installChannel
+	installChannel = nixfile.StableChannel
+	t.Cleanup(func() { installChannel = saved })
+
+	return cfg, mock
+}
+
+// TestInstallStableChannel tests adding the stable input and writing stable.<pkg>
+func TestInstallStableChannel(t *testing.T) {
+	cfg, mock := setupChannelTest(t)
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	content, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if !strings.Contains(string(content), "    stable.ripgrep\n") {
+		t.Errorf("Entry was not written with the channel:\n%s", content)
+	}
+
+	flake, err := os.ReadFile(filepath.Join(cfg.FlakePath, "flake.nix"))
+	if err != nil {
+		t.Fatalf("Failed to read flake.nix: %v", err)
+	}
+	if !strings.Contains(string(flake), `nixpkgs-stable.url = "github:NixOS/nixpkgs/nixos-26.05";`) || !strings.Contains(string(flake), "    nixpkgs-stable,\n") {
+		t.Errorf("Input was not added:\n%s", flake)
+	}
+	if strings.Join(mock.UpdatedInputs, ",") != nixfile.StableInput {
+		t.Errorf("Input should be locked once: %v", mock.UpdatedInputs)
+	}
+
+	if _, err := os.Stat(cfg.ChannelsModulePath()); err != nil {
+		t.Errorf("Channels module was not written: %v", err)
+	}
+	homeNix, err := os.ReadFile(cfg.HomeNixPath)
+	if err != nil {
+		t.Fatalf("Failed to read home.nix: %v", err)
+	}
+	if !strings.Contains(string(homeNix), "./focus-channels.nix") {
+		t.Errorf("Channels module was not imported:\n%s", homeNix)
+	}
+
+	savedFormat, savedOut := outputFormat, documentOut
+	var out bytes.Buffer
+	outputFormat, documentOut = outputJSON, &out
+	if err := runList(listCmd, nil); err != nil {
+		t.Fatalf("runList failed: %v", err)
+	}
+	outputFormat, documentOut = savedFormat, savedOut
+
+	var doc packageListDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, out.String())
+	}
+	channels := map[string]string{}
+	for _, pkg := range doc.Packages {
+		channels[pkg.Name] = pkg.Channel + "/" + pkg.Version
+	}
+	if channels["ripgrep"] != "stable/14.1.0" || !strings.HasPrefix(channels["fd"], "/") {
+		t.Errorf("Unexpected channels: %v", channels)
+	}
+
+	// 削除して入れ直しても入力は再度ロックしない
+	if err := runUninstall(uninstallCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runUninstall failed: %v", err)
+	}
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+	if len(mock.UpdatedInputs) != 1 {
+		t.Errorf("Input should not be locked again: %v", mock.UpdatedInputs)
+	}
+}
+
+// TestInstallStableChannelRevertsOnApplyFailure tests that a failed switch restores every file
+func TestInstallStableChannelRevertsOnApplyFailure(t *testing.T) {
+	cfg, mock := setupChannelTest(t)
+	mock.ShouldApplyFail = true
+
+	paths := []string{cfg.PackagesFilePath, cfg.HomeNixPath, filepath.Join(cfg.FlakePath, "flake.nix")}
+	before := map[string]string{}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		before[path] = string(content)
+	}
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err == nil {
+		t.Fatal("runInstall should fail when switch fails")
+	}
+
+	for _, path := range paths {
+		if after, _ := os.ReadFile(path); string(after) != before[path] {
+			t.Errorf("%s was not restored:\n%s", filepath.Base(path), after)
+		}
+	}
+	if _, err := os.Stat(cfg.ChannelsModulePath()); !os.IsNotExist(err) {
+		t.Errorf("Channels module should be removed: %v", err)
+	}
+}
+
+// TestInstallStableChannelSavesBranch tests saving the branch chosen on first use to the config file
+func TestInstallStableChannelSavesBranch(t *testing.T) {
+	cfg, mock := setupChannelTest(t)
+	cfg.StableBranch = ""
+	if err := config.Save(configPath, cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	branch := config.DefaultStableBranch(time.Now())
+	mock.NixpkgsVersions = map[string]map[string]string{
+		nixfile.StableFlakeURL(branch): {"ripgrep": "14.1.0"},
+	}
+
+	// switch に失敗したら設定ファイルにも残さない
+	mock.ShouldApplyFail = true
+	if err := runInstall(installCmd, []string{"ripgrep"}); err == nil {
+		t.Fatal("runInstall should fail when switch fails")
+	}
+	saved, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if saved.StableBranch != "" {
+		t.Errorf("Branch should not be saved after a failed install: %s", saved.StableBranch)
+	}
+
+	mock.ShouldApplyFail = false
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+	saved, err = config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if saved.StableBranch != branch {
+		t.Errorf("stable_branch = %q, want %q", saved.StableBranch, branch)
+	}
+	flake, err := os.ReadFile(filepath.Join(cfg.FlakePath, "flake.nix"))
+	if err != nil {
+		t.Fatalf("Failed to read flake.nix: %v", err)
+	}
+	if !strings.Contains(string(flake), "github:NixOS/nixpkgs/"+branch) {
+		t.Errorf("Input should use the saved branch:\n%s", flake)
+	}
+}
+
+// TestInstallStableChannelRevertsOnInputFailure tests that a flake.nix without inputs leaves the config unchanged
+func TestInstallStableChannelRevertsOnInputFailure(t *testing.T) {
+	cfg, _ := setupChannelTest(t)
+	cfg.StableBranch = ""
+	if err := config.Save(configPath, cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	flakeNix := filepath.Join(cfg.FlakePath, "flake.nix")
+	if err := os.WriteFile(flakeNix, []byte("{\n  outputs = { self }: { };\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write flake.nix: %v", err)
+	}
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err == nil {
+		t.Fatal("runInstall should fail without inputs in flake.nix")
+	}
+
+	if after, _ := os.ReadFile(configPath); string(after) != string(before) {
+		t.Errorf("Config was not restored:\n%s", after)
+	}
+}
+
+// TestInstallStableChannelKeepsTildePaths tests that saving stable_branch keeps ~ in the config file
+func TestInstallStableChannelKeepsTildePaths(t *testing.T) {
+	cfg, mock := setupChannelTest(t)
+	cfg.StableBranch = ""
+	if err := config.Save(configPath, cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	useTildePaths(t, cfg)
+	mock.NixpkgsVersions = map[string]map[string]string{
+		nixfile.StableFlakeURL(config.DefaultStableBranch(time.Now())): {"ripgrep": "14.1.0"},
+	}
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+	assertTildePaths(t, cfg)
+}
+
+// TestInstallStableChannelWithoutFlake tests pinning the stable branch to a commit and hash without flakes
+func TestInstallStableChannelWithoutFlake(t *testing.T) {
+	cfg, mock := setupGroupTest(t)
+	cfg.StableBranch = "nixos-26.05"
+	if err := config.Save(configPath, cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	rev := nixfile.StableFlakeURL("nixos-26.05")
+	mock.PrefetchHashes = map[string]string{nixfile.NixpkgsTarballURL(rev): "sha256-stable="}
+
+	saved := installChannel
+	installChannel = nixfile.StableChannel
+	t.Cleanup(func() { installChannel = saved })
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.StableRev != rev || loaded.StableHash != "sha256-stable=" {
+		t.Errorf("Commit was not saved: %q %q", loaded.StableRev, loaded.StableHash)
+	}
+
+	module, err := os.ReadFile(cfg.ChannelsModulePath())
+	if err != nil {
+		t.Fatalf("Failed to read channels module: %v", err)
+	}
+	if !strings.Contains(string(module), `sha256 = "sha256-stable=";`) || !strings.Contains(string(module), nixfile.NixpkgsTarballURL(rev)) {
+		t.Errorf("Module should fetch the pinned commit:\n%s", module)
+	}
+
+	// 固定したコミットは取得し直さない
+	mock.ShouldPrefetchFail = true
+	if err := runInstall(installCmd, []string{"jq"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+}
+
+// TestInstallRejectsUnknownChannel tests validating --channel
+func TestInstallRejectsUnknownChannel(t *testing.T) {
+	setupChannelTest(t)
+
+	installChannel = "beta"
+	if err := runInstall(installCmd, []string{"ripgrep"}); err == nil {
+		t.Error("runInstall should fail for an unknown channel")
+	}
+}
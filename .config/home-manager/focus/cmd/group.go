@@ -0,0 +1,440 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"focus/internal/config"
+	"focus/internal/nixast"
+	"focus/internal/nixfile"
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "パッケージのグループを管理する",
+	Long: `パッケージをグループごとに別の Nix ファイルにまとめて管理します。
+
+グループは focus install --group <name> で最初にパッケージを追加したときに作られ、
+focus-packages.nix と同じディレクトリの focus-groups/<name>.nix に書き出されます。
+有効なグループは focus-groups/default.nix から import され、
+このファイルは最初のグループを作ったときに home.nix の imports に追加されます。
+グループを無効にすると、メンバーを削除せずにまとめてアンインストールできます。
+
+例:
+ focus install --group dev ripgrep fd
+ focus list --group dev
+ focus group list
+ focus group disable dev
+ focus group enable dev`,
+}
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "グループの一覧を表示する",
+	Args:  cobra.NoArgs,
+	RunE:  runGroupList,
+}
+
+var groupEnableCmd = &cobra.Command{
+	Use:   "enable <name>",
+	Short: "グループを有効にしてメンバーをインストールする",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGroupToggle(cmd, args, true)
+	},
+}
+
+var groupDisableCmd = &cobra.Command{
+	Use:   "disable <name>",
+	Short: "メンバーを残したままグループを無効にする",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGroupToggle(cmd, args, false)
+	},
+}
+
+// packageGroup は --group で指定されたグループ（空ならグループなしの focus-packages.nix）
+var packageGroup string
+
+func init() {
+	rootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupListCmd)
+	groupCmd.AddCommand(groupEnableCmd)
+	groupCmd.AddCommand(groupDisableCmd)
+}
+
+// packageFile は focus が管理するパッケージファイル（Group が空なら focus-packages.nix）
+type packageFile struct {
+	Group    string
+	Path     string
+	Disabled bool
+}
+
+// label は表示用の名前を返す
+func (f packageFile) label() string {
+	if f.Group == "" {
+		return filepath.Base(f.Path)
+	}
+	return fmt.Sprintf("グループ '%s'", f.Group)
+}
+
+// packageFiles は focus-packages.nix と全グループのファイルを返す
+func packageFiles(cfg *config.Config) []packageFile {
+	files := []packageFile{{Path: cfg.PackagesFilePath}}
+	for _, name := range cfg.GroupNames() {
+		files = append(files, packageFile{
+			Group:    name,
+			Path:     cfg.GroupPath(name),
+			Disabled: cfg.Groups[name].Disabled,
+		})
+	}
+	return files
+}
+
+// groupedPackage はパッケージと、それを含むファイル
+type groupedPackage struct {
+	Name      string
+	File      packageFile
+	Condition nixfile.Condition
+	Note      string
+}
+
+// listFilePackages は1つのパッケージファイルのパッケージを返す
+func listFilePackages(file packageFile) ([]groupedPackage, error) {
+	entries, err := nixfile.NewManager(file.Path).ListEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	packages := make([]groupedPackage, 0, len(entries))
+	for _, entry := range entries {
+		packages = append(packages, groupedPackage{Name: entry.Name, File: file, Condition: entry.Condition, Note: entry.Note})
+	}
+	return packages, nil
+}
+
+// listAllPackages は全てのパッケージファイルのパッケージを返す
+// includeDisabled が false なら無効なグループのパッケージは含めない
+func listAllPackages(cfg *config.Config, includeDisabled bool) ([]groupedPackage, error) {
+	var packages []groupedPackage
+	for _, file := range packageFiles(cfg) {
+		if file.Disabled && !includeDisabled {
+			continue
+		}
+
+		filePackages, err := listFilePackages(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s のパッケージ一覧の取得に失敗: %w", file.label(), err)
+		}
+		packages = append(packages, filePackages...)
+	}
+	return packages, nil
+}
+
+// installedAttrPaths は有効な全てのパッケージファイルの属性パスを返す
+// 式のエントリと、focus pin で固定したパッケージ、stable のパッケージは除く
+func installedAttrPaths(cfg *config.Config) ([]string, error) {
+	packages, err := listAllPackages(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	attrPaths := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		if _, _, pinned := pinnedPackage(cfg, pkg.Name); pinned {
+			continue
+		}
+		if channel, _ := nixfile.EntryChannel(pkg.Name); channel != "" {
+			continue
+		}
+		if nixast.IsAttrPath(pkg.Name) {
+			attrPaths = append(attrPaths, pkg.Name)
+		}
+	}
+	return attrPaths, nil
+}
+
+// findPackageFile は packageName を含むパッケージファイルを返す（なければ nil）
+// focus pin で固定したパッケージは focusPinned.<packageName> の、
+// --channel stable でインストールしたパッケージは stable.<packageName> の要素も探す
+func findPackageFile(cfg *config.Config, packageName string) (*packageFile, error) {
+	entries := []string{packageName, nixfile.ChannelEntry(nixfile.StableChannel, packageName)}
+	if _, ok := cfg.Pins[packageName]; ok {
+		entries = append(entries, nixfile.PinnedEntry(packageName))
+	}
+
+	for _, file := range packageFiles(cfg) {
+		if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+			continue
+		}
+
+		manager := nixfile.NewManager(file.Path)
+		for _, entry := range entries {
+			hasPackage, err := manager.HasPackage(entry)
+			if err != nil {
+				return nil, fmt.Errorf("パッケージチェックに失敗: %w", err)
+			}
+			if hasPackage {
+				return &file, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// existingGroupFile は --group で指定された既存のグループのファイルを返す
+// グループを指定しなければ focus-packages.nix を返す
+func existingGroupFile(cfg *config.Config, group string) (packageFile, error) {
+	if group == "" {
+		return packageFile{Path: cfg.PackagesFilePath}, nil
+	}
+
+	settings, ok := cfg.Groups[group]
+	if !ok {
+		return packageFile{}, fmt.Errorf("グループ '%s' はありません", group)
+	}
+
+	return packageFile{Group: group, Path: cfg.GroupPath(group), Disabled: settings.Disabled}, nil
+}
+
+// createGroup はグループのファイルを作って設定に追加し、home.nix から import されるようにする
+// 戻り値の関数はグループの作成を取り消す（switch しなかった場合に使う）
+func createGroup(cfg *config.Config, group string) (func(), error) {
+	if err := config.ValidateGroupName(group); err != nil {
+		return nil, err
+	}
+
+	path := cfg.GroupPath(group)
+
+	snapshots, err := takeSnapshots(path, path+".bak", cfg.GroupsModulePath(), cfg.HomeNixPath, getConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	undo := func() {
+		delete(cfg.Groups, group)
+		restoreSnapshots(cfg, snapshots)
+		fmt.Fprintf(messageOut, "グループ '%s' の作成を取り消しました\n", group)
+	}
+
+	if err := nixfile.CreatePackagesFile(path); err != nil {
+		return nil, fmt.Errorf("グループ '%s' のファイルの作成に失敗: %w", group, err)
+	}
+
+	if cfg.Groups == nil {
+		cfg.Groups = make(map[string]config.Group)
+	}
+	cfg.Groups[group] = config.Group{}
+
+	if err := config.Save(getConfigPath(), cfg); err != nil {
+		undo()
+		return nil, fmt.Errorf("設定ファイルの保存に失敗: %w", err)
+	}
+
+	if err := writeGroupsModule(cfg); err != nil {
+		undo()
+		return nil, err
+	}
+
+	if err := addImportToHomeNix(cfg.HomeNixPath, cfg.GroupsModulePath()); err != nil {
+		undo()
+		return nil, fmt.Errorf("home.nixへのimport追加に失敗: %w", err)
+	}
+
+	if err := gitAddFile(cfg, path); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+	}
+
+	fmt.Fprintf(messageOut, "☑️ グループ '%s' を作成しました: %s\n", group, path)
+	return undo, nil
+}
+
+// writeGroupsModule は有効なグループを import するモジュールを書き直す
+func writeGroupsModule(cfg *config.Config) error {
+	var files []string
+	for _, name := range cfg.GroupNames() {
+		if !cfg.Groups[name].Disabled {
+			files = append(files, cfg.GroupPath(name))
+		}
+	}
+
+	if err := nixfile.WriteGroupsModule(cfg.GroupsModulePath(), files); err != nil {
+		return err
+	}
+
+	if err := gitAddFile(cfg, cfg.GroupsModulePath()); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+	}
+
+	return nil
+}
+
+func runGroupList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	docs := make([]groupDocument, 0, len(cfg.Groups))
+	for _, name := range cfg.GroupNames() {
+		packages, err := nixfile.NewManager(cfg.GroupPath(name)).ListPackages()
+		if err != nil {
+			return fmt.Errorf("グループ '%s' のパッケージ一覧の取得に失敗: %w", name, err)
+		}
+
+		docs = append(docs, groupDocument{
+			Name:     name,
+			Path:     cfg.GroupPath(name),
+			Enabled:  !cfg.Groups[name].Disabled,
+			Packages: nonNil(packages),
+		})
+	}
+
+	if isMachineOutput() {
+		return renderDocument(groupListDocument{
+			SchemaVersion: schemaVersion,
+			Kind:          "groups",
+			Groups:        docs,
+		})
+	}
+
+	if len(docs) == 0 {
+		fmt.Fprintln(messageOut, "グループはありません")
+		fmt.Fprintln(messageOut, "focus install --group <name> <package> でグループを作れます")
+		return nil
+	}
+
+	for _, doc := range docs {
+		status := "有効"
+		if !doc.Enabled {
+			status = "無効"
+		}
+		fmt.Fprintf(messageOut, "%s (%s, %d個): %s\n", doc.Name, status, len(doc.Packages), strings.Join(doc.Packages, ", "))
+	}
+
+	return nil
+}
+
+// runGroupToggle はグループを有効または無効にし、ビルドして確認してから switch する
+// ビルドや switch に失敗した場合、確認でキャンセルした場合は設定とモジュールを元に戻す
+func runGroupToggle(cmd *cobra.Command, args []string, enable bool) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
+
+	// 非対話環境では、ファイルを書き換えてビルドする前に失敗させる
+	if err := ensureCanConfirm(); err != nil {
+		return err
+	}
+
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	group := args[0]
+	settings, ok := cfg.Groups[group]
+	if !ok {
+		return fmt.Errorf("グループ '%s' はありません", group)
+	}
+
+	if settings.Disabled == !enable {
+		if enable {
+			fmt.Fprintf(messageOut, "グループ '%s' は既に有効です\n", group)
+		} else {
+			fmt.Fprintf(messageOut, "グループ '%s' は既に無効です\n", group)
+		}
+		return nil
+	}
+
+	members, err := nixfile.NewManager(cfg.GroupPath(group)).ListPackages()
+	if err != nil {
+		return fmt.Errorf("グループ '%s' のパッケージ一覧の取得に失敗: %w", group, err)
+	}
+
+	verb := "無効"
+	if enable {
+		verb = "有効"
+		result.doc.Added = nonNil(members)
+	} else {
+		result.doc.Removed = nonNil(members)
+	}
+
+	fmt.Fprintf(messageOut, "グループ '%s' を%sにします（%d個: %s）\n", group, verb, len(members), strings.Join(members, ", "))
+
+	if dryRun {
+		printDryRun(cfg, buildCommandLine(cfg), diffClosuresCommandLine)
+		result.doc.Status = statusDryRun
+		return nil
+	}
+
+	configFile := getConfigPath()
+	setGroupDisabled := func(disabled bool) error {
+		settings.Disabled = disabled
+		cfg.Groups[group] = settings
+		if err := config.Save(configFile, cfg); err != nil {
+			return fmt.Errorf("設定ファイルの保存に失敗: %w", err)
+		}
+		return writeGroupsModule(cfg)
+	}
+	revert := func() error {
+		if err := setGroupDisabled(enable); err != nil {
+			return fmt.Errorf("グループの設定の復元に失敗: %w", err)
+		}
+		fmt.Fprintf(messageOut, "グループ '%s' の設定を元に戻しました\n", group)
+		return nil
+	}
+
+	if err := setGroupDisabled(!enable); err != nil {
+		return err
+	}
+
+	nixClient := newNixClient(cfg)
+
+	if err := buildAndPreview(nixClient, cfg, result, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", err)
+		if revertErr := revert(); revertErr != nil {
+			return revertErr
+		}
+		return fmt.Errorf("home-manager build に失敗しました")
+	}
+
+	ok, err = confirm("この内容で switch しますか？")
+	if err != nil {
+		if revertErr := revert(); revertErr != nil {
+			return revertErr
+		}
+		return err
+	}
+
+	if !ok {
+		if err := revert(); err != nil {
+			return err
+		}
+		fmt.Fprintln(messageOut, "キャンセルしました")
+		result.doc.Status = statusCancelled
+		return nil
+	}
+
+	fmt.Fprintln(messageOut, "\nhome-manager switch を実行しています...")
+
+	if switchErr := nixClient.Apply(cfg); switchErr != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", switchErr)
+		if revertErr := revert(); revertErr != nil {
+			return fmt.Errorf("元の状態への復元にも失敗しました: %w\n元のエラー: %v", revertErr, switchErr)
+		}
+		return fmt.Errorf("home-manager switch に失敗しました")
+	}
+
+	fmt.Fprintf(messageOut, "\n☑️ グループ '%s' を%sにしました\n", group, verb)
+	result.doc.Status = statusSuccess
+
+	return nil
+}
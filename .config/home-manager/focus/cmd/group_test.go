@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/nixfile"
+)
+
+// setupGroupTest は home.nix を含む設定でコマンドのテストを準備する
+func setupGroupTest(t *testing.T) (*config.Config, *nix.MockClient) {
+	t.Helper()
+
+	dir := t.TempDir()
+	homeNix := filepath.Join(dir, "home.nix")
+	if err := os.WriteFile(homeNix, []byte("{ pkgs, ... }: {\n  imports = [\n    ./focus-packages.nix\n  ];\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write home.nix: %v", err)
+	}
+
+	cfg := &config.Config{
+		HomeNixPath:      homeNix,
+		PackagesFilePath: filepath.Join(dir, "focus-packages.nix"),
+	}
+	mock := setupCommandTest(t, cfg)
+
+	saved := packageGroup
+	t.Cleanup(func() { packageGroup = saved })
+
+	return cfg, mock
+}
+
+// TestInstallCreatesGroup tests that install --group writes a separate, imported module
+func TestInstallCreatesGroup(t *testing.T) {
+	cfg, _ := setupGroupTest(t)
+
+	packageGroup = "dev"
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if _, ok := loaded.Groups["dev"]; !ok {
+		t.Fatalf("Group was not saved to the config: %+v", loaded.Groups)
+	}
+
+	packages, err := nixfile.NewManager(loaded.GroupPath("dev")).ListPackages()
+	if err != nil || strings.Join(packages, ",") != "ripgrep" {
+		t.Errorf("Unexpected group packages: %v (%v)", packages, err)
+	}
+
+	// グループなしのファイルには追加しない
+	packages, err = nixfile.NewManager(cfg.PackagesFilePath).ListPackages()
+	if err != nil || strings.Join(packages, ",") != "fd" {
+		t.Errorf("Unexpected main packages: %v (%v)", packages, err)
+	}
+
+	module, err := os.ReadFile(loaded.GroupsModulePath())
+	if err != nil || !strings.Contains(string(module), "./dev.nix") {
+		t.Errorf("Groups module does not import dev: %s (%v)", module, err)
+	}
+
+	homeNix, err := os.ReadFile(cfg.HomeNixPath)
+	if err != nil || !strings.Contains(string(homeNix), "./focus-groups/default.nix") {
+		t.Errorf("home.nix does not import the groups module: %s (%v)", homeNix, err)
+	}
+}
+
+// useTildePaths は cfg のディレクトリをホームにして、設定ファイルのパスを ~/... で書き直す
+func useTildePaths(t *testing.T, cfg *config.Config) {
+	t.Helper()
+
+	home := filepath.Dir(cfg.HomeNixPath)
+	t.Setenv("HOME", home)
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if err := os.WriteFile(configPath, []byte(strings.ReplaceAll(string(data), home, "~")), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+// assertTildePaths は設定ファイルのパスが ~/... のまま残っていることを確認する
+func assertTildePaths(t *testing.T, cfg *config.Config) {
+	t.Helper()
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if strings.Contains(string(data), filepath.Dir(cfg.HomeNixPath)) || !strings.Contains(string(data), "'~/home.nix'") {
+		t.Errorf("Config paths were expanded:\n%s", data)
+	}
+}
+
+// TestInstallCreatesGroupKeepsTildePaths tests that saving the new group keeps ~ in the config file
+func TestInstallCreatesGroupKeepsTildePaths(t *testing.T) {
+	cfg, _ := setupGroupTest(t)
+	useTildePaths(t, cfg)
+
+	packageGroup = "dev"
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+	assertTildePaths(t, cfg)
+
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if _, ok := loaded.Groups["dev"]; !ok {
+		t.Fatalf("Group was not saved to the config: %+v", loaded.Groups)
+	}
+
+	// 無効化して保存しても同じ
+	if err := runGroupToggle(groupDisableCmd, []string{"dev"}, false); err != nil {
+		t.Fatalf("runGroupToggle failed: %v", err)
+	}
+	assertTildePaths(t, cfg)
+}
+
+// TestInstallGroupRevertsOnBuildFailure tests that a failed build also removes the group created for the install
+func TestInstallGroupRevertsOnBuildFailure(t *testing.T) {
+	cfg, mock := setupGroupTest(t)
+	mock.ShouldBuildFail = true
+
+	homeNix, err := os.ReadFile(cfg.HomeNixPath)
+	if err != nil {
+		t.Fatalf("Failed to read home.nix: %v", err)
+	}
+
+	packageGroup = "dev"
+	if err := runInstall(installCmd, []string{"ripgrep"}); err == nil {
+		t.Fatal("runInstall should fail when the build fails")
+	}
+
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(loaded.Groups) != 0 {
+		t.Errorf("Group should not be saved: %+v", loaded.Groups)
+	}
+
+	for _, path := range []string{cfg.GroupPath("dev"), cfg.GroupPath("dev") + ".bak", cfg.GroupsModulePath()} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed: %v", path, err)
+		}
+	}
+	if after, _ := os.ReadFile(cfg.HomeNixPath); string(after) != string(homeNix) {
+		t.Errorf("home.nix was not restored:\n%s", after)
+	}
+}
+
+// TestInstallSkipsPackageInOtherGroup tests that a package is never listed in two files
+func TestInstallSkipsPackageInOtherGroup(t *testing.T) {
+	setupGroupTest(t)
+
+	packageGroup = "dev"
+	if err := runInstall(installCmd, []string{"fd"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if _, ok := cfg.Groups["dev"]; ok {
+		t.Error("Group should not be created when nothing is added")
+	}
+}
+
+// TestGroupDisableAndEnable tests toggling a group without touching its members
+func TestGroupDisableAndEnable(t *testing.T) {
+	setupGroupTest(t)
+
+	packageGroup = "dev"
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	if err := runGroupToggle(groupDisableCmd, []string{"dev"}, false); err != nil {
+		t.Fatalf("disable failed: %v", err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !cfg.Groups["dev"].Disabled {
+		t.Error("Group should be disabled")
+	}
+
+	module, _ := os.ReadFile(cfg.GroupsModulePath())
+	if strings.Contains(string(module), "dev.nix") {
+		t.Errorf("Disabled group should not be imported:\n%s", module)
+	}
+
+	packages, err := nixfile.NewManager(cfg.GroupPath("dev")).ListPackages()
+	if err != nil || strings.Join(packages, ",") != "ripgrep" {
+		t.Errorf("Members should be kept: %v (%v)", packages, err)
+	}
+
+	// 無効なグループのパッケージはインストール済みとして扱わない
+	attrPaths, err := installedAttrPaths(cfg)
+	if err != nil || strings.Join(attrPaths, ",") != "fd" {
+		t.Errorf("Unexpected installed packages: %v (%v)", attrPaths, err)
+	}
+
+	if err := runGroupToggle(groupEnableCmd, []string{"dev"}, true); err != nil {
+		t.Fatalf("enable failed: %v", err)
+	}
+
+	cfg, err = config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	module, _ = os.ReadFile(cfg.GroupsModulePath())
+	if cfg.Groups["dev"].Disabled || !strings.Contains(string(module), "./dev.nix") {
+		t.Errorf("Group should be enabled again:\n%s", module)
+	}
+}
+
+// TestGroupDisableRevertsOnFailure tests that a failed switch restores the group settings
+func TestGroupDisableRevertsOnFailure(t *testing.T) {
+	_, mock := setupGroupTest(t)
+
+	packageGroup = "dev"
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	mock.ShouldApplyFail = true
+
+	if err := runGroupToggle(groupDisableCmd, []string{"dev"}, false); err == nil {
+		t.Fatal("Expected disable to fail")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	module, _ := os.ReadFile(cfg.GroupsModulePath())
+	if cfg.Groups["dev"].Disabled || !strings.Contains(string(module), "./dev.nix") {
+		t.Errorf("Group settings should be restored:\n%s", module)
+	}
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"focus/internal/history"
+	"focus/internal/nixfile"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "focus-packages.nix の変更履歴を表示する",
+	Long: `focusで行った focus-packages.nix の変更履歴を表示します。
+各エントリには日時、実行したコマンド、追加・削除したパッケージ、switch の結果が記録されます。
+表示された ID を 'focus rollback <id>' に渡すと、その時点の状態に戻せます。
+
+例:
+ focus history
+ focus history --output json`,
+	Args: cobra.NoArgs,
+	RunE: runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	store, err := history.DefaultStore()
+	if err != nil {
+		return err
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		return fmt.Errorf("履歴の取得に失敗: %w", err)
+	}
+
+	if isMachineOutput() {
+		docs := make([]historyEntryDocument, 0, len(entries))
+		for _, entry := range entries {
+			docs = append(docs, historyEntryDocument{
+				ID:        entry.ID,
+				Timestamp: entry.Timestamp.Format(time.RFC3339),
+				Command:   entry.Command,
+				FilePath:  entry.FilePath,
+				Added:     nonNil(entry.Added),
+				Removed:   nonNil(entry.Removed),
+				Result:    string(entry.Result),
+			})
+		}
+
+		return renderDocument(historyDocument{
+			SchemaVersion: schemaVersion,
+			Kind:          "history",
+			Entries:       docs,
+		})
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(messageOut, "履歴はありません")
+		return nil
+	}
+
+	fmt.Fprintf(messageOut, "変更履歴 (%d件):\n\n", len(entries))
+
+	for _, entry := range entries {
+		result := "☑️"
+		if entry.Result != history.ResultSuccess {
+			result = "✗"
+		}
+
+		fmt.Fprintf(messageOut, "  #%d %s %s %s\n", entry.ID, result, entry.Timestamp.Format("2006-01-02 15:04:05"), entry.Command)
+		if len(entry.Added) > 0 {
+			fmt.Fprintf(messageOut, "	+ %s\n", strings.Join(entry.Added, ", "))
+		}
+		if len(entry.Removed) > 0 {
+			fmt.Fprintf(messageOut, "	- %s\n", strings.Join(entry.Removed, ", "))
+		}
+	}
+
+	return nil
+}
+
+// recordHistory は focus-packages.nix の変更を履歴に記録する
+// 記録に失敗してもコマンド自体は失敗させない
+func recordHistory(cmd *cobra.Command, args []string, manager *nixfile.Manager, before, after []byte, switchErr error) {
+	store, err := history.DefaultStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 履歴の記録に失敗しました: %v\n", err)
+		return
+	}
+
+	beforePackages, _ := manager.PackagesIn(before)
+	afterPackages, _ := manager.PackagesIn(after)
+	added, removed := history.Delta(beforePackages, afterPackages)
+
+	result := history.ResultSuccess
+	if switchErr != nil {
+		result = history.ResultFailed
+	}
+
+	entry := &history.Entry{
+		Command:  strings.TrimSpace(cmd.CommandPath() + " " + strings.Join(args, " ")),
+		FilePath: manager.Path(),
+		Added:    added,
+		Removed:  removed,
+		Result:   result,
+		Before:   string(before),
+		After:    string(after),
+	}
+
+	if err := store.Record(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 履歴の記録に失敗しました: %v\n", err)
+	}
+}
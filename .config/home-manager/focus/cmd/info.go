@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/nixfile"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info <package>",
+	Short: "パッケージの詳細を表示する",
+	Long: `パッケージの meta を評価し、ホームページ、ライセンス、メンテナー、対応プラットフォーム、
+mainProgram、broken/insecure の状態、出力、nixpkgs 内の定義位置を表示します。
+focus と home.nix のどちらでインストールされているかも表示します。
+--channel stable でインストールしたパッケージは、安定版の nixpkgs のものを表示します。
+
+例:
+ focus info ripgrep
+ focus info python3Packages.black
+ focus info ripgrep --output json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInfo,
+}
+
+// パッケージのインストール元
+const (
+	installedByFocus   = "focus"
+	installedByHomeNix = "home.nix"
+	installedByBoth    = "both"
+	installedByNone    = "none"
+)
+
+// maxPlatformsShown はテキスト表示で列挙するプラットフォームの上限
+const maxPlatformsShown = 6
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+}
+
+func runInfo(cmd *cobra.Command, args []string) error {
+	packageName := args[0]
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	channel, err := installedChannel(cfg, packageName)
+	if err != nil {
+		return err
+	}
+
+	nixClient := channelClient(cfg, newNixClient(cfg), channel)
+
+	fmt.Fprintf(messageOut, "'%s' の情報を取得しています...\n\n", packageName)
+
+	info, err := nixClient.PackageInfo(packageName)
+	if err != nil {
+		return err
+	}
+
+	installed, err := installedBy(cfg, packageName)
+	if err != nil {
+		return err
+	}
+
+	if isMachineOutput() {
+		return renderDocument(infoDocument{
+			SchemaVersion:        schemaVersion,
+			Kind:                 "info",
+			Attribute:            packageName,
+			Name:                 info.Name,
+			Pname:                info.Pname,
+			Version:              info.Version,
+			Description:          info.Description,
+			Homepage:             info.Homepage,
+			Licenses:             nonNil(info.Licenses),
+			Maintainers:          nonNil(info.Maintainers),
+			Platforms:            nonNil(info.Platforms),
+			MainProgram:          info.MainProgram,
+			Broken:               info.Broken,
+			Insecure:             info.Insecure,
+			KnownVulnerabilities: nonNil(info.KnownVulnerabilities),
+			Outputs:              nonNil(info.Outputs),
+			Position:             info.RelativePosition(),
+			InstalledBy:          installed,
+			Channel:              channelLabel(channel),
+		})
+	}
+
+	fmt.Fprintf(messageOut, "%s %s\n", packageName, info.Version)
+	if info.Description != "" {
+		fmt.Fprintf(messageOut, "  %s\n", info.Description)
+	}
+	fmt.Fprintln(messageOut)
+
+	printInfoField("ホームページ", info.Homepage)
+	printInfoField("ライセンス", strings.Join(info.Licenses, ", "))
+	printInfoField("メンテナー", strings.Join(info.Maintainers, ", "))
+	printInfoField("プラットフォーム", summarizePlatforms(info.Platforms))
+	printInfoField("mainProgram", info.MainProgram)
+	printInfoField("出力", strings.Join(info.Outputs, ", "))
+	printInfoField("定義", info.RelativePosition())
+	printInfoField("状態", packageState(info))
+	printInfoField("インストール", installedByLabel(installed))
+	printInfoField("チャンネル", channelLabel(channel))
+
+	return nil
+}
+
+// installedBy はパッケージが focus と home.nix のどちらでインストールされているかを返す
+func installedBy(cfg *config.Config, packageName string) (string, error) {
+	// 無効なグループのパッケージはインストールされていない
+	file, err := findPackageFile(cfg, packageName)
+	if err != nil {
+		return "", err
+	}
+	inFocus := file != nil && !file.Disabled
+
+	// home.nix に home.packages のリストがない場合もあるので、読めなければ含まれていないとみなす
+	inHomeNix := false
+	if cfg.HomeNixPath != "" {
+		inHomeNix, _ = nixfile.NewManager(cfg.HomeNixPath).HasPackage(packageName)
+	}
+
+	switch {
+	case inFocus && inHomeNix:
+		return installedByBoth, nil
+	case inFocus:
+		return installedByFocus, nil
+	case inHomeNix:
+		return installedByHomeNix, nil
+	}
+	return installedByNone, nil
+}
+
+// installedChannel は focus でインストールしたパッケージのチャンネルを返す（既定の nixpkgs なら空）
+func installedChannel(cfg *config.Config, packageName string) (string, error) {
+	entry := nixfile.ChannelEntry(nixfile.StableChannel, packageName)
+	for _, file := range packageFiles(cfg) {
+		if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+			continue
+		}
+
+		hasPackage, err := nixfile.NewManager(file.Path).HasPackage(entry)
+		if err != nil {
+			return "", fmt.Errorf("パッケージチェックに失敗: %w", err)
+		}
+		if hasPackage {
+			return nixfile.StableChannel, nil
+		}
+	}
+	return "", nil
+}
+
+func installedByLabel(installed string) string {
+	switch installed {
+	case installedByFocus:
+		return "focus でインストール済み"
+	case installedByHomeNix:
+		return "home.nix でインストール済み"
+	case installedByBoth:
+		return "focus と home.nix の両方でインストール済み"
+	}
+	return "インストールされていません"
+}
+
+// packageState は broken や insecure の状態を表示用にまとめる
+func packageState(info *nix.PackageInfo) string {
+	var states []string
+	if info.Broken {
+		states = append(states, "broken")
+	}
+	if info.Insecure {
+		states = append(states, "insecure")
+	}
+	if len(info.KnownVulnerabilities) > 0 {
+		states = append(states, "既知の脆弱性: "+strings.Join(info.KnownVulnerabilities, "; "))
+	}
+
+	if len(states) == 0 {
+		return "問題なし"
+	}
+	return strings.Join(states, ", ")
+}
+
+// summarizePlatforms はプラットフォームが多い場合に先頭だけを表示する
+func summarizePlatforms(platforms []string) string {
+	if len(platforms) <= maxPlatformsShown {
+		return strings.Join(platforms, ", ")
+	}
+	return fmt.Sprintf("%s ほか %d 個", strings.Join(platforms[:maxPlatformsShown], ", "), len(platforms)-maxPlatformsShown)
+}
+
+func printInfoField(label, value string) {
+	if value == "" {
+		value = "-"
+	}
+	fmt.Fprintf(messageOut, "  %s %s\n", padRight(label+":", 18), value)
+}
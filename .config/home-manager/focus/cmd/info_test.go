@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"focus/internal/config"
+)
+
+// TestInstalledBy tests reporting whether focus, home.nix or both install a package
+func TestInstalledBy(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: filepath.Join(t.TempDir(), "home.nix")}
+	setupCommandTest(t, cfg)
+
+	homeNix := "{ pkgs, ... }: {\n  imports = [ ./focus-packages.nix ];\n  home.packages = with pkgs; [\n    fd\n    git\n  ];\n}\n"
+	if err := os.WriteFile(cfg.HomeNixPath, []byte(homeNix), 0644); err != nil {
+		t.Fatalf("Failed to write home.nix: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"fd", installedByBoth},
+		{"git", installedByHomeNix},
+		{"ripgrep", installedByNone},
+	}
+
+	for _, tt := range tests {
+		got, err := installedBy(cfg, tt.name)
+		if err != nil {
+			t.Fatalf("installedBy(%q) failed: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("installedBy(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// home.nix に home.packages がなくても focus の判定はできる
+	if err := os.WriteFile(cfg.HomeNixPath, []byte("{ ... }: { }\n"), 0644); err != nil {
+		t.Fatalf("Failed to write home.nix: %v", err)
+	}
+	if got, err := installedBy(cfg, "fd"); err != nil || got != installedByFocus {
+		t.Errorf("installedBy(fd) = %q, %v, want %q", got, err, installedByFocus)
+	}
+}
+
+// TestSummarizePlatforms tests truncating long platform lists
+func TestSummarizePlatforms(t *testing.T) {
+	short := []string{"x86_64-linux", "aarch64-darwin"}
+	if got := summarizePlatforms(short); got != "x86_64-linux, aarch64-darwin" {
+		t.Errorf("summarizePlatforms(short) = %q", got)
+	}
+
+	long := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	if got := summarizePlatforms(long); got != "a, b, c, d, e, f ほか 2 個" {
+		t.Errorf("summarizePlatforms(long) = %q", got)
+	}
+}
@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +8,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"focus/internal/config"
+	"focus/internal/safefile"
 )
 
 var initCmd = &cobra.Command{
@@ -27,36 +27,28 @@ func init() {
 }
 
 func runInit(cmd *cobra.Command, args []string) error {
-	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprintln(messageOut, "focusの初期設定を開始します")
+	fmt.Fprintln(messageOut)
 
-	fmt.Println("focusの初期設定を開始します")
-	fmt.Println()
-
-	fmt.Printf("設定ファイルの保存先 [./focus.toml]: ")
-	configPathInput, _ := reader.ReadString('\n')
-	configPathInput = strings.TrimSpace(configPathInput)
-
-	savePath := "./focus.toml"
-	if configPathInput != "" {
-		savePath = configPathInput
+	savePath, err := promptInput("設定ファイルの保存先", "./focus.toml")
+	if err != nil {
+		return err
 	}
 
 	if config.Exists(savePath) {
-		fmt.Printf("設定ファイル '%s' は既に存在します。上書きしますか？ [y/N]: ", savePath)
-		confirm, _ := reader.ReadString('\n')
-		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(confirm)), "y") {
-			fmt.Println("初期設定をキャンセルしました")
+		ok, err := confirm(fmt.Sprintf("設定ファイル '%s' は既に存在します。上書きしますか？", savePath))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(messageOut, "初期設定をキャンセルしました")
 			return nil
 		}
 	}
 
-	fmt.Printf("home.nixのパス [~/.config/home-manager/home.nix]: ")
-	homeNixInput, _ := reader.ReadString('\n')
-	homeNixInput = strings.TrimSpace(homeNixInput)
-
-	homeNixPath := "~/.config/home-manager/home.nix"
-	if homeNixInput != "" {
-		homeNixPath = homeNixInput
+	homeNixPath, err := promptInput("home.nixのパス", "~/.config/home-manager/home.nix")
+	if err != nil {
+		return err
 	}
 
 	expandedHomeNix, err := expandPathForInit(homeNixPath)
@@ -65,23 +57,25 @@ func runInit(cmd *cobra.Command, args []string) error {
 	}
 
 	if _, err := os.Stat(expandedHomeNix); os.IsNotExist(err) {
-		fmt.Printf("警告: '%s'が見つかりません\n", expandedHomeNix)
-		fmt.Print("続行しますか？ [y/N]: ")
-		confirm, _ := reader.ReadString('\n')
-		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(confirm)), "y") {
+		fmt.Fprintf(messageOut, "警告: '%s'が見つかりません\n", expandedHomeNix)
+		ok, err := confirm("続行しますか？")
+		if err != nil {
+			return err
+		}
+		if !ok {
 			return nil
 		}
 	}
 
 	homeNixDir := filepath.Dir(expandedHomeNix)
-	packagesFilePath := filepath.Join(homeNixDir, "focus-packages.nix")
+	defaultPackagesFilePath := filepath.Join(homeNixDir, "focus-packages.nix")
 
-	fmt.Printf("focus-packages.nixのパス [%s]: ", packagesFilePath)
-	packagesInput, _ := reader.ReadString('\n')
-	packagesInput = strings.TrimSpace(packagesInput)
+	packagesFilePath, err := promptInput("focus-packages.nixのパス", defaultPackagesFilePath)
+	if err != nil {
+		return err
+	}
 
-	if packagesInput != "" {
-		packagesFilePath = packagesInput
+	if packagesFilePath != defaultPackagesFilePath {
 		expandedPackages, err := expandPathForInit(packagesFilePath)
 		if err != nil {
 			return fmt.Errorf("packagesファイルのパス展開に失敗: %w", err)
@@ -94,23 +88,41 @@ func runInit(cmd *cobra.Command, args []string) error {
 		PackagesFilePath: packagesFilePath,
 	}
 
+	if dryRun {
+		fmt.Fprintln(messageOut)
+		fmt.Fprintln(messageOut, "以下の変更を行います:")
+		fmt.Fprintf(messageOut, "	設定ファイルを保存: %s\n", savePath)
+		fmt.Fprintf(messageOut, "	パッケージファイルを作成: %s\n", packagesFilePath)
+		fmt.Fprintf(messageOut, "	import文を追加: %s\n", expandedHomeNix)
+		fmt.Fprintln(messageOut)
+		fmt.Fprintln(messageOut, "--dry-run のため、変更は書き込まれていません")
+		return nil
+	}
+
+	// 設定ファイルや home.nix を書き換えている間に、他の focus が読み書きしないようにする
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	if err := config.Save(savePath, cfg); err != nil {
 		return fmt.Errorf("設定ファイルの保存に失敗: %w", err)
 	}
 
-	fmt.Printf("\n☑️ 設定ファイルを保存しました: %s\n", savePath)
+	fmt.Fprintf(messageOut, "\n☑️ 設定ファイルを保存しました: %s\n", savePath)
 
 	if err := createPackagesFile(packagesFilePath); err != nil {
 		return fmt.Errorf("packages ファイルの作成に失敗: %w", err)
 	}
 
-	fmt.Printf("☑️ %sを作成しました\n", packagesFilePath)
+	fmt.Fprintf(messageOut, "☑️ %sを作成しました\n", packagesFilePath)
 
 	if err := addImportToHomeNix(expandedHomeNix, packagesFilePath); err != nil {
 		return fmt.Errorf("home.nixへのimport追加に失敗: %w", err)
 	}
 
-	fmt.Printf("☑️ %sにimport文を追加しました\n", expandedHomeNix)
+	fmt.Fprintf(messageOut, "☑️ %sにimport文を追加しました\n", expandedHomeNix)
 
 	// 設定ファイルが既に存在する場合（再初期化）、Flake設定があればgit addを試みる
 	if loadedCfg, err := config.Load(savePath); err == nil && loadedCfg.UseFlake {
@@ -119,10 +131,10 @@ func runInit(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	fmt.Println()
-	fmt.Println("初期設定が完了しました！")
-	fmt.Println("次のコマンドでパッケージをインストールできます:")
-	fmt.Println("	focus install <package>")
+	fmt.Fprintln(messageOut)
+	fmt.Fprintln(messageOut, "初期設定が完了しました！")
+	fmt.Fprintln(messageOut, "次のコマンドでパッケージをインストールできます:")
+	fmt.Fprintln(messageOut, "	focus install <package>")
 
 	return nil
 }
@@ -139,7 +151,7 @@ func createPackagesFile(path string) error {
 	}
 	`
 
-	return os.WriteFile(path, []byte(content), 0644)
+	return safefile.WriteFile(path, []byte(content), 0644)
 }
 
 func addImportToHomeNix(homeNixPath, packagesFilePath string) error {
@@ -190,12 +202,18 @@ func addImportToHomeNix(homeNixPath, packagesFilePath string) error {
 		content = content[:insertPos] + fmt.Sprintf("\n%s\n	", importLine) + content[insertPos:]
 	}
 
+	// バックアップも home.nix と同じ権限で作る
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(homeNixPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
 	backupPath := homeNixPath + ".bak"
-	if err := os.WriteFile(backupPath, data, 0644); err != nil {
+	if err := safefile.WriteFile(backupPath, data, perm); err != nil {
 		return fmt.Errorf("バックアップの作成に失敗: %w", err)
 	}
 
-	if err := os.WriteFile(homeNixPath, []byte(content), 0644); err != nil {
+	if err := safefile.WriteFile(homeNixPath, []byte(content), 0644); err != nil {
 		return err
 	}
 
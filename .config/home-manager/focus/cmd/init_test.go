@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"focus/internal/state"
+)
+
+// TestInitTakesLock tests that init does not write the config while another focus holds the lock
+func TestInitTakesLock(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("FOCUS_STATE_DIR", filepath.Join(dir, "state"))
+
+	savedAssumeYes := assumeYes
+	assumeYes = true
+	t.Cleanup(func() { assumeYes = savedAssumeYes })
+
+	lock, err := state.AcquireLock(false, nil)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+	defer lock.Release()
+
+	// 既定値で進め、ロックを取れずに失敗する
+	var locked *state.LockedError
+	if err := runInit(initCmd, nil); !errors.As(err, &locked) {
+		t.Fatalf("runInit should fail while locked: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "focus.toml")); !os.IsNotExist(err) {
+		t.Errorf("Config should not be written while locked: %v", err)
+	}
+}
@@ -1,118 +1,522 @@
 package cmd
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"focus/internal/config"
 	"focus/internal/nix"
+	"focus/internal/nixast"
 	"focus/internal/nixfile"
+	"focus/internal/state"
 )
 
 var installCmd = &cobra.Command{
-	Use:   "install [package]",
+	Use:   "install [package...]",
 	Short: "パッケージをインストールする",
 	Long: `指定されたパッケージを focus-packages.nix に追加し、home-manager switch を実行してインストールします。
+複数のパッケージを指定した場合は、1回の確認と1回の switch でまとめてインストールします。
+switch の前に home-manager build でビルドし、クロージャの差分（追加・削除されるパッケージ、
+バージョンの変化、サイズの増減）を表示してから確認します。ビルドに失敗した場合は何も有効化しません。
+いずれかのパッケージが見つからない場合や switch に失敗した場合は、全ての変更を元に戻します。
+--group を指定するとそのグループのファイルに追加します（グループがなければ作成します）。
+
+--only や --host を指定すると、条件を満たす環境でだけインストールする
+lib.optionals <条件> [ ... ] のブロックに追加します。
+--only には darwin, linux, または aarch64-darwin のようなシステム名を指定します。
+--host はモジュール引数 hostname と比較し、パッケージファイルの引数に hostname を追加します。
+Flake環境では homeManagerConfiguration の extraSpecialArgs で hostname を渡す必要があります
+（渡していなければ --host はエラーになります）。Flakeを使わない環境で extraSpecialArgs で渡していなければ、
+focus-host.nix（home.nix の imports に追加）が focus の実行時に環境変数 FOCUS_HOSTNAME で渡す
+ホスト名（ドメインを除く）を使い、ホスト名がわからない場合（focus を使わない home-manager switch など）は
+評価時にエラーになります。
+
+--channel stable を指定すると、安定版の NixOS リリースの nixpkgs からインストールし、
+stable.<package> として追加します。最初に使うときに pkgs.stable を追加するオーバーレイ
+focus-channels.nix を home.nix の imports に追加します。リリースはそのときに最新のものに決めて
+設定ファイルの stable_branch に保存し、以後はそれを使います。
+Flake環境では flake.nix に nixpkgs-stable の入力を追加してロックします
+（homeManagerConfiguration の extraSpecialArgs に inputs が必要です）。Flakeを使わない環境では
+focus pin と同じく、その時点のコミットとハッシュを stable_rev と stable_hash に保存して固定します
+（更新するときや stable_branch を変えたときは、この2つを削除してから --channel stable を使ってください）。
+
+--note を指定すると、インストールした理由などのメモを要素の行末にコメント（# メモ）として書きます。
+メモは focus list に表示され、focus note で後から書き換えられます。
 
 例:
  focus install ripgrep
- focus install fzf`,
-	Args: cobra.ExactArgs(1),
+ focus install ripgrep fd bat jq
+ focus install python3Packages.black nodePackages.prettier
+ focus install --group dev ripgrep fd
+ focus install --only darwin pngpaste
+ focus install --host work-laptop slack
+ focus install --channel stable terraform
+ focus install jq --note "デプロイスクリプトで使う"
+ focus install '(callPackage ./my-tool.nix {})'`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: runInstall,
 }
 
+var (
+	installPlatform string
+	installHost     string
+	installChannel  string
+	installNote     string
+)
+
 func init() {
 	rootCmd.AddCommand(installCmd)
+	installCmd.Flags().StringVar(&packageGroup, "group", "", "追加するグループ（なければ作成する）")
+	installCmd.Flags().StringVar(&installPlatform, "only", "", "指定したプラットフォームでだけインストールする (darwin, linux, <arch>-<os>)")
+	installCmd.Flags().StringVar(&installHost, "host", "", "指定したホストでだけインストールする")
+	installCmd.Flags().StringVar(&installChannel, "channel", "", "パッケージを取得する nixpkgs のチャンネル (stable, unstable)")
+	installCmd.Flags().StringVar(&installNote, "note", "", "パッケージのメモ（要素の行末にコメントとして書く）")
 }
 
-func runInstall(cmd *cobra.Command, args []string) error {
-	packageName := args[0]
+func runInstall(cmd *cobra.Command, args []string) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
+
+	// 非対話環境では、ファイルを書き換えてビルドする前に失敗させる
+	if err := ensureCanConfirm(); err != nil {
+		return err
+	}
+
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
 
-	manager := nixfile.NewManager(cfg.PackagesFilePath)
+	condition, err := installCondition(cfg)
+	if err != nil {
+		return err
+	}
 
-	hasPackage, err := manager.HasPackage(packageName)
+	channel, err := parseChannel(installChannel)
 	if err != nil {
-		return fmt.Errorf("パッケージチェックに失敗: %w", err)
+		return err
+	}
+	if err := nixfile.ValidateNote(installNote); err != nil {
+		return err
 	}
+	for _, packageName := range args {
+		if err := nixfile.ValidatePackage(packageName); err != nil {
+			return err
+		}
+	}
+	if channel != "" {
+		for _, packageName := range args {
+			if !nixast.IsAttrPath(packageName) {
+				return fmt.Errorf("--channel は属性パスのパッケージにだけ指定できます: %s", packageName)
+			}
+		}
+		// 確認、プレビュー、モジュールで同じブランチを使う（設定ファイルへの保存は ensureStableChannel）
+		cfg.ChooseStableBranch(time.Now())
+	}
+
+	target := packageFile{Path: cfg.PackagesFilePath}
+	_, groupExists := cfg.Groups[packageGroup]
+	if packageGroup != "" {
+		if err := config.ValidateGroupName(packageGroup); err != nil {
+			return err
+		}
+		target = packageFile{Group: packageGroup, Path: cfg.GroupPath(packageGroup), Disabled: cfg.Groups[packageGroup].Disabled}
+	}
+
+	manager := nixfile.NewManager(target.Path)
+
+	packageNames := make([]string, 0, len(args))
+	for _, packageName := range uniqueArgs(args) {
+		// 別のグループに同じパッケージがあると home.packages に重複するので追加しない
+		file, err := findPackageFile(cfg, packageName)
+		if err != nil {
+			return err
+		}
 
-	if hasPackage {
-		fmt.Printf("パッケージ '%s' は既にインストールされています\n", packageName)
+		if file != nil {
+			if file.Path == target.Path {
+				fmt.Fprintf(messageOut, "パッケージ '%s' は既にインストールされています\n", packageName)
+			} else {
+				fmt.Fprintf(messageOut, "パッケージ '%s' は既に%sにあります\n", packageName, file.label())
+			}
+			continue
+		}
+
+		packageNames = append(packageNames, packageName)
+	}
+
+	if len(packageNames) == 0 {
 		return nil
 	}
 
-	nixClient := nix.NewClient()
+	nixClient := newNixClient(cfg)
+	checkClient := channelClient(cfg, nixClient, channel)
 
-	fmt.Printf("パッケージ '%s' を検索しています...\n", packageName)
-	exists, err := nixClient.PackageExists(packageName)
-	if err != nil {
-		return fmt.Errorf("パッケージの検索に失敗: %w", err)
+	// 1つでもインストールできなければ何も変更しない
+	var rejected []*nix.PackageCheck
+	resolved := make([]string, 0, len(packageNames))
+	for _, packageName := range packageNames {
+		// (callPackage ./x {}) のような式は nixpkgs で検索できないのでそのまま追加する
+		if !nixast.IsAttrPath(packageName) {
+			resolved = append(resolved, packageName)
+			continue
+		}
+
+		check, err := checkPackage(checkClient, packageName)
+		if err != nil {
+			return err
+		}
+
+		if check.Status == nix.PackageNotFound {
+			replacement, err := pickSuggestion(checkClient, check)
+			if err != nil {
+				return err
+			}
+
+			if replacement != "" {
+				file, err := findPackageFile(cfg, replacement)
+				if err != nil {
+					return err
+				}
+				if file != nil || slices.Contains(resolved, replacement) || slices.Contains(packageNames, replacement) {
+					fmt.Fprintf(messageOut, "パッケージ '%s' は既にインストール対象です\n", replacement)
+					continue
+				}
+
+				check, err = checkPackage(checkClient, replacement)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if !check.Installable() {
+			rejected = append(rejected, check)
+			continue
+		}
+
+		if check.Status == nix.PackageUnfree {
+			fmt.Fprintf(os.Stderr, "警告: パッケージ '%s' は%s\n", check.Name, check.Reason())
+		}
+
+		resolved = append(resolved, check.Name)
+	}
+
+	if len(rejected) > 0 {
+		return rejectedPackagesError(rejected)
+	}
+
+	packageNames = resolved
+	if len(packageNames) == 0 {
+		return nil
+	}
+
+	// --channel stable のパッケージは pkgs.stable から参照する
+	entries := packageNames
+	if channel != "" {
+		entries = make([]string, 0, len(packageNames))
+		for _, packageName := range packageNames {
+			entries = append(entries, nixfile.ChannelEntry(channel, packageName))
+		}
+	}
+
+	// undoSetup はグループの作成や pkgs.stable、hostname 引数のために変更したファイルを、変更と逆の順に元に戻す
+	var undos []func()
+	undoSetup := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
 	}
 
-	if !exists {
-		return fmt.Errorf("パッケージ '%s' が見つかりませんでした", packageName)
+	if packageGroup != "" && !groupExists {
+		if dryRun {
+			fmt.Fprintf(messageOut, "\nグループ '%s' を作成して '%s' を追加します\n", packageGroup, strings.Join(packageNames, "', '"))
+			result.doc.Added = packageNames
+			printDryRun(cfg, buildCommandLine(cfg), diffClosuresCommandLine)
+			result.doc.Status = statusDryRun
+			return nil
+		}
+
+		undo, err := createGroup(cfg, packageGroup)
+		if err != nil {
+			return err
+		}
+		undos = append(undos, undo)
 	}
 
-	diff, err := manager.GetDiff(packageName, true)
+	if target.Disabled {
+		fmt.Fprintf(os.Stderr, "警告: グループ '%s' は無効なので、focus group enable %s を実行するまでインストールされません\n", packageGroup, packageGroup)
+	}
+
+	diff, err := manager.GetAddDiff(entries, condition, installNote)
 	if err != nil {
+		undoSetup()
 		return fmt.Errorf("diff の生成に失敗: %w", err)
 	}
 
-	fmt.Println("\n変更内容:")
-	fmt.Println(diff)
-	fmt.Println()
+	fmt.Fprintln(messageOut, "\n変更内容:")
+	fmt.Fprintln(messageOut, diff)
+	if !condition.IsZero() {
+		fmt.Fprintf(messageOut, "条件: %s\n", condition)
+	}
+	if channel != "" {
+		fmt.Fprintf(messageOut, "チャンネル: %s (%s)\n", channel, stableNixpkgsRef(cfg))
+	}
+	if installNote != "" {
+		fmt.Fprintf(messageOut, "メモ: %s\n", installNote)
+	}
+	fmt.Fprintln(messageOut)
 
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("続行しますか？ [y/N]: ")
-	confirm, _ := reader.ReadString('\n')
+	result.doc.Added = packageNames
+	result.doc.Condition = condition.String()
+	result.doc.Channel = channel
+	result.doc.Note = installNote
 
-	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(confirm)), "y") {
-		fmt.Println("インストールをキャンセルしました")
+	if dryRun {
+		printDryRun(cfg, buildCommandLine(cfg), diffClosuresCommandLine)
+		result.doc.Status = statusDryRun
 		return nil
 	}
 
-	fmt.Printf("\nパッケージ '%s' を追加しています...\n", packageName)
-	if err := manager.AddPackage(packageName); err != nil {
+	fmt.Fprintf(messageOut, "\nパッケージ '%s' を追加しています...\n", strings.Join(packageNames, "', '"))
+	before, err := manager.Snapshot()
+	if err != nil {
+		undoSetup()
+		return err
+	}
+
+	if channel != "" {
+		undo, err := ensureStableChannel(cfg, nixClient)
+		if err != nil {
+			undoSetup()
+			return err
+		}
+		undos = append(undos, undo)
+	}
+	if condition.Host != "" && !cfg.UseFlake {
+		undo, err := ensureHostModule(cfg)
+		if err != nil {
+			undoSetup()
+			return err
+		}
+		undos = append(undos, undo)
+	}
+
+	if err := manager.AddPackagesWithNote(entries, condition, installNote); err != nil {
+		undoSetup()
 		return fmt.Errorf("パッケージの追加に失敗: %w", err)
 	}
 
-	fmt.Println("☑️ focus-packages.nix に追加しました")
+	fmt.Fprintf(messageOut, "☑️ %s に追加しました\n", filepath.Base(target.Path))
 
 	// Flake環境の場合、git addを実行
 	if cfg.UseFlake {
-		if err := gitAddFile(cfg, cfg.PackagesFilePath); err != nil {
+		if err := gitAddFile(cfg, target.Path); err != nil {
 			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
 		}
 	}
 
-	fmt.Println("\nhome-manager switch を実行しています...")
+	// 有効化する前にビルドして、壊れた式や実際の変更内容を確認する
+	// グループを作成した場合はパッケージファイルも取り除くので、先にファイルを戻す
+	revert := func() error {
+		err := revertPackagesFile(cfg, manager)
+		undoSetup()
+		return err
+	}
 
-	var switchErr error
-	if cfg.UseFlake {
-		switchErr = nixClient.(*nix.Client).ApplyHomeManagerWithFlake(cfg.FlakePath, cfg.FlakeConfig)
-	} else {
-		switchErr = nixClient.ApplyHomeManager(cfg.HomeNixPath)
+	// stable のパッケージは既定の nixpkgs で出力パスを評価できないので、サイズは表示しない
+	sized := packageNames
+	if channel != "" {
+		sized = nil
+	}
+
+	if err := buildAndPreview(nixClient, cfg, result, sized); err != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", err)
+		if revertErr := revert(); revertErr != nil {
+			return revertErr
+		}
+		return fmt.Errorf("home-manager build に失敗しました")
+	}
+
+	ok, err := confirm("この内容で switch しますか？")
+	if err != nil {
+		if revertErr := revert(); revertErr != nil {
+			return revertErr
+		}
+		return err
+	}
+
+	if !ok {
+		if err := revert(); err != nil {
+			return err
+		}
+		fmt.Fprintln(messageOut, "インストールをキャンセルしました")
+		result.doc.Status = statusCancelled
+		return nil
+	}
+
+	fmt.Fprintln(messageOut, "\nhome-manager switch を実行しています...")
+
+	switchErr := nixClient.Apply(cfg)
+
+	after, err := manager.Snapshot()
+	if err == nil {
+		recordHistory(cmd, args, manager, before, after, switchErr)
 	}
 
 	if switchErr != nil {
 		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", switchErr)
-		fmt.Println("ロールバックしています...")
+		fmt.Fprintln(messageOut, "ロールバックしています...")
 
-		if rollbackErr := manager.Rollback(); rollbackErr != nil {
+		rollbackErr := manager.Rollback()
+		undoSetup()
+		if rollbackErr != nil {
 			return fmt.Errorf("ロールバックにも失敗しました: %w\n元のエラー: %v", rollbackErr, switchErr)
 		}
 
-		fmt.Println("✓ ロールバックが完了しました")
+		fmt.Fprintln(messageOut, "✓ ロールバックが完了しました")
 		return fmt.Errorf("home-manager switch に失敗しました")
 	}
 
-	fmt.Printf("\n☑️ パッケージ '%s' のインストールが完了しました\n", packageName)
+	fmt.Fprintf(messageOut, "\n☑️ パッケージ '%s' のインストールが完了しました\n", strings.Join(packageNames, "', '"))
+	result.doc.Status = statusSuccess
 
 	return nil
 }
+
+// installCondition は --only と --host からインストールする条件を作る
+func installCondition(cfg *config.Config) (nixfile.Condition, error) {
+	condition := nixfile.Condition{Platform: installPlatform, Host: installHost}
+
+	if condition.Platform != "" {
+		if err := nixfile.ValidatePlatform(condition.Platform); err != nil {
+			return condition, err
+		}
+	}
+
+	if condition.Host != "" {
+		if err := nixfile.ValidateHost(condition.Host); err != nil {
+			return condition, err
+		}
+
+		// 純粋な評価では環境変数を読めないので、focus-host.nix では hostname を定義できない
+		if cfg.UseFlake {
+			passed, err := nixfile.FlakePassesHostArg(filepath.Join(cfg.FlakePath, "flake.nix"))
+			if err != nil {
+				return condition, err
+			}
+			if !passed {
+				return condition, fmt.Errorf("Flake環境で --host を使うには、flake.nix の homeManagerConfiguration で extraSpecialArgs に %s を渡してください（例: extraSpecialArgs = { inherit inputs; %s = \"%s\"; };）", nixfile.HostArg, nixfile.HostArg, condition.Host)
+			}
+		}
+	}
+
+	return condition, nil
+}
+
+// ensureHostModule は --host の条件で使う hostname 引数を定義するモジュールを書き、home.nix から import する
+// 戻り値の関数は変更したファイルを元に戻す（switch しなかった場合に使う）
+func ensureHostModule(cfg *config.Config) (func(), error) {
+	modulePath := cfg.HostModulePath()
+
+	snapshots, err := takeSnapshots(modulePath, cfg.HomeNixPath)
+	if err != nil {
+		return nil, err
+	}
+	undo := func() {
+		restoreSnapshots(cfg, snapshots)
+	}
+
+	module := nixfile.HostModule(nix.HostEnv)
+	if current, err := os.ReadFile(modulePath); err != nil || !bytes.Equal(current, module) {
+		if err := nixfile.WriteHostModule(modulePath, nix.HostEnv); err != nil {
+			return nil, err
+		}
+		if err := gitAddFile(cfg, modulePath); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+		}
+	}
+
+	if err := addImportToHomeNix(cfg.HomeNixPath, modulePath); err != nil {
+		undo()
+		return nil, fmt.Errorf("home.nixへのimport追加に失敗: %w", err)
+	}
+
+	return undo, nil
+}
+
+// rejectedPackagesError はインストールできないパッケージとその理由をまとめたエラーを返す
+func rejectedPackagesError(rejected []*nix.PackageCheck) error {
+	var b strings.Builder
+	b.WriteString("次のパッケージはインストールできません:")
+	for _, check := range rejected {
+		fmt.Fprintf(&b, "\n  %s: %s", check.Name, check.Reason())
+	}
+	return errors.New(b.String())
+}
+
+// checkPackage はパッケージをインストールできるか確認する
+func checkPackage(nixClient nix.NixClient, packageName string) (*nix.PackageCheck, error) {
+	fmt.Fprintf(messageOut, "パッケージ '%s' を確認しています...\n", packageName)
+	check, err := nixClient.CheckPackage(packageName)
+	if err != nil {
+		return nil, fmt.Errorf("パッケージの確認に失敗: %w", err)
+	}
+	return check, nil
+}
+
+// pickSuggestion は見つからなかったパッケージの候補を示し、選ばれた属性名を返す
+// 対話できない場合は候補を check.Detail に入れてエラーメッセージに含める
+func pickSuggestion(nixClient nix.NixClient, check *nix.PackageCheck) (string, error) {
+	suggestions := suggestPackages(nixClient, check.Name)
+	if len(suggestions) == 0 {
+		return "", nil
+	}
+
+	if assumeYes || !stdinIsTerminal() {
+		check.Detail = "もしかして " + strings.Join(suggestions, ", ")
+		return "", nil
+	}
+
+	fmt.Fprintf(messageOut, "パッケージ '%s' が見つかりませんでした。もしかして:\n", check.Name)
+	index, err := choose("インストールするパッケージの番号", suggestions)
+	if err != nil || index < 0 {
+		return "", err
+	}
+
+	return suggestions[index], nil
+}
+
+// suggestPackages は nixpkgs の属性名から name に近いものを返す
+// 候補の提示は補助的なものなので、属性名が取得できなければ何も返さない
+func suggestPackages(nixClient nix.NixClient, name string) []string {
+	// 候補はトップレベルの属性名だけなので、python3Packages.x のような属性パスは対象外
+	if strings.Contains(name, ".") {
+		return nil
+	}
+
+	dir, err := state.CacheDir()
+	if err != nil {
+		return nil
+	}
+
+	names, err := nix.LoadAttributeNames(nixClient, dir)
+	if err != nil {
+		return nil
+	}
+
+	return nix.Suggest(name, names)
+}
@@ -0,0 +1,520 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/state"
+)
+
+// setupCommandTest は一時ディレクトリに設定とパッケージファイルを作り、
+// Nixクライアントをモックに差し替える
+func setupCommandTest(t *testing.T, cfg *config.Config) *nix.MockClient {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("FOCUS_STATE_DIR", filepath.Join(dir, "state"))
+	t.Setenv("FOCUS_CACHE_DIR", filepath.Join(dir, "cache"))
+
+	if cfg.PackagesFilePath == "" {
+		cfg.PackagesFilePath = filepath.Join(dir, "focus-packages.nix")
+	}
+	content := "{ pkgs, ... }:\n{\n  home.packages = with pkgs; [\n    fd\n  ];\n}\n"
+	if err := os.WriteFile(cfg.PackagesFilePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write packages file: %v", err)
+	}
+
+	path := filepath.Join(dir, "config.toml")
+	if err := config.Save(path, cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	mock := nix.NewMockClient()
+
+	savedConfigPath, savedAssumeYes, savedClient := configPath, assumeYes, newNixClient
+	configPath = path
+	assumeYes = true
+	newNixClient = func(*config.Config) nix.NixClient { return mock }
+	t.Cleanup(func() {
+		configPath, assumeYes, newNixClient = savedConfigPath, savedAssumeYes, savedClient
+	})
+
+	return mock
+}
+
+// TestInstallUsesFlakeMode tests that install applies through the flake when configured
+func TestInstallUsesFlakeMode(t *testing.T) {
+	mock := setupCommandTest(t, &config.Config{
+		UseFlake:        true,
+		FlakePath:       t.TempDir(),
+		FlakeConfig:     "user",
+		BackupExtension: "backup",
+	})
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	if len(mock.Applied) != 1 {
+		t.Fatalf("Expected 1 apply, got %d", len(mock.Applied))
+	}
+	call := mock.Applied[0]
+	if call.Mode != nix.ApplyModeFlake {
+		t.Errorf("Expected flake mode, got %q", call.Mode)
+	}
+	if !strings.Contains(strings.Join(call.Args, " "), "-b backup") {
+		t.Errorf("Backup extension was not passed: %q", call.Args)
+	}
+}
+
+// TestInstallRollsBackOnApplyFailure tests that a failed switch restores the file
+func TestInstallRollsBackOnApplyFailure(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+	mock.ShouldApplyFail = true
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err == nil {
+		t.Fatal("runInstall should fail when the switch fails")
+	}
+
+	if len(mock.Applied) != 1 || mock.Applied[0].Mode != nix.ApplyModeFile {
+		t.Errorf("Expected a single file-mode apply, got %+v", mock.Applied)
+	}
+
+	after, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("Packages file was not restored\ngot:\n%s\nwant:\n%s", after, before)
+	}
+}
+
+// TestInstallRejectsUninstallablePackages tests that reasons are reported and nothing is written
+func TestInstallRejectsUninstallablePackages(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+	mock.PackageStatuses["rg"] = nix.PackageNotFound
+	mock.PackageStatuses["oldpkg"] = nix.PackageBroken
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	err = runInstall(installCmd, []string{"ripgrep", "rg", "oldpkg"})
+	if err == nil {
+		t.Fatal("runInstall should fail for uninstallable packages")
+	}
+	for _, want := range []string{"rg: nixpkgs に存在しません", "oldpkg: broken"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error should contain %q: %v", want, err)
+		}
+	}
+
+	if len(mock.Applied) != 0 {
+		t.Errorf("Apply should not be called, got %+v", mock.Applied)
+	}
+
+	after, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Error("Packages file should not change")
+	}
+}
+
+// TestInstallRejectsInvalidElements tests that arguments which aren't a single list element change nothing
+func TestInstallRejectsInvalidElements(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	for _, arg := range []string{"foo bar", "hello # x", "ripgrep,"} {
+		if err := runInstall(installCmd, []string{"jq", arg}); err == nil {
+			t.Errorf("runInstall should fail for %q", arg)
+		}
+	}
+
+	if len(mock.Applied) != 0 {
+		t.Errorf("Apply should not be called, got %+v", mock.Applied)
+	}
+	if after, _ := os.ReadFile(cfg.PackagesFilePath); string(after) != string(before) {
+		t.Errorf("Packages file should not change:\n%s", after)
+	}
+}
+
+// TestInstallAllowsUnfreePackages tests that unfree packages only produce a warning
+func TestInstallAllowsUnfreePackages(t *testing.T) {
+	mock := setupCommandTest(t, &config.Config{HomeNixPath: "/nonexistent/home.nix"})
+	mock.PackageStatuses["vscode"] = nix.PackageUnfree
+
+	if err := runInstall(installCmd, []string{"vscode"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+	if len(mock.Applied) != 1 {
+		t.Errorf("Expected 1 apply, got %d", len(mock.Applied))
+	}
+}
+
+// TestInstallPicksSuggestion tests choosing a suggestion by number
+func TestInstallPicksSuggestion(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+	mock.PackageStatuses["ripgerp"] = nix.PackageNotFound
+	mock.Attributes = []string{"fd", "ripgrep", "ripgrep-all"}
+
+	savedReader, savedTerminal := stdinReader, stdinIsTerminal
+	// 候補の番号を選び、続く確認に y と答える
+	assumeYes = false
+	stdinReader = bufio.NewReader(strings.NewReader("1\ny\n"))
+	stdinIsTerminal = func() bool { return true }
+	t.Cleanup(func() {
+		stdinReader, stdinIsTerminal = savedReader, savedTerminal
+	})
+
+	if err := runInstall(installCmd, []string{"ripgerp"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	content, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if !strings.Contains(string(content), "    ripgrep\n") || strings.Contains(string(content), "ripgerp") {
+		t.Errorf("Suggestion was not installed:\n%s", content)
+	}
+}
+
+// TestInstallSuggestionsNonInteractive tests that suggestions are listed in the error with --yes
+func TestInstallSuggestionsNonInteractive(t *testing.T) {
+	mock := setupCommandTest(t, &config.Config{HomeNixPath: "/nonexistent/home.nix"})
+	mock.PackageStatuses["ripgerp"] = nix.PackageNotFound
+	mock.Attributes = []string{"fd", "ripgrep"}
+
+	err := runInstall(installCmd, []string{"ripgerp"})
+	if err == nil || !strings.Contains(err.Error(), "もしかして ripgrep") {
+		t.Errorf("Error should list suggestions: %v", err)
+	}
+}
+
+// TestNonInteractiveFailsBeforeBuild tests that commands without --yes fail before editing files or building
+func TestNonInteractiveFailsBeforeBuild(t *testing.T) {
+	cfg, mock := setupGroupTest(t)
+	assumeYes = false
+	savedTerminal := stdinIsTerminal
+	stdinIsTerminal = func() bool { return false }
+	t.Cleanup(func() { stdinIsTerminal = savedTerminal })
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	commands := map[string]func() error{
+		"install":   func() error { return runInstall(installCmd, []string{"ripgrep"}) },
+		"uninstall": func() error { return runUninstall(uninstallCmd, []string{"fd"}) },
+		"pin":       func() error { return runPin(pinCmd, []string{"fd"}) },
+		"unpin":     func() error { return runUnpin(unpinCmd, []string{"fd"}) },
+		"group":     func() error { return runGroupToggle(groupDisableCmd, []string{"dev"}, false) },
+		"update":    func() error { return runUpdate(updateCmd, nil) },
+	}
+	for name, run := range commands {
+		if err := run(); err == nil || !strings.Contains(err.Error(), "--yes") {
+			t.Errorf("%s should fail without --yes: %v", name, err)
+		}
+	}
+
+	if after, _ := os.ReadFile(cfg.PackagesFilePath); string(after) != string(before) {
+		t.Errorf("Packages file was changed:\n%s", after)
+	}
+	if mock.Builds != 0 || len(mock.Applied) != 0 {
+		t.Errorf("Expected no builds, got %d builds and %d applies", mock.Builds, len(mock.Applied))
+	}
+
+	// --dry-run は確認しないので非対話でも実行できる
+	savedDryRun := dryRun
+	dryRun = true
+	t.Cleanup(func() { dryRun = savedDryRun })
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Errorf("runInstall --dry-run failed: %v", err)
+	}
+}
+
+// TestInstallBuildFailureDoesNotSwitch tests that a failed build reverts the file before activation
+func TestInstallBuildFailureDoesNotSwitch(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+	mock.ShouldBuildFail = true
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err == nil {
+		t.Fatal("runInstall should fail when the build fails")
+	}
+
+	if mock.Builds != 1 || len(mock.Applied) != 0 {
+		t.Errorf("Expected a build without switch, got %d builds and %d applies", mock.Builds, len(mock.Applied))
+	}
+
+	after, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("Packages file was not reverted:\n%s", after)
+	}
+}
+
+// TestInstallClosurePreview tests that the closure diff is reported and cancelling reverts the file
+func TestInstallClosurePreview(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+	mock.ClosureChanges = []nix.ClosureChange{
+		{Name: "ripgrep", NewVersions: []string{"14.1.1"}, SizeDelta: 4 * 1024 * 1024},
+	}
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	// 差分を見てから switch を断る
+	assumeYes = false
+	savedReader, savedTerminal := stdinReader, stdinIsTerminal
+	stdinReader = bufio.NewReader(strings.NewReader("n\n"))
+	stdinIsTerminal = func() bool { return true }
+	savedFormat, savedOut := outputFormat, documentOut
+	var out bytes.Buffer
+	outputFormat, documentOut = outputJSON, &out
+	t.Cleanup(func() {
+		stdinReader, stdinIsTerminal = savedReader, savedTerminal
+		outputFormat, documentOut = savedFormat, savedOut
+	})
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	var doc commandResultDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, out.String())
+	}
+	if doc.Status != statusCancelled || len(doc.Closure) != 1 || doc.Closure[0].Name != "ripgrep" {
+		t.Errorf("Unexpected result: %+v", doc)
+	}
+
+	after, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if string(after) != string(before) || len(mock.Applied) != 0 {
+		t.Error("Cancelling should revert the file without switching")
+	}
+}
+
+// TestInstallShowsPackageSizes tests that the preview reports sizes of the added packages
+func TestInstallShowsPackageSizes(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+
+	generation := "/nix/store/mock-new-home-manager-generation"
+	mock.PathInfos = map[string]nix.StorePathInfo{
+		generation:                {NarSize: 1, References: []string{"/nix/store/mock-fd", "/nix/store/mock-ripgrep"}},
+		"/nix/store/mock-fd":      {NarSize: 50, References: []string{"/nix/store/glibc"}},
+		"/nix/store/mock-ripgrep": {NarSize: 100, References: []string{"/nix/store/glibc"}},
+		"/nix/store/glibc":        {NarSize: 1000},
+	}
+
+	savedFormat, savedOut := outputFormat, documentOut
+	var out bytes.Buffer
+	outputFormat, documentOut = outputJSON, &out
+	t.Cleanup(func() { outputFormat, documentOut = savedFormat, savedOut })
+
+	if err := runInstall(installCmd, []string{"ripgrep"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	var doc commandResultDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, out.String())
+	}
+	if len(doc.Sizes) != 1 {
+		t.Fatalf("Expected sizes for ripgrep only, got %+v", doc.Sizes)
+	}
+
+	size := doc.Sizes[0]
+	if size.Name != "ripgrep" || size.ClosureSize != 1100 || size.UniqueSize == nil || *size.UniqueSize != 100 {
+		t.Errorf("Unexpected size: %+v", size)
+	}
+}
+
+// TestInstallFailsWhileLocked tests that install refuses to run during another operation
+func TestInstallFailsWhileLocked(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+
+	lock, err := state.AcquireLock(false, nil)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+	defer lock.Release()
+
+	err = runInstall(installCmd, []string{"ripgrep"})
+	if !state.IsLocked(err) || !strings.Contains(err.Error(), "pid") {
+		t.Fatalf("Expected lock error with pid, got %v", err)
+	}
+	if len(mock.Applied) != 0 {
+		t.Error("Install should not switch while locked")
+	}
+}
+
+// TestInstallConditional tests that --only and --host add a conditional block shown by list
+func TestInstallConditional(t *testing.T) {
+	cfg, _ := setupGroupTest(t)
+
+	savedPlatform, savedHost := installPlatform, installHost
+	t.Cleanup(func() { installPlatform, installHost = savedPlatform, savedHost })
+
+	installPlatform, installHost = "windows", ""
+	if err := runInstall(installCmd, []string{"pngpaste"}); err == nil {
+		t.Fatal("runInstall should fail for an unknown platform")
+	}
+
+	installPlatform, installHost = "darwin", "work-laptop"
+	if err := runInstall(installCmd, []string{"pngpaste"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	content, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	block := `] ++ lib.optionals (pkgs.stdenv.isDarwin && hostname == "work-laptop") [
+    pngpaste
+  ];`
+	if !strings.Contains(string(content), block) || !strings.Contains(string(content), "{ pkgs, hostname, lib, ... }:") {
+		t.Errorf("Conditional block not found:\n%s", content)
+	}
+
+	// hostname 引数を定義するモジュールを import する
+	if _, err := os.Stat(cfg.HostModulePath()); err != nil {
+		t.Errorf("Host module was not written: %v", err)
+	}
+	homeNix, err := os.ReadFile(cfg.HomeNixPath)
+	if err != nil {
+		t.Fatalf("Failed to read home.nix: %v", err)
+	}
+	if !strings.Contains(string(homeNix), "./focus-host.nix") {
+		t.Errorf("Host module was not imported:\n%s", homeNix)
+	}
+
+	savedFormat, savedOut := outputFormat, documentOut
+	var out bytes.Buffer
+	outputFormat, documentOut = outputJSON, &out
+	t.Cleanup(func() { outputFormat, documentOut = savedFormat, savedOut })
+
+	if err := runList(listCmd, nil); err != nil {
+		t.Fatalf("runList failed: %v", err)
+	}
+
+	var doc packageListDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, out.String())
+	}
+	if len(doc.Packages) != 2 || doc.Packages[0].Condition != "" || doc.Packages[1].Name != "pngpaste" || doc.Packages[1].Condition != "darwin, host=work-laptop" {
+		t.Errorf("Unexpected packages: %+v", doc.Packages)
+	}
+}
+
+// TestInstallHostRevertsOnApplyFailure tests that a failed switch also removes the hostname module and its import
+func TestInstallHostRevertsOnApplyFailure(t *testing.T) {
+	cfg, mock := setupGroupTest(t)
+	mock.ShouldApplyFail = true
+
+	savedHost := installHost
+	installHost = "work-laptop"
+	t.Cleanup(func() { installHost = savedHost })
+
+	homeNix, err := os.ReadFile(cfg.HomeNixPath)
+	if err != nil {
+		t.Fatalf("Failed to read home.nix: %v", err)
+	}
+
+	if err := runInstall(installCmd, []string{"slack"}); err == nil {
+		t.Fatal("runInstall should fail when switch fails")
+	}
+
+	if after, _ := os.ReadFile(cfg.HomeNixPath); string(after) != string(homeNix) {
+		t.Errorf("home.nix was not restored:\n%s", after)
+	}
+	if _, err := os.Stat(cfg.HostModulePath()); !os.IsNotExist(err) {
+		t.Errorf("Host module should be removed: %v", err)
+	}
+}
+
+// TestInstallHostFlakeRequiresSpecialArg tests that --host in a flake needs hostname in extraSpecialArgs
+func TestInstallHostFlakeRequiresSpecialArg(t *testing.T) {
+	cfg, mock := setupChannelTest(t)
+	installChannel = ""
+
+	savedHost := installHost
+	installHost = "work-laptop"
+	t.Cleanup(func() { installHost = savedHost })
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	// 純粋な評価では FOCUS_HOSTNAME を読めないので、focus-host.nix は書かずにエラーにする
+	err = runInstall(installCmd, []string{"slack"})
+	if err == nil || !strings.Contains(err.Error(), "extraSpecialArgs") {
+		t.Fatalf("runInstall should require hostname in extraSpecialArgs: %v", err)
+	}
+	if after, _ := os.ReadFile(cfg.PackagesFilePath); string(after) != string(before) {
+		t.Errorf("Packages file was changed:\n%s", after)
+	}
+	if mock.Builds != 0 {
+		t.Errorf("Expected no builds, got %d", mock.Builds)
+	}
+
+	flake := strings.Replace(testFlake, "}: { };", "}: { extraSpecialArgs = { hostname = \"work-laptop\"; }; };", 1)
+	if err := os.WriteFile(filepath.Join(cfg.FlakePath, "flake.nix"), []byte(flake), 0644); err != nil {
+		t.Fatalf("Failed to write flake.nix: %v", err)
+	}
+	if err := runInstall(installCmd, []string{"slack"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	content, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if !strings.Contains(string(content), `hostname == "work-laptop"`) {
+		t.Errorf("Host condition was not written:\n%s", content)
+	}
+	if _, err := os.Stat(cfg.HostModulePath()); !os.IsNotExist(err) {
+		t.Errorf("Host module should not be written in a flake: %v", err)
+	}
+}
@@ -2,9 +2,13 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+
 	"github.com/spf13/cobra"
 	"focus/internal/nix"
+	"focus/internal/nixast"
 	"focus/internal/nixfile"
+	"focus/internal/state"
 )
 
 var listCmd = &cobra.Command{
@@ -12,14 +16,35 @@ var listCmd = &cobra.Command{
 	Short: "インストール済みパッケージの一覧を表示する",
 	Long: `focusでインストールしたパッケージの一覧を表示します。
 各パッケージのバージョン情報も取得します。
+バージョンは並列に取得し、nixpkgs のリビジョンごとにキャッシュします。
+
+グループがある場合はグループごとに表示し、--group を指定するとそのグループだけを表示します。
+--only や --host で条件付きでインストールしたパッケージには、条件を [darwin] のように表示します。
+focus pin で固定したパッケージには、固定した nixpkgs のコミットを表示します。
+--channel stable でインストールしたパッケージには (stable) と表示し、安定版の nixpkgs のバージョンを表示します。
+install --note や focus note で書いたメモ（要素の行末のコメント）は # メモ のように表示します。
+
+--size を指定すると、現在の home-manager の世代から nix path-info -S で各パッケージの
+クロージャのサイズと、そのパッケージだけが必要とするサイズ（削除すると空く量）を表示します。
 
 例:
- focus list`,
+ focus list
+ focus list --size
+ focus list --group work
+ focus list --output json | jq '.packages[].name'`,
 	RunE: runList,
 }
 
+var (
+	refreshVersions bool
+	showSizes       bool
+)
+
 func init() {
 	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().BoolVar(&refreshVersions, "refresh", false, "バージョンのキャッシュを使わずに取得し直す")
+	listCmd.Flags().StringVar(&packageGroup, "group", "", "表示するグループ")
+	listCmd.Flags().BoolVar(&showSizes, "size", false, "各パッケージのクロージャのサイズを表示する")
 }
 
 func runList(cmd *cobra.Command, args []string) error {
@@ -28,30 +53,202 @@ func runList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	manager := nixfile.NewManager(cfg.PackagesFilePath)
+	var packages []groupedPackage
+	if packageGroup != "" {
+		file, err := existingGroupFile(cfg, packageGroup)
+		if err != nil {
+			return err
+		}
 
-	packages, err := manager.ListPackages()
-	if err != nil {
-		return fmt.Errorf("パッケージ一覧の取得に失敗: %w", err)
+		packages, err = listFilePackages(file)
+		if err != nil {
+			return fmt.Errorf("パッケージ一覧の取得に失敗: %w", err)
+		}
+	} else {
+		packages, err = listAllPackages(cfg, true)
+		if err != nil {
+			return err
+		}
 	}
 
-	if len(packages) == 0 {
-		fmt.Println("インストール済みのパッケージはありません")
-		return nil
+	nixClient := newNixClient(cfg)
+
+	// 固定したパッケージのバージョンは固定したときのものを使う
+	// stable のパッケージのバージョンは安定版の nixpkgs から取得する
+	attrPaths := make([]string, 0, len(packages))
+	var stableNames []string
+	for _, pkg := range packages {
+		if _, _, pinned := pinnedPackage(cfg, pkg.Name); pinned {
+			continue
+		}
+		if channel, name := nixfile.EntryChannel(pkg.Name); channel != "" {
+			stableNames = append(stableNames, name)
+			continue
+		}
+		if nixast.IsAttrPath(pkg.Name) {
+			attrPaths = append(attrPaths, pkg.Name)
+		}
+	}
+
+	versions := lookupVersions(nixClient, attrPaths)
+
+	var stableVersions map[string]string
+	if len(stableNames) > 0 {
+		stableVersions = lookupVersions(channelClient(cfg, nixClient, nixfile.StableChannel), stableNames)
 	}
 
-	nixClient := nix.NewClient()
+	var sizes map[string]nix.PackageSize
+	if showSizes {
+		generation, err := nixClient.CurrentGeneration()
+		if err != nil {
+			return err
+		}
 
-	fmt.Printf("インストール済みパッケージ (%d個):\n", len(packages))
+		sizes, err = nix.PackageSizes(nixClient, generation, attrPaths)
+		if err != nil {
+			return fmt.Errorf("パッケージのサイズの取得に失敗: %w", err)
+		}
+	}
 
+	docs := make([]packageDocument, 0, len(packages))
 	for _, pkg := range packages {
-		version, err := nixClient.GetPackageVersion(pkg)
-		if err != nil {
-			version = "unknown"
+		if name, pin, pinned := pinnedPackage(cfg, pkg.Name); pinned {
+			docs = append(docs, packageDocument{Name: name, Version: pin.Version, Group: pkg.File.Group, Disabled: pkg.File.Disabled, Condition: pkg.Condition.String(), PinnedRevision: pin.Rev, Note: pkg.Note})
+			continue
+		}
+
+		if channel, name := nixfile.EntryChannel(pkg.Name); channel != "" {
+			docs = append(docs, packageDocument{Name: name, Version: stableVersions[name], Group: pkg.File.Group, Disabled: pkg.File.Disabled, Condition: pkg.Condition.String(), Channel: channel, Note: pkg.Note})
+			continue
+		}
+
+		// 式のエントリはバージョンを取得できない
+		if !nixast.IsAttrPath(pkg.Name) {
+			docs = append(docs, packageDocument{Name: pkg.Name, Expression: true, Group: pkg.File.Group, Disabled: pkg.File.Disabled, Condition: pkg.Condition.String(), Note: pkg.Note})
+			continue
+		}
+
+		doc := packageDocument{Name: pkg.Name, Version: versions[pkg.Name], Group: pkg.File.Group, Disabled: pkg.File.Disabled, Condition: pkg.Condition.String(), Note: pkg.Note}
+		if size, ok := sizes[pkg.Name]; ok {
+			sizeDoc := newPackageSizeDocument(pkg.Name, size)
+			doc.ClosureSize = &sizeDoc.ClosureSize
+			doc.UniqueSize = sizeDoc.UniqueSize
+		}
+		docs = append(docs, doc)
+	}
+
+	if isMachineOutput() {
+		return renderDocument(packageListDocument{
+			SchemaVersion: schemaVersion,
+			Kind:          "packages",
+			Packages:      docs,
+		})
+	}
+
+	if len(docs) == 0 {
+		fmt.Fprintln(messageOut, "インストール済みのパッケージはありません")
+		return nil
+	}
+
+	// グループごとに見出しを付けて表示する（docs はファイルごとに並んでいる）
+	for i, doc := range docs {
+		if i == 0 || doc.Group != docs[i-1].Group {
+			if i > 0 {
+				fmt.Fprintln(messageOut)
+			}
+			printListHeading(docs, doc)
+		}
+
+		// suffix は条件、チャンネル、固定したリビジョン、メモ
+		suffix := ""
+		if doc.Condition != "" {
+			suffix = fmt.Sprintf(" [%s]", doc.Condition)
+		}
+		if doc.Channel != "" {
+			suffix += fmt.Sprintf(" (%s)", doc.Channel)
+		}
+		if doc.PinnedRevision != "" {
+			suffix += fmt.Sprintf(" (nixpkgs %s に固定)", shortRevision(doc.PinnedRevision))
+		}
+		if doc.Note != "" {
+			suffix += fmt.Sprintf("  # %s", doc.Note)
 		}
 
-		fmt.Printf("  - %s: %s\n", pkg, version)
+		if doc.Expression {
+			fmt.Fprintf(messageOut, "  - %s: (式)%s\n", doc.Name, suffix)
+			continue
+		}
+		if showSizes {
+			fmt.Fprintf(messageOut, "  - %s: %s (%s)%s\n", doc.Name, doc.Version, formatSizeColumn(sizes, doc.Name), suffix)
+			continue
+		}
+		fmt.Fprintf(messageOut, "  - %s: %s%s\n", doc.Name, doc.Version, suffix)
 	}
 
 	return nil
 }
+
+// printListHeading は doc を含むファイルの見出しを表示する
+func printListHeading(docs []packageDocument, doc packageDocument) {
+	count := 0
+	for _, d := range docs {
+		if d.Group == doc.Group {
+			count++
+		}
+	}
+
+	switch {
+	case doc.Group == "":
+		fmt.Fprintf(messageOut, "インストール済みパッケージ (%d個):\n", count)
+	case doc.Disabled:
+		fmt.Fprintf(messageOut, "グループ '%s' (無効, %d個):\n", doc.Group, count)
+	default:
+		fmt.Fprintf(messageOut, "グループ '%s' (%d個):\n", doc.Group, count)
+	}
+}
+
+// formatSizeColumn は focus list --size で表示するパッケージのサイズを返す
+func formatSizeColumn(sizes map[string]nix.PackageSize, name string) string {
+	size, ok := sizes[name]
+	if !ok {
+		return "サイズ不明"
+	}
+	return formatPackageSize(size)
+}
+
+// lookupVersions はキャッシュを使って複数パッケージのバージョンを取得する
+func lookupVersions(nixClient nix.NixClient, attrPaths []string) map[string]string {
+	cache := loadVersionCache(nixClient)
+	versions := nix.LookupVersions(nixClient, attrPaths, cache)
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: %v\n", err)
+		}
+	}
+	return versions
+}
+
+// loadVersionCache は現在の nixpkgs リビジョンに対応するバージョンキャッシュを読み込む
+// リビジョンが取得できない場合は nil を返し、キャッシュを使わない
+// --refresh 指定時はキャッシュを読まずに取得し直し、その結果でキャッシュを更新する
+func loadVersionCache(nixClient nix.NixClient) *nix.VersionCache {
+	revision, err := nixClient.NixpkgsRevision()
+	if err != nil {
+		return nil
+	}
+
+	dir, err := state.CacheDir()
+	if err != nil {
+		return nil
+	}
+
+	cache, err := nix.LoadVersionCache(dir, revision)
+	if err != nil {
+		return nil
+	}
+	if refreshVersions {
+		cache.Refresh()
+	}
+
+	return cache
+}
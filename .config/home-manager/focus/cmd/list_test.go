@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+)
+
+// TestListSizes tests that list --size reports sizes from the current generation
+func TestListSizes(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+	mock.PathInfos = map[string]nix.StorePathInfo{
+		"/nix/store/mock-current-home-manager-generation": {NarSize: 1, References: []string{"/nix/store/mock-fd"}},
+		"/nix/store/mock-fd":                              {NarSize: 50},
+	}
+
+	savedFormat, savedOut, savedSizes := outputFormat, documentOut, showSizes
+	var out bytes.Buffer
+	outputFormat, documentOut, showSizes = outputJSON, &out, true
+	t.Cleanup(func() { outputFormat, documentOut, showSizes = savedFormat, savedOut, savedSizes })
+
+	if err := runList(listCmd, nil); err != nil {
+		t.Fatalf("runList failed: %v", err)
+	}
+
+	var doc packageListDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, out.String())
+	}
+	if len(doc.Packages) != 1 {
+		t.Fatalf("Expected one package, got %+v", doc.Packages)
+	}
+
+	pkg := doc.Packages[0]
+	if pkg.ClosureSize == nil || *pkg.ClosureSize != 50 || pkg.UniqueSize == nil || *pkg.UniqueSize != 50 {
+		t.Errorf("Unexpected sizes for %s: %+v", pkg.Name, pkg)
+	}
+}
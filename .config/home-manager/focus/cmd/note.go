@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"focus/internal/config"
+	"focus/internal/nixast"
+	"focus/internal/nixfile"
+)
+
+var noteCmd = &cobra.Command{
+	Use:   "note <package> <text>",
+	Short: "パッケージのメモを書き換える",
+	Long: `インストール済みのパッケージのメモを書き換えます。
+メモはパッケージファイルの要素の行末のコメント（# メモ）で、focus list に表示されます。
+空文字列を指定するとメモを削除します。グループ、条件付き、固定したパッケージにも書けます。
+メモはビルドに影響しないため、home-manager switch は実行しません。
+
+例:
+ focus note jq "デプロイスクリプトで使う"
+ focus note jq ""`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runNote,
+}
+
+func init() {
+	rootCmd.AddCommand(noteCmd)
+}
+
+func runNote(cmd *cobra.Command, args []string) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
+
+	packageName := args[0]
+	note := strings.Join(args[1:], " ")
+	if err := nixfile.ValidateNote(note); err != nil {
+		return err
+	}
+
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	file, err := findPackageFile(cfg, packageName)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return fmt.Errorf("パッケージ '%s' はインストールされていません", packageName)
+	}
+
+	manager := nixfile.NewManager(file.Path)
+	entry, err := findEntry(cfg, manager, packageName)
+	if err != nil {
+		return err
+	}
+
+	result.doc.Note = note
+
+	if entry.Note == note {
+		fmt.Fprintf(messageOut, "パッケージ '%s' のメモは変わりません\n", packageName)
+		return nil
+	}
+
+	fmt.Fprintf(messageOut, "\nパッケージ '%s' のメモを書き換えます（%s）\n", packageName, file.label())
+	fmt.Fprintf(messageOut, "  %s → %s\n\n", noteLabel(entry.Note), noteLabel(note))
+
+	if dryRun {
+		fmt.Fprintln(messageOut, "--dry-run のため、変更は書き込まれていません")
+		result.doc.Status = statusDryRun
+		return nil
+	}
+
+	before, err := manager.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err := manager.SetNote(entry.Name, note); err != nil {
+		return fmt.Errorf("メモの書き換えに失敗: %w", err)
+	}
+
+	// Flake環境の場合、git addを実行
+	if cfg.UseFlake {
+		if err := gitAddFile(cfg, file.Path); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+		}
+	}
+
+	after, err := manager.Snapshot()
+	if err == nil {
+		recordHistory(cmd, args, manager, before, after, nil)
+	}
+
+	fmt.Fprintf(messageOut, "☑️ %s のメモを書き換えました\n", filepath.Base(file.Path))
+	result.doc.Status = statusSuccess
+
+	return nil
+}
+
+// findEntry は packageName の要素を返す
+// --channel stable や focus pin で書き換えた要素（stable.<name>, focusPinned.<name>）も探す
+func findEntry(cfg *config.Config, manager *nixfile.Manager, packageName string) (nixfile.Entry, error) {
+	names := []string{nixast.NormalizeName(packageName), nixfile.ChannelEntry(nixfile.StableChannel, packageName)}
+	if _, ok := cfg.Pins[packageName]; ok {
+		names = append(names, nixfile.PinnedEntry(packageName))
+	}
+
+	entries, err := manager.ListEntries()
+	if err != nil {
+		return nixfile.Entry{}, fmt.Errorf("パッケージ一覧の取得に失敗: %w", err)
+	}
+
+	for _, name := range names {
+		for _, entry := range entries {
+			if entry.Name == name {
+				return entry, nil
+			}
+		}
+	}
+	return nixfile.Entry{}, fmt.Errorf("パッケージ '%s' は見つかりませんでした", packageName)
+}
+
+// noteLabel は表示用のメモを返す（なければ (なし)）
+func noteLabel(note string) string {
+	if note == "" {
+		return "(なし)"
+	}
+	return note
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"focus/internal/config"
+)
+
+// TestInstallNoteAndEdit tests writing a note on install, showing it in list and editing it
+func TestInstallNoteAndEdit(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+
+	saved := installNote
+	installNote = "デプロイスクリプトで使う"
+	t.Cleanup(func() { installNote = saved })
+
+	if err := runInstall(installCmd, []string{"jq"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+	installNote = ""
+
+	content, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if !strings.Contains(string(content), "    jq # デプロイスクリプトで使う\n") {
+		t.Errorf("Note was not written:\n%s", content)
+	}
+
+	// メモの書き換えでは switch しない
+	applied := len(mock.Applied)
+	if err := runNote(noteCmd, []string{"jq", "デプロイと", "CI", "で使う"}); err != nil {
+		t.Fatalf("runNote failed: %v", err)
+	}
+	if err := runNote(noteCmd, []string{"fd", "ファイル検索"}); err != nil {
+		t.Fatalf("runNote failed: %v", err)
+	}
+	if len(mock.Applied) != applied {
+		t.Errorf("runNote should not switch: %d applies", len(mock.Applied)-applied)
+	}
+
+	// 後から追加してもメモは残る
+	if err := runInstall(installCmd, []string{"bat"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+
+	savedFormat, savedOut := outputFormat, documentOut
+	var out bytes.Buffer
+	outputFormat, documentOut = outputJSON, &out
+	if err := runList(listCmd, nil); err != nil {
+		t.Fatalf("runList failed: %v", err)
+	}
+	outputFormat, documentOut = savedFormat, savedOut
+
+	var doc packageListDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, out.String())
+	}
+	notes := map[string]string{}
+	for _, pkg := range doc.Packages {
+		notes[pkg.Name] = pkg.Note
+	}
+	if notes["jq"] != "デプロイと CI で使う" || notes["fd"] != "ファイル検索" || notes["bat"] != "" {
+		t.Errorf("Unexpected notes: %v", notes)
+	}
+
+	// 空文字列でメモを削除する
+	if err := runNote(noteCmd, []string{"fd", ""}); err != nil {
+		t.Fatalf("runNote failed: %v", err)
+	}
+	content, err = os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if !strings.Contains(string(content), "    fd\n") || !strings.Contains(string(content), "    jq # デプロイと CI で使う\n") {
+		t.Errorf("Unexpected content after removing the note:\n%s", content)
+	}
+}
+
+// TestNoteRejectsInvalidInput tests missing packages and notes that can't be a comment
+func TestNoteRejectsInvalidInput(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	setupCommandTest(t, cfg)
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	if err := runNote(noteCmd, []string{"ripgrep", "検索"}); err == nil {
+		t.Error("runNote should fail for a package that is not installed")
+	}
+	if err := runNote(noteCmd, []string{"fd", "1行目\n2行目"}); err == nil {
+		t.Error("runNote should fail for a note with a newline")
+	}
+
+	saved := installNote
+	installNote = "*/"
+	t.Cleanup(func() { installNote = saved })
+	if err := runInstall(installCmd, []string{"jq"}); err == nil {
+		t.Error("runInstall should fail for a note that can't be a comment")
+	}
+
+	if after, _ := os.ReadFile(cfg.PackagesFilePath); string(after) != string(before) {
+		t.Errorf("Packages file should not change:\n%s", after)
+	}
+}
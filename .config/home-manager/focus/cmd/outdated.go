@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"focus/internal/config"
+	"focus/internal/nix"
+)
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "新しいバージョンがあるパッケージを表示する",
+	Long: `focus で管理しているパッケージについて、現在ロックされている nixpkgs と
+新しい nixpkgs のバージョンを比較し、新しいバージョンがあるものを表示します。
+何も変更しないので、'focus update' を実行する価値があるかの確認に使えます。
+
+比較先は既定では flake.nix に書かれた nixpkgs の参照（nix flake update で更新される先）です。
+--against には flake の入力名（ロック済みのリビジョンを使う）かフレーク参照を指定できます。
+設定ファイルの outdated_against で既定の比較先を変えられます。
+
+例:
+ focus outdated
+ focus outdated --against nixpkgs-stable
+ focus outdated --against github:NixOS/nixpkgs/nixos-unstable --output json`,
+	Args: cobra.NoArgs,
+	RunE: runOutdated,
+}
+
+var outdatedAgainst string
+
+func init() {
+	rootCmd.AddCommand(outdatedCmd)
+	outdatedCmd.Flags().StringVar(&outdatedAgainst, "against", "", "比較先の flake の入力名またはフレーク参照")
+}
+
+func runOutdated(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	attrPaths, err := installedAttrPaths(cfg)
+	if err != nil {
+		return err
+	}
+
+	against, err := outdatedCandidate(cfg)
+	if err != nil {
+		return err
+	}
+
+	nixClient := newNixClient(cfg)
+
+	fmt.Fprintf(messageOut, "現在の nixpkgs と %s を比較しています...\n", against)
+	current := lookupVersions(nixClient, attrPaths)
+	candidate := lookupVersions(nixClient.WithNixpkgs(against), attrPaths)
+
+	outdated := []versionChangeDocument{}
+	for _, pkg := range attrPaths {
+		oldVersion, newVersion := current[pkg], candidate[pkg]
+		if oldVersion == "unknown" || newVersion == "unknown" {
+			continue
+		}
+
+		if nix.CompareVersions(newVersion, oldVersion) > 0 {
+			outdated = append(outdated, versionChangeDocument{
+				Name: pkg,
+				Old:  oldVersion,
+				New:  newVersion,
+			})
+		}
+	}
+
+	if isMachineOutput() {
+		return renderDocument(outdatedDocument{
+			SchemaVersion: schemaVersion,
+			Kind:          "outdated",
+			Against:       against,
+			Packages:      outdated,
+		})
+	}
+
+	fmt.Fprintln(messageOut)
+	if len(outdated) == 0 {
+		fmt.Fprintln(messageOut, "全てのパッケージは最新です")
+		return nil
+	}
+
+	renderVersionTable(outdated, "最新")
+	fmt.Fprintf(messageOut, "\n%d 個のパッケージに新しいバージョンがあります。'focus update' で更新できます\n", len(outdated))
+
+	return nil
+}
+
+// outdatedCandidate は比較先のフレーク参照を返す
+// flake の入力名が指定された場合はそのロック済みのリビジョンを、それ以外はフレーク参照として使う
+func outdatedCandidate(cfg *config.Config) (string, error) {
+	against := outdatedAgainst
+	if against == "" {
+		against = cfg.OutdatedAgainst
+	}
+
+	if against != "" {
+		if cfg.UseFlake {
+			if locked, err := nix.ReadLockedInput(cfg.FlakePath, against); err == nil {
+				return locked.FlakeRef(), nil
+			}
+		}
+		return against, nil
+	}
+
+	if !cfg.UseFlake {
+		return "", fmt.Errorf("Flakeを使わない環境では --against で比較先を指定してください")
+	}
+
+	original, err := nix.ReadOriginalInput(cfg.FlakePath, "nixpkgs")
+	if err != nil {
+		return "", err
+	}
+
+	return original.FlakeRef(), nil
+}
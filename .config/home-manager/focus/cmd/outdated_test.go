@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"focus/internal/config"
+)
+
+// TestOutdatedJSON tests listing only packages with a newer version in the candidate
+func TestOutdatedJSON(t *testing.T) {
+	flakePath := t.TempDir()
+	lock, err := os.ReadFile(filepath.Join("..", "internal", "nix", "testdata", "flake.lock"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(flakePath, "flake.lock"), lock, 0644); err != nil {
+		t.Fatalf("Failed to write flake.lock: %v", err)
+	}
+
+	cfg := &config.Config{UseFlake: true, FlakePath: flakePath, FlakeConfig: "user"}
+	mock := setupCommandTest(t, cfg)
+	if err := os.WriteFile(cfg.PackagesFilePath, []byte("{ pkgs, ... }: {\n  home.packages = with pkgs; [\n    bat\n    fd\n    jq\n  ];\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write packages file: %v", err)
+	}
+
+	mock.PackageVersions = map[string]string{"bat": "0.24.0", "fd": "10.1.0", "jq": "1.7.1"}
+	mock.NixpkgsVersions = map[string]map[string]string{
+		"github:nixos/nixpkgs/nixos-unstable": {"bat": "0.24.0", "fd": "10.2.0", "jq": "1.7"},
+	}
+
+	var out bytes.Buffer
+	savedFormat, savedOut := outputFormat, documentOut
+	outputFormat, documentOut = outputJSON, &out
+	t.Cleanup(func() {
+		outputFormat, documentOut = savedFormat, savedOut
+	})
+
+	if err := runOutdated(outdatedCmd, nil); err != nil {
+		t.Fatalf("runOutdated failed: %v", err)
+	}
+
+	var doc outdatedDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse output: %v\n%s", err, out.String())
+	}
+
+	if doc.Kind != "outdated" || doc.Against != "github:nixos/nixpkgs/nixos-unstable" {
+		t.Errorf("Unexpected document header: %+v", doc)
+	}
+	if len(doc.Packages) != 1 || doc.Packages[0] != (versionChangeDocument{Name: "fd", Old: "10.1.0", New: "10.2.0"}) {
+		t.Errorf("Unexpected outdated packages: %+v", doc.Packages)
+	}
+}
+
+// TestOutdatedCandidate tests resolving the comparison target
+func TestOutdatedCandidate(t *testing.T) {
+	flakePath := filepath.Join("..", "internal", "nix", "testdata")
+	cfg := &config.Config{UseFlake: true, FlakePath: flakePath}
+
+	saved := outdatedAgainst
+	t.Cleanup(func() { outdatedAgainst = saved })
+
+	tests := []struct {
+		against string
+		want    string
+	}{
+		{"", "github:nixos/nixpkgs/nixos-unstable"},
+		{"home-manager", "github:nix-community/home-manager/" + mustLockedRev(t, flakePath, "home-manager")},
+		{"github:NixOS/nixpkgs/nixos-24.11", "github:NixOS/nixpkgs/nixos-24.11"},
+	}
+
+	for _, tt := range tests {
+		outdatedAgainst = tt.against
+		got, err := outdatedCandidate(cfg)
+		if err != nil {
+			t.Fatalf("outdatedCandidate(%q) failed: %v", tt.against, err)
+		}
+		if got != tt.want {
+			t.Errorf("outdatedCandidate(%q) = %q, want %q", tt.against, got, tt.want)
+		}
+	}
+
+	outdatedAgainst = ""
+	if _, err := outdatedCandidate(&config.Config{}); err == nil {
+		t.Error("outdatedCandidate should require --against without a flake")
+	}
+}
+
+func mustLockedRev(t *testing.T, flakePath, input string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(flakePath, "flake.lock"))
+	if err != nil {
+		t.Fatalf("Failed to read flake.lock: %v", err)
+	}
+
+	var lock struct {
+		Nodes map[string]struct {
+			Locked struct {
+				Rev string `json:"rev"`
+			} `json:"locked"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		t.Fatalf("Failed to parse flake.lock: %v", err)
+	}
+	return lock.Nodes[input].Locked.Rev
+}
@@ -0,0 +1,441 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"focus/internal/nix"
+)
+
+// 出力形式
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// schemaVersion は機械可読出力のスキーマのバージョン
+// フィールドの削除や意味の変更を行う場合にのみ上げる（追加では上げない）
+const schemaVersion = 1
+
+var outputFormat string
+
+// documentOut は機械可読ドキュメントの出力先
+var documentOut io.Writer = os.Stdout
+
+// messageOut は人が読む表示（進捗、プレビュー、確認など）と home-manager の出力の出力先
+// json/yaml 出力時は標準エラー出力にして、標準出力をドキュメント専用にする
+var messageOut io.Writer = os.Stdout
+
+// setupOutput は --output の値を検証し、表示の出力先を切り替える
+func setupOutput() error {
+	switch outputFormat {
+	case outputText:
+		messageOut = os.Stdout
+		return nil
+	case outputJSON, outputYAML:
+		messageOut = os.Stderr
+		return nil
+	}
+	return fmt.Errorf("未対応の出力形式です: %s (text, json, yaml のいずれかを指定してください)", outputFormat)
+}
+
+// isMachineOutput は json/yaml 出力かどうかを返す
+func isMachineOutput() bool {
+	return outputFormat == outputJSON || outputFormat == outputYAML
+}
+
+// renderDocument はドキュメントを --output の形式で書き出す
+func renderDocument(doc any) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("出力のシリアライズに失敗: %w", err)
+	}
+
+	if outputFormat == outputYAML {
+		yaml, err := jsonToYAML(data)
+		if err != nil {
+			return fmt.Errorf("出力のシリアライズに失敗: %w", err)
+		}
+		_, err = io.WriteString(documentOut, yaml)
+		return err
+	}
+
+	_, err = fmt.Fprintln(documentOut, string(data))
+	return err
+}
+
+// 以下は json/yaml 出力のスキーマ
+// 全てのドキュメントは schema_version と kind を持つ
+
+// packageListDocument は focus list の出力 (kind: "packages")
+type packageListDocument struct {
+	SchemaVersion int               `json:"schema_version"`
+	Kind          string            `json:"kind"`
+	Packages      []packageDocument `json:"packages"`
+}
+
+// groupListDocument は focus group list の出力 (kind: "groups")
+type groupListDocument struct {
+	SchemaVersion int             `json:"schema_version"`
+	Kind          string          `json:"kind"`
+	Groups        []groupDocument `json:"groups"`
+}
+
+// groupDocument はグループ1件
+type groupDocument struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Enabled  bool     `json:"enabled"`
+	Packages []string `json:"packages"`
+}
+
+// packageDocument はインストール済みパッケージ1件
+// expression は (callPackage ./x {}) のような式のエントリで true になり、version は空になる
+type packageDocument struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Expression bool   `json:"expression"`
+	// Group はパッケージを含むグループ（focus-packages.nix なら省略）
+	Group string `json:"group,omitempty"`
+	// Disabled は無効なグループのパッケージ（インストールされていない）
+	Disabled bool `json:"disabled,omitempty"`
+	// Condition はインストールする条件（例: "darwin", "host=work-laptop"。条件がなければ省略）
+	Condition string `json:"condition,omitempty"`
+	// Channel はパッケージを取得する nixpkgs のチャンネル（既定の nixpkgs なら省略）
+	Channel string `json:"channel,omitempty"`
+	// PinnedRevision は focus pin で固定した nixpkgs のコミット（固定していなければ省略）
+	PinnedRevision string `json:"pinned_revision,omitempty"`
+	// Note は要素の行末のコメントに書いたメモ（なければ省略）
+	Note string `json:"note,omitempty"`
+	// ClosureSize と UniqueSize は --size 指定時のサイズ（バイト）
+	ClosureSize *int64 `json:"closure_size,omitempty"`
+	UniqueSize  *int64 `json:"unique_size,omitempty"`
+}
+
+// searchDocument は focus search の出力 (kind: "search")
+type searchDocument struct {
+	SchemaVersion int                    `json:"schema_version"`
+	Kind          string                 `json:"kind"`
+	Query         string                 `json:"query"`
+	Results       []searchResultDocument `json:"results"`
+}
+
+// searchResultDocument は検索結果1件。name は legacyPackages.<system>. を除いた属性パス
+type searchResultDocument struct {
+	Name        string `json:"name"`
+	Pname       string `json:"pname"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// historyDocument は focus history の出力 (kind: "history")
+type historyDocument struct {
+	SchemaVersion int                    `json:"schema_version"`
+	Kind          string                 `json:"kind"`
+	Entries       []historyEntryDocument `json:"entries"`
+}
+
+// historyEntryDocument は履歴1件。timestamp は RFC 3339、result は "success" か "failed"
+type historyEntryDocument struct {
+	ID        int      `json:"id"`
+	Timestamp string   `json:"timestamp"`
+	Command   string   `json:"command"`
+	FilePath  string   `json:"file_path"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Result    string   `json:"result"`
+}
+
+// outdatedDocument は focus outdated の出力 (kind: "outdated")
+// against は比較先のフレーク参照で、packages には新しいバージョンがあるものだけが入る
+type outdatedDocument struct {
+	SchemaVersion int                     `json:"schema_version"`
+	Kind          string                  `json:"kind"`
+	Against       string                  `json:"against"`
+	Packages      []versionChangeDocument `json:"packages"`
+}
+
+// infoDocument は focus info の出力 (kind: "info")
+// installed_by は "focus", "home.nix", "both", "none" のいずれか
+// position は nixpkgs 内の定義位置（ストアパスは除く）
+type infoDocument struct {
+	SchemaVersion        int      `json:"schema_version"`
+	Kind                 string   `json:"kind"`
+	Attribute            string   `json:"attribute"`
+	Name                 string   `json:"name"`
+	Pname                string   `json:"pname"`
+	Version              string   `json:"version"`
+	Description          string   `json:"description"`
+	Homepage             string   `json:"homepage"`
+	Licenses             []string `json:"licenses"`
+	Maintainers          []string `json:"maintainers"`
+	Platforms            []string `json:"platforms"`
+	MainProgram          string   `json:"main_program"`
+	Broken               bool     `json:"broken"`
+	Insecure             bool     `json:"insecure"`
+	KnownVulnerabilities []string `json:"known_vulnerabilities"`
+	Outputs              []string `json:"outputs"`
+	Position             string   `json:"position"`
+	InstalledBy          string   `json:"installed_by"`
+	// Channel は評価した nixpkgs のチャンネル（stable でインストールしていれば stable）
+	Channel string `json:"channel"`
+}
+
+// 変更系コマンドの結果
+const (
+	statusSuccess   = "success"
+	statusFailed    = "failed"
+	statusCancelled = "cancelled"
+	statusDryRun    = "dry_run"
+	statusUnchanged = "unchanged"
+)
+
+// commandResultDocument は install/uninstall/rollback/update/pin などの結果 (kind: "result")
+// status は success, failed, cancelled, dry_run, unchanged のいずれか
+type commandResultDocument struct {
+	SchemaVersion int      `json:"schema_version"`
+	Kind          string   `json:"kind"`
+	Command       string   `json:"command"`
+	Status        string   `json:"status"`
+	Added         []string `json:"added"`
+	Removed       []string `json:"removed"`
+	// Condition は --only/--host で指定したインストールの条件（なければ省略）
+	Condition string `json:"condition,omitempty"`
+	// Channel は --channel stable で指定したチャンネル（既定の nixpkgs なら省略）
+	Channel string `json:"channel,omitempty"`
+	// Note は install --note や focus note で書いたメモ（なければ省略）
+	Note string `json:"note,omitempty"`
+	// Revision は focus pin で固定する nixpkgs のコミット（pin 以外では省略）
+	Revision string `json:"revision,omitempty"`
+	// Updates は focus update/pin/unpin で変わるバージョン（それ以外では省略）
+	Updates []versionChangeDocument `json:"updates,omitempty"`
+	// Closure は switch 前のビルドで求めたクロージャの差分（ビルドしていなければ省略）
+	Closure []closureChangeDocument `json:"closure,omitempty"`
+	// Sizes は追加するパッケージのクロージャのサイズ（求めていなければ省略）
+	Sizes []packageSizeDocument `json:"sizes,omitempty"`
+	Error string                `json:"error,omitempty"`
+}
+
+// closureChangeDocument はクロージャの差分1件。old/new が空ならその世代に含まれない
+// size_delta はバイト単位
+type closureChangeDocument struct {
+	Name      string   `json:"name"`
+	Old       []string `json:"old"`
+	New       []string `json:"new"`
+	SizeDelta int64    `json:"size_delta"`
+}
+
+// packageSizeDocument はパッケージのクロージャのサイズ（バイト）
+// unique_size はそのパッケージだけが必要とするサイズで、求められなければ null
+type packageSizeDocument struct {
+	Name        string `json:"name"`
+	ClosureSize int64  `json:"closure_size"`
+	UniqueSize  *int64 `json:"unique_size"`
+}
+
+func newPackageSizeDocument(name string, size nix.PackageSize) packageSizeDocument {
+	doc := packageSizeDocument{Name: name, ClosureSize: size.Closure}
+	if size.Unique >= 0 {
+		doc.UniqueSize = &size.Unique
+	}
+	return doc
+}
+
+// versionChangeDocument はパッケージの現在のバージョンと、更新後（または比較先）のバージョン
+type versionChangeDocument struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// commandResult は変更系コマンドの結果を集め、終了時に出力する
+type commandResult struct {
+	doc commandResultDocument
+}
+
+func newCommandResult(cmd *cobra.Command, args []string) *commandResult {
+	return &commandResult{
+		doc: commandResultDocument{
+			SchemaVersion: schemaVersion,
+			Kind:          "result",
+			Command:       strings.TrimSpace(cmd.CommandPath() + " " + strings.Join(args, " ")),
+			Status:        statusUnchanged,
+			Added:         []string{},
+			Removed:       []string{},
+		},
+	}
+}
+
+// finish は defer で呼び出し、json/yaml 出力時に結果を書き出す
+func (r *commandResult) finish(errp *error) {
+	if !isMachineOutput() {
+		return
+	}
+
+	if *errp != nil {
+		r.doc.Status = statusFailed
+		r.doc.Error = (*errp).Error()
+	}
+
+	if err := renderDocument(r.doc); err != nil && *errp == nil {
+		*errp = err
+	}
+}
+
+// nonNil は nil のスライスを空のスライスにする（JSON で null ではなく [] を出すため）
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// jsonToYAML は JSON をフィールド順を保ったまま YAML に変換する
+func jsonToYAML(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	value, err := decodeOrdered(dec)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	writeYAML(&builder, value, 0, true)
+	return builder.String(), nil
+}
+
+// orderedField はキーの順序を保持したオブジェクトのフィールド
+type orderedField struct {
+	key   string
+	value any
+}
+
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			fields := []orderedField{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, orderedField{key: keyTok.(string), value: value})
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return fields, nil
+		case '[':
+			items := []any{}
+			for dec.More() {
+				value, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, value)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return items, nil
+		}
+	}
+
+	return tok, nil
+}
+
+// writeYAML は value を書き出す
+// inline はドキュメントの先頭や "- " の直後など、値を現在の行から書き始める場合に true
+func writeYAML(b *strings.Builder, value any, indent int, inline bool) {
+	pad := strings.Repeat("  ", indent)
+	sep := " "
+	if inline {
+		sep = ""
+	}
+
+	switch v := value.(type) {
+	case []orderedField:
+		if len(v) == 0 {
+			b.WriteString(sep + "{}\n")
+			return
+		}
+		if !inline {
+			b.WriteString("\n")
+		}
+		for i, field := range v {
+			if !(inline && i == 0) {
+				b.WriteString(pad)
+			}
+			b.WriteString(yamlScalar(field.key) + ":")
+			writeYAML(b, field.value, indent+1, false)
+		}
+	case []any:
+		if len(v) == 0 {
+			b.WriteString(sep + "[]\n")
+			return
+		}
+		b.WriteString("\n")
+		for _, item := range v {
+			b.WriteString(pad + "- ")
+			writeYAML(b, item, indent+1, true)
+		}
+	default:
+		b.WriteString(sep + yamlValue(v) + "\n")
+	}
+}
+
+func yamlValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlScalar(v)
+	}
+	return fmt.Sprint(value)
+}
+
+var (
+	plainScalarPattern     = regexp.MustCompile(`^[A-Za-z0-9_./+()][A-Za-z0-9_ ./+()@,-]*$`)
+	ambiguousScalarPattern = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|null|~|y|n)$|^[-+]?[0-9._]+([eE][-+]?[0-9]+)?$|^[-+]?\.(?i:inf|nan)$|^0[xXoObB][0-9A-Fa-f_]+$|^[0-9]{4}-[0-9]{2}-[0-9]{2}`)
+)
+
+// yamlScalar は文字列を YAML のスカラーとして書き出す
+// 曖昧になりうるものは JSON 形式（YAML の二重引用符スカラーとしても有効）で引用する
+func yamlScalar(s string) string {
+	if plainScalarPattern.MatchString(s) && !ambiguousScalarPattern.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+
+	// & < > は \u0026 などにせずそのまま書く
+	var quoted bytes.Buffer
+	enc := json.NewEncoder(&quoted)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(quoted.String(), "\n")
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// TestJSONToYAML tests converting a document while keeping field order
+func TestJSONToYAML(t *testing.T) {
+	doc := packageListDocument{
+		SchemaVersion: schemaVersion,
+		Kind:          "packages",
+		Packages: []packageDocument{
+			{Name: "ripgrep", Version: "14.1.1"},
+			{Name: "(callPackage ./x {})", Expression: true},
+		},
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	got, err := jsonToYAML(data)
+	if err != nil {
+		t.Fatalf("jsonToYAML failed: %v", err)
+	}
+
+	want := `schema_version: 1
+kind: packages
+packages:
+  - name: ripgrep
+    version: "14.1.1"
+    expression: false
+  - name: "(callPackage ./x {})"
+    version: ""
+    expression: true
+`
+	if got != want {
+		t.Errorf("YAML mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestJSONToYAMLEmptyAndNested tests empty collections and nested lists
+func TestJSONToYAMLEmptyAndNested(t *testing.T) {
+	got, err := jsonToYAML([]byte(`{"entries":[{"id":1,"added":["fd","jq"],"removed":[]}],"meta":{}}`))
+	if err != nil {
+		t.Fatalf("jsonToYAML failed: %v", err)
+	}
+
+	want := `entries:
+  - id: 1
+    added:
+      - fd
+      - jq
+    removed: []
+meta: {}
+`
+	if got != want {
+		t.Errorf("YAML mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestYAMLScalar tests quoting of ambiguous strings
+func TestYAMLScalar(t *testing.T) {
+	tests := map[string]string{
+		"ripgrep":               "ripgrep",
+		"python3Packages.black": "python3Packages.black",
+		"":                      `""`,
+		"true":                  `"true"`,
+		"no":                    `"no"`,
+		"1.0":                   `"1.0"`,
+		"2024-06-26":            `"2024-06-26"`,
+		"a: b":                  `"a: b"`,
+		"# comment":             `"# comment"`,
+		"日本語":                   `"日本語"`,
+		// 真偽値や null として読まれる語
+		"yes":  `"yes"`,
+		"Off":  `"Off"`,
+		"null": `"null"`,
+		"NULL": `"NULL"`,
+		"~":    `"~"`,
+		// 数値として読まれる文字列
+		"1e3":    `"1e3"`,
+		"-1.5":   `"-1.5"`,
+		"+1":     `"+1"`,
+		".5":     `".5"`,
+		"1_000":  `"1_000"`,
+		".inf":   `".inf"`,
+		"-.Inf":  `"-.Inf"`,
+		".NaN":   `".NaN"`,
+		"0x1F":   `"0x1F"`,
+		"0o17":   `"0o17"`,
+		"v1e3":   "v1e3",
+		"1.0-rc": "1.0-rc",
+		// 先頭が記号だとシーケンス、マッピング、コメントなどとして読まれる
+		"-":          `"-"`,
+		"- item":     `"- item"`,
+		"-flag":      `"-flag"`,
+		":key":       `":key"`,
+		"#tag":       `"#tag"`,
+		"?":          `"?"`,
+		"&anchor":    `"&anchor"`,
+		"*alias":     `"*alias"`,
+		"!tag":       `"!tag"`,
+		"|":          `"|"`,
+		">":          `">"`,
+		"@scope":     `"@scope"`,
+		"%percent":   `"%percent"`,
+		"'single'":   `"'single'"`,
+		"\"double\"": `"\"double\""`,
+		"[list]":     `"[list]"`,
+		"{map}":      `"{map}"`,
+		" leading":   `" leading"`,
+		"trailing ":  `"trailing "`,
+		// 途中のコメントやマッピングの区切り
+		"a #b":  `"a #b"`,
+		"a:b":   `"a:b"`,
+		"a, b":  "a, b",
+		"a-b c": "a-b c",
+		// 複数行や制御文字
+		"line1\nline2": `"line1\nline2"`,
+		"tab\there":    `"tab\there"`,
+		"cr\r":         `"cr\r"`,
+	}
+
+	for input, want := range tests {
+		if got := yamlScalar(input); got != want {
+			t.Errorf("yamlScalar(%q) = %s, want %s", input, got, want)
+		}
+	}
+}
+
+// TestJSONToYAMLQuotesValues tests that quoted values stay on one line inside a document
+func TestJSONToYAMLQuotesValues(t *testing.T) {
+	doc := commandResultDocument{
+		SchemaVersion: schemaVersion,
+		Kind:          "result",
+		Command:       "focus note jq",
+		Status:        statusSuccess,
+		Added:         []string{"yes", "-"},
+		Removed:       []string{},
+		Note:          "1行目\n# 2行目",
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	got, err := jsonToYAML(data)
+	if err != nil {
+		t.Fatalf("jsonToYAML failed: %v", err)
+	}
+
+	want := `schema_version: 1
+kind: result
+command: focus note jq
+status: success
+added:
+  - "yes"
+  - "-"
+removed: []
+note: "1行目\n# 2行目"
+`
+	if got != want {
+		t.Errorf("YAML mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestSetupOutput tests that machine output sends messages to stderr without replacing os.Stdout
+func TestSetupOutput(t *testing.T) {
+	savedFormat, savedMessageOut, savedStdout := outputFormat, messageOut, os.Stdout
+	t.Cleanup(func() { outputFormat, messageOut, os.Stdout = savedFormat, savedMessageOut, savedStdout })
+
+	for _, format := range []string{outputJSON, outputYAML} {
+		outputFormat = format
+		if err := setupOutput(); err != nil {
+			t.Fatalf("setupOutput(%s) failed: %v", format, err)
+		}
+		if messageOut != os.Stderr || documentOut != os.Stdout || os.Stdout != savedStdout {
+			t.Errorf("Unexpected writers for %s", format)
+		}
+	}
+
+	outputFormat = outputText
+	if err := setupOutput(); err != nil {
+		t.Fatalf("setupOutput(text) failed: %v", err)
+	}
+	if messageOut != os.Stdout {
+		t.Error("Text output should print messages to stdout")
+	}
+
+	outputFormat = "xml"
+	if err := setupOutput(); err == nil {
+		t.Error("setupOutput should reject unknown formats")
+	}
+}
@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+
+	"github.com/spf13/cobra"
+	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/nixast"
+	"focus/internal/nixfile"
+)
+
+var pinCmd = &cobra.Command{
+	Use:   "pin <package> --rev <commit>",
+	Short: "パッケージを nixpkgs の特定のリビジョンに固定する",
+	Long: `インストール済みのパッケージを nixpkgs の特定のコミットのものに固定します。
+他のパッケージは今までどおり nixpkgs の更新に追従します。
+
+固定したパッケージは focus-packages.nix と同じディレクトリの focus-pins.nix から
+focusPinned 引数で渡され、パッケージファイルの要素は focusPinned.<package> に書き換わります。
+focus-pins.nix は最初に固定したときに home.nix の imports に追加されます。
+固定したパッケージは focus outdated と focus update の対象になりません。
+
+例:
+ focus pin terraform --rev 5e4fbfb6b3de1aa2872b76d49fafc942626e2add
+ focus list
+ focus unpin terraform`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPin,
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin <package>",
+	Short: "パッケージの固定を解除する",
+	Long: `focus pin で固定したパッケージを、他のパッケージと同じ nixpkgs のものに戻します。
+
+例:
+ focus unpin terraform`,
+	Args: cobra.ExactArgs(1),
+	RunE: runUnpin,
+}
+
+// pinRevision は --rev で指定された nixpkgs のコミット
+var pinRevision string
+
+// revisionPattern は --rev に指定できるコミットハッシュ
+var revisionPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+func init() {
+	rootCmd.AddCommand(pinCmd)
+	rootCmd.AddCommand(unpinCmd)
+	pinCmd.Flags().StringVar(&pinRevision, "rev", "", "固定する nixpkgs のコミット")
+}
+
+// pinnedNixpkgsRef は rev の nixpkgs のフレーク参照を返す
+func pinnedNixpkgsRef(rev string) string {
+	return "github:NixOS/nixpkgs/" + rev
+}
+
+// shortRevision は表示用に短くしたコミットを返す
+func shortRevision(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+func runPin(cmd *cobra.Command, args []string) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
+
+	// 非対話環境では、ファイルを書き換えてビルドする前に失敗させる
+	if err := ensureCanConfirm(); err != nil {
+		return err
+	}
+
+	packageName := args[0]
+	if !nixast.IsAttrPath(packageName) {
+		return fmt.Errorf("'%s' は属性パスではないため固定できません", packageName)
+	}
+	if !revisionPattern.MatchString(pinRevision) {
+		return fmt.Errorf("--rev には nixpkgs のコミットハッシュを指定してください: '%s'", pinRevision)
+	}
+
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	current, pinned := cfg.Pins[packageName]
+	if pinned && current.Rev == pinRevision {
+		fmt.Fprintf(messageOut, "パッケージ '%s' は既に nixpkgs %s に固定されています\n", packageName, shortRevision(pinRevision))
+		return nil
+	}
+
+	// 固定し直す場合は要素が書き換わっているので、設定だけを変える
+	var file *packageFile
+	if !pinned {
+		file, err = findPackageFile(cfg, packageName)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return fmt.Errorf("パッケージ '%s' はインストールされていません", packageName)
+		}
+	}
+
+	nixClient := newNixClient(cfg)
+	pinnedClient := nixClient.WithNixpkgs(pinnedNixpkgsRef(pinRevision))
+
+	check, err := checkPackage(pinnedClient, packageName)
+	if err != nil {
+		return err
+	}
+	if !check.Installable() {
+		return fmt.Errorf("nixpkgs %s のパッケージ '%s' はインストールできません: %s", shortRevision(pinRevision), packageName, check.Reason())
+	}
+
+	version, _ := pinnedClient.GetPackageVersion(packageName)
+	oldVersion := current.Version
+	if !pinned {
+		oldVersion, _ = nixClient.GetPackageVersion(packageName)
+	}
+
+	if pinned {
+		fmt.Fprintf(messageOut, "\nパッケージ '%s' の固定を nixpkgs %s から %s に変更します\n", packageName, shortRevision(current.Rev), shortRevision(pinRevision))
+	} else {
+		fmt.Fprintf(messageOut, "\nパッケージ '%s' を nixpkgs %s に固定します（%s）\n", packageName, shortRevision(pinRevision), file.label())
+	}
+	fmt.Fprintf(messageOut, "  %s: %s → %s\n\n", packageName, oldVersion, version)
+
+	result.doc.Revision = pinRevision
+	result.doc.Updates = []versionChangeDocument{{Name: packageName, Old: oldVersion, New: version}}
+
+	if dryRun {
+		printDryRun(cfg, "nix store prefetch-file --unpack "+nixfile.NixpkgsTarballURL(pinRevision), buildCommandLine(cfg), diffClosuresCommandLine)
+		result.doc.Status = statusDryRun
+		return nil
+	}
+
+	fmt.Fprintf(messageOut, "nixpkgs %s を取得しています...\n", shortRevision(pinRevision))
+	hash, err := nixClient.PrefetchTarball(nixfile.NixpkgsTarballURL(pinRevision))
+	if err != nil {
+		return fmt.Errorf("nixpkgs %s の取得に失敗: %w", shortRevision(pinRevision), err)
+	}
+
+	pins := maps.Clone(cfg.Pins)
+	if pins == nil {
+		pins = make(map[string]config.Pin)
+	}
+	pins[packageName] = config.Pin{Rev: pinRevision, Hash: hash, Version: version}
+
+	var manager *nixfile.Manager
+	if file != nil {
+		manager = nixfile.NewManager(file.Path)
+	}
+
+	switched, err := switchPins(cmd, args, result, cfg, nixClient, pins, manager, func() error {
+		return manager.PinPackage(packageName)
+	})
+	if err != nil || !switched {
+		return err
+	}
+
+	fmt.Fprintf(messageOut, "\n☑️ パッケージ '%s' を nixpkgs %s に固定しました\n", packageName, shortRevision(pinRevision))
+	return nil
+}
+
+func runUnpin(cmd *cobra.Command, args []string) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
+
+	// 非対話環境では、ファイルを書き換えてビルドする前に失敗させる
+	if err := ensureCanConfirm(); err != nil {
+		return err
+	}
+
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	packageName := args[0]
+	pin, ok := cfg.Pins[packageName]
+	if !ok {
+		return fmt.Errorf("パッケージ '%s' は固定されていません", packageName)
+	}
+
+	// 要素を手で消していれば、固定の設定だけを取り除く
+	file, err := findPackageFile(cfg, nixfile.PinnedEntry(packageName))
+	if err != nil {
+		return err
+	}
+
+	nixClient := newNixClient(cfg)
+	version, _ := nixClient.GetPackageVersion(packageName)
+
+	fmt.Fprintf(messageOut, "\nパッケージ '%s' の nixpkgs %s への固定を解除します\n", packageName, shortRevision(pin.Rev))
+	fmt.Fprintf(messageOut, "  %s: %s → %s\n\n", packageName, pin.Version, version)
+
+	result.doc.Updates = []versionChangeDocument{{Name: packageName, Old: pin.Version, New: version}}
+
+	if dryRun {
+		printDryRun(cfg, buildCommandLine(cfg), diffClosuresCommandLine)
+		result.doc.Status = statusDryRun
+		return nil
+	}
+
+	pins := maps.Clone(cfg.Pins)
+	delete(pins, packageName)
+
+	var manager *nixfile.Manager
+	if file != nil {
+		manager = nixfile.NewManager(file.Path)
+	}
+
+	switched, err := switchPins(cmd, args, result, cfg, nixClient, pins, manager, func() error {
+		return manager.UnpinPackage(packageName)
+	})
+	if err != nil || !switched {
+		return err
+	}
+
+	fmt.Fprintf(messageOut, "\n☑️ パッケージ '%s' の固定を解除しました\n", packageName)
+	return nil
+}
+
+// switchPins は固定の設定を pins にし、manager が nil でなければ rewrite で要素を書き換えてから、
+// ビルドして確認し switch する。switch したかを返す
+// ビルドや switch に失敗した場合、確認でキャンセルした場合は設定とパッケージファイルを元に戻す
+func switchPins(cmd *cobra.Command, args []string, result *commandResult, cfg *config.Config, nixClient nix.NixClient, pins map[string]config.Pin, manager *nixfile.Manager, rewrite func() error) (bool, error) {
+	previous := cfg.Pins
+	configFile := getConfigPath()
+
+	setPins := func(pins map[string]config.Pin) error {
+		cfg.Pins = pins
+		if err := config.Save(configFile, cfg); err != nil {
+			return fmt.Errorf("設定ファイルの保存に失敗: %w", err)
+		}
+		return writePinsModule(cfg)
+	}
+
+	// home.nix の import と focus-pins.nix は変更前の内容（なければ削除）に戻す
+	snapshots, err := takeSnapshots(cfg.HomeNixPath, cfg.PinsModulePath())
+	if err != nil {
+		return false, err
+	}
+
+	rewritten := false
+	revert := func() error {
+		if rewritten {
+			if err := revertPackagesFile(cfg, manager); err != nil {
+				return err
+			}
+		}
+		if err := setPins(previous); err != nil {
+			return fmt.Errorf("固定の設定の復元に失敗: %w", err)
+		}
+		restoreSnapshots(cfg, snapshots)
+		fmt.Fprintln(messageOut, "固定の設定を元に戻しました")
+		return nil
+	}
+	// fail は元に戻してから err を返す
+	fail := func(err error) (bool, error) {
+		if revertErr := revert(); revertErr != nil {
+			return false, fmt.Errorf("元の状態への復元にも失敗しました: %w\n元のエラー: %v", revertErr, err)
+		}
+		return false, err
+	}
+
+	var before []byte
+	if manager != nil {
+		if before, err = manager.Snapshot(); err != nil {
+			return false, err
+		}
+	}
+
+	if err := setPins(pins); err != nil {
+		return fail(err)
+	}
+
+	if err := addImportToHomeNix(cfg.HomeNixPath, cfg.PinsModulePath()); err != nil {
+		return fail(fmt.Errorf("home.nixへのimport追加に失敗: %w", err))
+	}
+
+	if manager != nil {
+		if err := rewrite(); err != nil {
+			return fail(fmt.Errorf("パッケージファイルの書き換えに失敗: %w", err))
+		}
+		rewritten = true
+
+		if err := gitAddFile(cfg, manager.Path()); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+		}
+	}
+
+	if err := buildAndPreview(nixClient, cfg, result, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", err)
+		return fail(fmt.Errorf("home-manager build に失敗しました"))
+	}
+
+	ok, err := confirm("この内容で switch しますか？")
+	if err != nil {
+		return fail(err)
+	}
+
+	if !ok {
+		if err := revert(); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(messageOut, "キャンセルしました")
+		result.doc.Status = statusCancelled
+		return false, nil
+	}
+
+	fmt.Fprintln(messageOut, "\nhome-manager switch を実行しています...")
+
+	switchErr := nixClient.Apply(cfg)
+
+	if manager != nil {
+		if after, err := manager.Snapshot(); err == nil {
+			recordHistory(cmd, args, manager, before, after, switchErr)
+		}
+	}
+
+	if switchErr != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", switchErr)
+		return fail(fmt.Errorf("home-manager switch に失敗しました"))
+	}
+
+	result.doc.Status = statusSuccess
+	return true, nil
+}
+
+// writePinsModule は固定したパッケージを渡すモジュールを書き直す
+func writePinsModule(cfg *config.Config) error {
+	pins := make([]nixfile.Pin, 0, len(cfg.Pins))
+	for _, name := range cfg.PinNames() {
+		pin := cfg.Pins[name]
+		pins = append(pins, nixfile.Pin{Name: name, Rev: pin.Rev, Hash: pin.Hash})
+	}
+
+	if err := nixfile.WritePinsModule(cfg.PinsModulePath(), pins); err != nil {
+		return err
+	}
+
+	if err := gitAddFile(cfg, cfg.PinsModulePath()); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+	}
+
+	return nil
+}
+
+// pinnedPackage は要素が固定したパッケージなら、元の属性パスと固定の設定を返す
+func pinnedPackage(cfg *config.Config, entry string) (string, config.Pin, bool) {
+	name, ok := nixfile.PinnedName(entry)
+	if !ok {
+		return "", config.Pin{}, false
+	}
+	pin, ok := cfg.Pins[name]
+	return name, pin, ok
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"focus/internal/config"
+)
+
+const testRevision = "5e4fbfb6b3de1aa2872b76d49fafc942626e2add"
+
+// setupPinTest は setupGroupTest に加えて --rev を差し替える
+func setupPinTest(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg, mock := setupGroupTest(t)
+	mock.NixpkgsVersions = map[string]map[string]string{
+		pinnedNixpkgsRef(testRevision): {"fd": "8.7.0"},
+	}
+
+	saved := pinRevision
+	pinRevision = testRevision
+	t.Cleanup(func() { pinRevision = saved })
+
+	return cfg
+}
+
+// TestPinAndUnpin tests rewriting the entry to the pinned nixpkgs and back
+func TestPinAndUnpin(t *testing.T) {
+	cfg := setupPinTest(t)
+
+	original, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	if err := runPin(pinCmd, []string{"fd"}); err != nil {
+		t.Fatalf("runPin failed: %v", err)
+	}
+
+	content, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if !strings.Contains(string(content), "{ pkgs, focusPinned, ... }:") || !strings.Contains(string(content), "    focusPinned.fd\n") {
+		t.Errorf("Entry was not rewritten:\n%s", content)
+	}
+
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	pin := loaded.Pins["fd"]
+	if pin.Rev != testRevision || pin.Hash == "" || pin.Version != "8.7.0" {
+		t.Errorf("Unexpected pin: %+v", pin)
+	}
+
+	module, err := os.ReadFile(cfg.PinsModulePath())
+	if err != nil {
+		t.Fatalf("Failed to read pins module: %v", err)
+	}
+	if !strings.Contains(string(module), testRevision) || !strings.Contains(string(module), pin.Hash) {
+		t.Errorf("Pins module does not reference the revision:\n%s", module)
+	}
+
+	homeNix, err := os.ReadFile(cfg.HomeNixPath)
+	if err != nil {
+		t.Fatalf("Failed to read home.nix: %v", err)
+	}
+	if !strings.Contains(string(homeNix), "./focus-pins.nix") {
+		t.Errorf("Pins module was not imported:\n%s", homeNix)
+	}
+
+	// 固定したパッケージは outdated/update の対象にならない
+	attrPaths, err := installedAttrPaths(loaded)
+	if err != nil {
+		t.Fatalf("installedAttrPaths failed: %v", err)
+	}
+	if len(attrPaths) != 0 {
+		t.Errorf("Pinned package should be excluded: %v", attrPaths)
+	}
+
+	savedFormat, savedOut := outputFormat, documentOut
+	var out bytes.Buffer
+	outputFormat, documentOut = outputJSON, &out
+	if err := runList(listCmd, nil); err != nil {
+		t.Fatalf("runList failed: %v", err)
+	}
+	outputFormat, documentOut = savedFormat, savedOut
+
+	var doc packageListDocument
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, out.String())
+	}
+	if len(doc.Packages) != 1 || doc.Packages[0].Name != "fd" || doc.Packages[0].Version != "8.7.0" || doc.Packages[0].PinnedRevision != testRevision {
+		t.Errorf("Unexpected packages: %+v", doc.Packages)
+	}
+
+	// 固定したパッケージは install で重複させない
+	if err := runInstall(installCmd, []string{"fd"}); err != nil {
+		t.Fatalf("runInstall failed: %v", err)
+	}
+	if after, _ := os.ReadFile(cfg.PackagesFilePath); string(after) != string(content) {
+		t.Errorf("Install should not add a pinned package again:\n%s", after)
+	}
+
+	if err := runUnpin(unpinCmd, []string{"fd"}); err != nil {
+		t.Fatalf("runUnpin failed: %v", err)
+	}
+
+	content, err = os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	// 追加した引数は残す
+	want := strings.Replace(string(original), "{ pkgs, ... }:", "{ pkgs, focusPinned, ... }:", 1)
+	if string(content) != want {
+		t.Errorf("Unexpected content after unpin\ngot:\n%s\nwant:\n%s", content, want)
+	}
+
+	loaded, err = config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(loaded.Pins) != 0 {
+		t.Errorf("Pin was not removed: %+v", loaded.Pins)
+	}
+}
+
+// TestPinKeepsTildePaths tests that saving the pin keeps ~ in the config file
+func TestPinKeepsTildePaths(t *testing.T) {
+	cfg := setupPinTest(t)
+	useTildePaths(t, cfg)
+
+	if err := runPin(pinCmd, []string{"fd"}); err != nil {
+		t.Fatalf("runPin failed: %v", err)
+	}
+	assertTildePaths(t, cfg)
+
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.Pins["fd"].Rev != testRevision {
+		t.Errorf("Pin was not saved: %+v", loaded.Pins)
+	}
+}
+
+// TestPinRevertsOnApplyFailure tests that a failed switch restores the file, the config and home.nix
+func TestPinRevertsOnApplyFailure(t *testing.T) {
+	cfg, mock := setupGroupTest(t)
+	mock.ShouldApplyFail = true
+
+	saved := pinRevision
+	pinRevision = testRevision
+	t.Cleanup(func() { pinRevision = saved })
+
+	original, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	homeNix, err := os.ReadFile(cfg.HomeNixPath)
+	if err != nil {
+		t.Fatalf("Failed to read home.nix: %v", err)
+	}
+
+	if err := runPin(pinCmd, []string{"fd"}); err == nil {
+		t.Fatal("runPin should fail when switch fails")
+	}
+
+	content, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+	if string(content) != string(original) {
+		t.Errorf("Packages file was not restored:\n%s", content)
+	}
+
+	loaded, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(loaded.Pins) != 0 {
+		t.Errorf("Pin should not be saved: %+v", loaded.Pins)
+	}
+
+	// focus-pins.nix の import とモジュールも取り除く
+	if after, _ := os.ReadFile(cfg.HomeNixPath); string(after) != string(homeNix) {
+		t.Errorf("home.nix was not restored:\n%s", after)
+	}
+	if _, err := os.Stat(cfg.PinsModulePath()); !os.IsNotExist(err) {
+		t.Errorf("Pins module should be removed: %v", err)
+	}
+}
+
+// TestPinRejectsInvalidInput tests errors for bad revisions and packages that are not installed
+func TestPinRejectsInvalidInput(t *testing.T) {
+	setupPinTest(t)
+
+	pinRevision = "nixos-unstable"
+	if err := runPin(pinCmd, []string{"fd"}); err == nil || !strings.Contains(err.Error(), "--rev") {
+		t.Errorf("Expected revision error, got %v", err)
+	}
+
+	pinRevision = testRevision
+	if err := runPin(pinCmd, []string{"ripgrep"}); err == nil || !strings.Contains(err.Error(), "インストールされていません") {
+		t.Errorf("Expected not-installed error, got %v", err)
+	}
+
+	if err := runUnpin(unpinCmd, []string{"fd"}); err == nil || !strings.Contains(err.Error(), "固定されていません") {
+		t.Errorf("Expected not-pinned error, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/nixast"
+	"focus/internal/nixfile"
+)
+
+// buildAndPreview は switch の前に新しい設定をビルドし、現在の世代とのクロージャの差分を表示する
+// added を渡すと、追加するパッケージのクロージャのサイズも表示する
+// ビルドに失敗した場合は何も有効化せずにエラーを返す
+// 差分やサイズが求められない場合（初回の switch など）は警告だけを出して続ける
+func buildAndPreview(nixClient nix.NixClient, cfg *config.Config, result *commandResult, added []string) error {
+	fmt.Fprintln(messageOut, "\nhome-manager build を実行しています...")
+
+	newPath, err := nixClient.Build(cfg)
+	if err != nil {
+		return err
+	}
+
+	previewClosureDiff(nixClient, newPath, result)
+	previewPackageSizes(nixClient, newPath, added, result)
+
+	return nil
+}
+
+// previewClosureDiff は現在の世代と newPath のクロージャの差分を表示する
+func previewClosureDiff(nixClient nix.NixClient, newPath string, result *commandResult) {
+	currentPath, err := nixClient.CurrentGeneration()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "警告: %v（クロージャの差分は表示できません）\n", err)
+		return
+	}
+
+	changes, err := nixClient.DiffClosures(currentPath, newPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "警告: %v\n", err)
+		return
+	}
+
+	result.doc.Closure = make([]closureChangeDocument, 0, len(changes))
+	for _, change := range changes {
+		result.doc.Closure = append(result.doc.Closure, closureChangeDocument{
+			Name:      change.Name,
+			Old:       nonNil(change.OldVersions),
+			New:       nonNil(change.NewVersions),
+			SizeDelta: change.SizeDelta,
+		})
+	}
+
+	fmt.Fprintln(messageOut)
+	printClosureDiff(changes)
+	fmt.Fprintln(messageOut)
+}
+
+// previewPackageSizes はビルドした世代 newPath での、追加するパッケージのクロージャのサイズを表示する
+func previewPackageSizes(nixClient nix.NixClient, newPath string, added []string, result *commandResult) {
+	attrPaths := make([]string, 0, len(added))
+	for _, pkg := range added {
+		if nixast.IsAttrPath(pkg) {
+			attrPaths = append(attrPaths, pkg)
+		}
+	}
+	if len(attrPaths) == 0 {
+		return
+	}
+
+	sizes, err := nix.PackageSizes(nixClient, newPath, attrPaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "警告: %v（パッケージのサイズは表示できません）\n", err)
+		return
+	}
+
+	fmt.Fprintln(messageOut, "追加するパッケージのサイズ:")
+	for _, pkg := range attrPaths {
+		size, ok := sizes[pkg]
+		if !ok {
+			fmt.Fprintf(messageOut, "  %s: 不明\n", pkg)
+			continue
+		}
+
+		result.doc.Sizes = append(result.doc.Sizes, newPackageSizeDocument(pkg, size))
+		fmt.Fprintf(messageOut, "  %s: %s\n", pkg, formatPackageSize(size))
+	}
+	fmt.Fprintln(messageOut)
+}
+
+// formatPackageSize はクロージャのサイズと、そのパッケージだけが必要とするサイズを表示用にする
+func formatPackageSize(size nix.PackageSize) string {
+	if size.Unique < 0 {
+		return "クロージャ " + nix.FormatSize(size.Closure)
+	}
+	return fmt.Sprintf("クロージャ %s, 固有 %s", nix.FormatSize(size.Closure), nix.FormatSize(size.Unique))
+}
+
+// printClosureDiff はクロージャの差分と合計のサイズの増減を表示する
+func printClosureDiff(changes []nix.ClosureChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(messageOut, "クロージャに変更はありません")
+		return
+	}
+
+	fmt.Fprintln(messageOut, "クロージャの変更:")
+
+	var total int64
+	for _, change := range changes {
+		total += change.SizeDelta
+
+		var items []string
+		if change.OldVersions != nil || change.NewVersions != nil {
+			items = append(items, fmt.Sprintf("%s → %s", nix.FormatVersions(change.OldVersions), nix.FormatVersions(change.NewVersions)))
+		}
+		if change.SizeDelta != 0 {
+			items = append(items, nix.FormatSizeDelta(change.SizeDelta))
+		}
+		fmt.Fprintf(messageOut, "  %s: %s\n", change.Name, strings.Join(items, ", "))
+	}
+
+	fmt.Fprintf(messageOut, "合計: %s\n", nix.FormatSizeDelta(total))
+}
+
+// revertPackagesFile は switch せずに終わる場合にパッケージファイルを変更前に戻す
+func revertPackagesFile(cfg *config.Config, manager *nixfile.Manager) error {
+	name := filepath.Base(manager.Path())
+	if err := manager.Rollback(); err != nil {
+		return fmt.Errorf("%s の復元に失敗: %w", name, err)
+	}
+
+	// Flake環境ではステージした変更も戻しておく
+	if cfg.UseFlake {
+		if err := gitAddFile(cfg, manager.Path()); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+		}
+	}
+
+	fmt.Fprintf(messageOut, "%s を元に戻しました\n", name)
+	return nil
+}
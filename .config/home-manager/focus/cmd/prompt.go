@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+)
+
+// stdinReader は全ての対話入力で共有する（バッファした入力を取りこぼさないため）
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// stdinIsTerminal は標準入力が端末かどうかを返す（テストでは差し替える）
+var stdinIsTerminal = func() bool {
+	return isTerminal(os.Stdin)
+}
+
+// ensureInteractive は対話入力ができない場合にエラーを返す
+func ensureInteractive() error {
+	if !stdinIsTerminal() {
+		return fmt.Errorf("標準入力が端末ではないため確認できません\n非対話環境では --yes を指定してください")
+	}
+	return nil
+}
+
+// ensureCanConfirm は switch の前に確認できない（--yes がなく非対話の）場合にエラーを返す
+// --dry-run では確認しないので何もしない
+func ensureCanConfirm() error {
+	if assumeYes || dryRun {
+		return nil
+	}
+	return ensureInteractive()
+}
+
+// confirm は [y/N] の確認を行う
+// --yes が指定されていれば確認せずに true を返す
+func confirm(question string) (bool, error) {
+	if assumeYes {
+		fmt.Fprintf(messageOut, "%s [y/N]: y (--yes)\n", question)
+		return true, nil
+	}
+
+	if err := ensureInteractive(); err != nil {
+		return false, err
+	}
+
+	fmt.Fprintf(messageOut, "%s [y/N]: ", question)
+	answer, _ := stdinReader.ReadString('\n')
+
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y"), nil
+}
+
+// promptInput は既定値付きで1行入力を受け付ける
+// --yes が指定されていれば既定値を使う
+func promptInput(question, defaultValue string) (string, error) {
+	if assumeYes {
+		fmt.Fprintf(messageOut, "%s [%s]: %s (--yes)\n", question, defaultValue, defaultValue)
+		return defaultValue, nil
+	}
+
+	if err := ensureInteractive(); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(messageOut, "%s [%s]: ", question, defaultValue)
+	input, _ := stdinReader.ReadString('\n')
+	input = strings.TrimSpace(input)
+
+	if input == "" {
+		return defaultValue, nil
+	}
+	return input, nil
+}
+
+// choose は番号付きの選択肢から1つを選ばせ、その添字を返す
+// 何も入力されなかった場合は -1 を返す
+func choose(question string, options []string) (int, error) {
+	if err := ensureInteractive(); err != nil {
+		return -1, err
+	}
+
+	for i, option := range options {
+		fmt.Fprintf(messageOut, "  %d) %s\n", i+1, option)
+	}
+
+	for {
+		fmt.Fprintf(messageOut, "%s [1-%d, Enter でスキップ]: ", question, len(options))
+		input, readErr := stdinReader.ReadString('\n')
+		input = strings.TrimSpace(input)
+
+		if input == "" {
+			return -1, nil
+		}
+
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+
+		if readErr != nil {
+			return -1, nil
+		}
+
+		fmt.Fprintf(messageOut, "1 から %d の番号を入力してください\n", len(options))
+	}
+}
+
+// switchCommandLine は実行される home-manager switch のコマンドラインを返す
+func switchCommandLine(cfg *config.Config) string {
+	return "home-manager " + strings.Join(nix.SwitchArgs(cfg), " ")
+}
+
+// buildCommandLine は switch の前に実行される home-manager build のコマンドラインを返す
+func buildCommandLine(cfg *config.Config) string {
+	return "home-manager " + strings.Join(nix.BuildArgs(cfg), " ")
+}
+
+// diffClosuresCommandLine はビルド結果と現在の世代を比較するコマンドラインを返す
+const diffClosuresCommandLine = "nix store diff-closures <現在の世代> ./result"
+
+// printDryRun は --dry-run 時に実行されるはずだったコマンドを表示する
+// before には switch の前に実行されるコマンドを渡す
+func printDryRun(cfg *config.Config, before ...string) {
+	fmt.Fprintln(messageOut, "実行されるコマンド:")
+	for _, command := range before {
+		fmt.Fprintf(messageOut, "	%s\n", command)
+	}
+	fmt.Fprintf(messageOut, "	%s\n", switchCommandLine(cfg))
+	fmt.Fprintln(messageOut)
+	fmt.Fprintln(messageOut, "--dry-run のため、変更は書き込まれていません")
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"focus/internal/history"
+	"focus/internal/nixfile"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [id]",
+	Short: "focus-packages.nix を履歴の状態に戻す",
+	Long: `'focus history' で表示された ID の変更を適用した直後の状態に focus-packages.nix を戻し、
+home-manager switch を実行します。switch に失敗した場合は元の状態に戻します。
+
+例:
+ focus history
+ focus rollback 3`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRollback,
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+func runRollback(cmd *cobra.Command, args []string) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("IDは数値で指定してください: %s", args[0])
+	}
+
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	store, err := history.DefaultStore()
+	if err != nil {
+		return err
+	}
+
+	entry, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+
+	// 履歴はグループのファイルの変更のこともあるので、記録されたファイルを戻す
+	// 削除したグループなど、今は管理していないファイルの履歴は別のファイルに書き込まない
+	target := ""
+	for _, file := range packageFiles(cfg) {
+		if file.Path == entry.FilePath {
+			target = file.Path
+			break
+		}
+	}
+	if target == "" {
+		return fmt.Errorf("履歴 #%d は focus が管理していないファイル (%s) の変更のため戻せません（削除したグループのファイルかもしれません）", id, entry.FilePath)
+	}
+
+	manager := nixfile.NewManager(target)
+
+	before, err := manager.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	after := []byte(entry.After)
+	if string(before) == string(after) {
+		fmt.Fprintf(messageOut, "focus-packages.nix は既に履歴 #%d の状態です\n", id)
+		return nil
+	}
+
+	currentPackages, err := manager.PackagesIn(before)
+	if err != nil {
+		return err
+	}
+	targetPackages, err := manager.PackagesIn(after)
+	if err != nil {
+		return fmt.Errorf("履歴 #%d の内容を解析できません: %w", id, err)
+	}
+
+	added, removed := history.Delta(currentPackages, targetPackages)
+	result.doc.Added = nonNil(added)
+	result.doc.Removed = nonNil(removed)
+
+	fmt.Fprintf(messageOut, "履歴 #%d (%s %s) の状態に戻します\n", id, entry.Timestamp.Format("2006-01-02 15:04:05"), entry.Command)
+	fmt.Fprintln(messageOut, "\n変更内容:")
+	for _, pkg := range removed {
+		fmt.Fprintf(messageOut, "-	%s\n", pkg)
+	}
+	for _, pkg := range added {
+		fmt.Fprintf(messageOut, "+	%s\n", pkg)
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Fprintln(messageOut, "	(パッケージの増減はありません)")
+	}
+	fmt.Fprintln(messageOut)
+
+	if dryRun {
+		printDryRun(cfg)
+		result.doc.Status = statusDryRun
+		return nil
+	}
+
+	ok, err := confirm("続行しますか？")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		fmt.Fprintln(messageOut, "ロールバックをキャンセルしました")
+		result.doc.Status = statusCancelled
+		return nil
+	}
+
+	if err := manager.Restore(after); err != nil {
+		return fmt.Errorf("focus-packages.nix の復元に失敗: %w", err)
+	}
+
+	fmt.Fprintln(messageOut, "☑️ focus-packages.nix を復元しました")
+
+	// Flake環境の場合、git addを実行
+	if cfg.UseFlake {
+		if err := gitAddFile(cfg, manager.Path()); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
+		}
+	}
+
+	nixClient := newNixClient(cfg)
+
+	fmt.Fprintln(messageOut, "\nhome-manager switch を実行しています...")
+
+	switchErr := nixClient.Apply(cfg)
+
+	recordHistory(cmd, args, manager, before, after, switchErr)
+
+	if switchErr != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", switchErr)
+		fmt.Fprintln(messageOut, "元の状態に戻しています...")
+
+		if rollbackErr := manager.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("元の状態への復元にも失敗しました: %w\n元のエラー: %v", rollbackErr, switchErr)
+		}
+
+		fmt.Fprintln(messageOut, "☑️ 元の状態に戻しました")
+		return fmt.Errorf("home-manager switch に失敗しました")
+	}
+
+	fmt.Fprintf(messageOut, "\n☑️ 履歴 #%d の状態に戻しました\n", id)
+	result.doc.Status = statusSuccess
+
+	return nil
+}
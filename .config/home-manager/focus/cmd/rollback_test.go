@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"focus/internal/config"
+	"focus/internal/history"
+)
+
+// TestRollbackRejectsUnknownFile tests that history of a file focus no longer manages is not written elsewhere
+func TestRollbackRejectsUnknownFile(t *testing.T) {
+	cfg := &config.Config{HomeNixPath: "/nonexistent/home.nix"}
+	mock := setupCommandTest(t, cfg)
+
+	before, err := os.ReadFile(cfg.PackagesFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read packages file: %v", err)
+	}
+
+	// 削除したグループのファイルの履歴
+	store, err := history.DefaultStore()
+	if err != nil {
+		t.Fatalf("DefaultStore failed: %v", err)
+	}
+	entry := &history.Entry{
+		Command:  "focus install --group old jq",
+		FilePath: filepath.Join(t.TempDir(), "focus-groups", "old.nix"),
+		Added:    []string{"jq"},
+		Result:   history.ResultSuccess,
+		After:    "{ pkgs, ... }:\n{\n  home.packages = with pkgs; [\n    jq\n  ];\n}\n",
+	}
+	if err := store.Record(entry); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	if err := runRollback(rollbackCmd, []string{"1"}); err == nil {
+		t.Error("runRollback should fail for a file that is not managed")
+	}
+
+	if after, _ := os.ReadFile(cfg.PackagesFilePath); string(after) != string(before) {
+		t.Errorf("Packages file should not change:\n%s", after)
+	}
+	if len(mock.Applied) != 0 {
+		t.Errorf("Apply should not be called, got %+v", mock.Applied)
+	}
+}
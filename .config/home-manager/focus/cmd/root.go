@@ -7,10 +7,15 @@ import (
 
 	"github.com/spf13/cobra"
 	"focus/internal/config"
+	"focus/internal/nix"
+	"focus/internal/state"
 )
 
 var (
 	configPath string
+	assumeYes  bool
+	dryRun     bool
+	waitLock   bool
 )
 
 var rootCmd = &cobra.Command{
@@ -20,11 +25,34 @@ var rootCmd = &cobra.Command{
 
 e.g.
 	focus init		# 初期設定
-	focus install ripgrep	# パッケージインストール
+	focus install ripgrep fd	# パッケージインストール
 	focus list		# インストール済みパッケージ一覧
 	focus uninstall ripgrep	# パッケージ削除
 	focus search fzf	# パッケージ検索
-	focus update ripgrep	# パッケージ更新`,
+	focus update ripgrep	# パッケージ更新
+	focus outdated		# 新しいバージョンがあるパッケージ
+	focus info ripgrep	# パッケージの詳細
+	focus install --group dev jq	# グループに追加
+	focus group disable dev	# グループをまとめて無効化
+	focus pin terraform --rev <commit>	# nixpkgs のリビジョンに固定
+	focus install --channel stable terraform	# 安定版の nixpkgs から追加
+	focus note jq "デプロイで使う"	# パッケージにメモを書く
+	focus history		# 変更履歴
+	focus rollback 3	# 履歴の状態に戻す
+
+home-manager の出力はそのまま表示し、状態ディレクトリ（~/.local/state/focus）の logs にも保存します。
+失敗した場合は出力の末尾とログファイルのパスを表示します。
+
+非対話環境（スクリプトやCI）では --yes で確認を省略できます。
+--dry-run を付けると変更内容と実行するコマンドを表示するだけで、何も書き込みません。
+パッケージを変更するコマンドは実行中に他の focus を排他にし、別の focus の操作中は
+そのプロセスの pid を表示して終了します。--wait を付けると終わるまで待ちます。
+--output json|yaml を付けると list, group list, search, history と変更系コマンドの結果を
+機械可読な形式で標準出力に書き出します（それ以外の表示は標準エラー出力に出ます）。
+各ドキュメントは schema_version と kind (packages, groups, search, history, outdated, info, result) を持ちます。`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupOutput()
+	},
 }
 
 func Execute() error {
@@ -33,6 +61,10 @@ func Execute() error {
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "設定ファイルのパス")
+	rootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "確認をすべて承諾する（非対話モード）")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "変更内容と実行するコマンドを表示するだけで、何も書き込まない")
+	rootCmd.PersistentFlags().BoolVar(&waitLock, "wait", false, "別の focus の操作中なら終わるまで待つ")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputText, "出力形式 (text, json, yaml)")
 }
 
 func getConfigPath() string {
@@ -59,6 +91,11 @@ func getConfigPath() string {
 	return "./focus.toml"
 }
 
+// newNixClient は設定に応じたNixクライアントを作成する（テストではモックに差し替える）
+var newNixClient = func(cfg *config.Config) nix.NixClient {
+	return nix.NewClientForConfig(cfg, messageOut)
+}
+
 func loadConfig() (*config.Config, error) {
 	path := getConfigPath()
 
@@ -69,6 +106,25 @@ func loadConfig() (*config.Config, error) {
 	return config.Load(path)
 }
 
+// lockOperation は focus-packages.nix の読み込みから switch までを他の focus と排他にするロックを取得する
+// --dry-run では何も書き込まないのでロックしない（nil の Lock を返す）
+func lockOperation() (*state.Lock, error) {
+	if dryRun {
+		return nil, nil
+	}
+	if !state.LockSupported {
+		fmt.Fprintln(os.Stderr, "警告: この環境ではファイルロックが使えないため、focus の同時実行による変更の衝突を防げません")
+	}
+
+	return state.AcquireLock(waitLock, func(pid int) {
+		if pid == 0 {
+			fmt.Fprintln(os.Stderr, "別の focus の操作が終わるのを待っています...")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "別の focus の操作 (pid %d) が終わるのを待っています...\n", pid)
+	})
+}
+
 // gitAddFile はFlake環境の場合にファイルをgit addする
 func gitAddFile(cfg *config.Config, filePath string) error {
 	// Flakeを使っていない場合は何もしない
@@ -91,3 +147,17 @@ func gitAddFile(cfg *config.Config, filePath string) error {
 
 	return nil
 }
+
+// uniqueArgs は引数の重複を取り除く（順序は維持する）
+func uniqueArgs(args []string) []string {
+	seen := make(map[string]bool, len(args))
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		if seen[arg] {
+			continue
+		}
+		seen[arg] = true
+		result = append(result, arg)
+	}
+	return result
+}
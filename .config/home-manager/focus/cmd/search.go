@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"focus/internal/config"
 	"focus/internal/nix"
 )
 
@@ -14,7 +15,8 @@ var searchCmd = &cobra.Command{
 
 例:
  focus search ripgrep
- focus search editor`,
+ focus search editor
+ focus search ripgrep --output yaml`,
 	Args: cobra.ExactArgs(1),
 	RunE: runSearch,
 }
@@ -26,31 +28,62 @@ func init() {
 func runSearch(cmd *cobra.Command, args []string) error {
 	keyword := args[0]
 
+	// 設定があればFlakeのロック済み nixpkgs を検索する（init 前でも検索はできる）
 	nixClient := nix.NewClient()
+	if config.Exists(getConfigPath()) {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		nixClient = newNixClient(cfg)
+	}
 
-	fmt.Printf("'%s' を検索しています...\n\n", keyword)
+	fmt.Fprintf(messageOut, "'%s' を検索しています...\n\n", keyword)
 
 	results, err := nixClient.Search(keyword)
 	if err != nil {
 		return fmt.Errorf("検索に失敗: %w", err)
 	}
 
+	if isMachineOutput() {
+		docs := make([]searchResultDocument, 0, len(results))
+		for _, result := range results {
+			docs = append(docs, searchResultDocument{
+				Name:        result.Name,
+				Pname:       result.Pname,
+				Version:     result.Version,
+				Description: result.Description,
+			})
+		}
+
+		return renderDocument(searchDocument{
+			SchemaVersion: schemaVersion,
+			Kind:          "search",
+			Query:         keyword,
+			Results:       docs,
+		})
+	}
+
 	if len(results) == 0 {
-		fmt.Printf("'%s' に一致するパッケージが見つかりませんでした\n", keyword)
+		fmt.Fprintf(messageOut, "'%s' に一致するパッケージが見つかりませんでした\n", keyword)
 		return nil
 	}
 
-	fmt.Printf("検索結果 (%d件):\n\n", len(results))
+	fmt.Fprintf(messageOut, "検索結果 (%d件):\n\n", len(results))
 
 	for _, result := range results {
-		fmt.Printf("  %s\n", result.Name)
 		if result.Version != "" {
-			fmt.Printf("	バージョン: %s\n", result.Version)
+			fmt.Fprintf(messageOut, "  %s (%s)\n", result.Name, result.Version)
+		} else {
+			fmt.Fprintf(messageOut, "  %s\n", result.Name)
+		}
+		if result.Pname != "" && result.Pname != result.Name {
+			fmt.Fprintf(messageOut, "	pname: %s\n", result.Pname)
 		}
 		if result.Description != "" {
-			fmt.Printf("	説明: %s\n", result.Description)
+			fmt.Fprintf(messageOut, "	説明: %s\n", result.Description)
 		}
-		fmt.Println()
+		fmt.Fprintln(messageOut)
 	}
 
 	return nil
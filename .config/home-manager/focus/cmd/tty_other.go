@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package cmd
+
+import "os"
+
+// isTerminal は f が文字デバイスかどうかで端末を判定する
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
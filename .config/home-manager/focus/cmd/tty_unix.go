@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// isTerminal は f が端末かどうかを返す
+// /dev/null などの文字デバイスを除外するため、termios が取得できるかで判定する
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlReadTermios, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
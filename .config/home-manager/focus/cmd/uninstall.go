@@ -1,109 +1,192 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
-	"focus/internal/nix"
 	"focus/internal/nixfile"
 )
 
 var uninstallCmd = &cobra.Command{
-	Use:   "uninstall [package]",
+	Use:   "uninstall [package...]",
 	Short: "パッケージをアンインストールする",
 	Long: `指定されたパッケージを focus-packages.nix から削除し、
 home-manager switch を実行してアンインストールします。
+複数のパッケージを指定した場合は、1回の確認と1回の switch でまとめて削除します。
+グループのパッケージは --group でグループを指定して削除します。
+switch の前に home-manager build でビルドし、クロージャの差分（追加・削除されるパッケージ、
+バージョンの変化、サイズの増減）を表示してから確認します。ビルドに失敗した場合は何も有効化しません。
 
 例:
  focus uninstall ripgrep
- focus uninstall fzf`,
-	Args: cobra.ExactArgs(1),
+ focus uninstall ripgrep fd bat
+ focus uninstall --group dev ripgrep`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: runUninstall,
 }
 
 func init() {
 	rootCmd.AddCommand(uninstallCmd)
+	uninstallCmd.Flags().StringVar(&packageGroup, "group", "", "削除するパッケージのグループ")
 }
 
-func runUninstall(cmd *cobra.Command, args []string) error {
-	packageName := args[0]
+func runUninstall(cmd *cobra.Command, args []string) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
 
-	cfg, err := loadConfig()
+	// 非対話環境では、ファイルを書き換えてビルドする前に失敗させる
+	if err := ensureCanConfirm(); err != nil {
+		return err
+	}
+
+	lock, err := lockOperation()
 	if err != nil {
 		return err
 	}
+	defer lock.Release()
 
-	manager := nixfile.NewManager(cfg.PackagesFilePath)
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
 
-	hasPackage, err := manager.HasPackage(packageName)
+	target, err := existingGroupFile(cfg, packageGroup)
 	if err != nil {
-		return fmt.Errorf("パッケージチェックに失敗: %w", err)
+		return err
 	}
 
-	if !hasPackage {
-		fmt.Printf("パッケージ '%s' はインストールされていません\n", packageName)
+	manager := nixfile.NewManager(target.Path)
+
+	packageNames := make([]string, 0, len(args))
+	for _, packageName := range uniqueArgs(args) {
+		hasPackage, err := manager.HasPackage(packageName)
+		if err != nil {
+			return fmt.Errorf("パッケージチェックに失敗: %w", err)
+		}
+
+		if !hasPackage {
+			// --channel stable でインストールしたパッケージは stable.<name> の要素を削除する
+			entry := nixfile.ChannelEntry(nixfile.StableChannel, packageName)
+			if hasStable, err := manager.HasPackage(entry); err == nil && hasStable {
+				packageNames = append(packageNames, entry)
+				continue
+			}
+
+			if _, pinned := cfg.Pins[packageName]; pinned {
+				fmt.Fprintf(messageOut, "パッケージ '%s' は固定されています（先に focus unpin %s を実行してください）\n", packageName, packageName)
+				continue
+			}
+
+			// 別のグループにあれば --group の指定を促す
+			file, err := findPackageFile(cfg, packageName)
+			if err != nil {
+				return err
+			}
+			if file != nil && file.Group != "" {
+				fmt.Fprintf(messageOut, "パッケージ '%s' は%sにあります（--group %s を指定してください）\n", packageName, file.label(), file.Group)
+			} else {
+				fmt.Fprintf(messageOut, "パッケージ '%s' はインストールされていません\n", packageName)
+			}
+			continue
+		}
+
+		packageNames = append(packageNames, packageName)
+	}
+
+	if len(packageNames) == 0 {
 		return nil
 	}
 
-	diff, err := manager.GetDiff(packageName, false)
+	diff, err := manager.GetPackagesDiff(packageNames, false)
 	if err != nil {
 		return fmt.Errorf("diff の生成に失敗: %w", err)
 	}
 
-	fmt.Println("\n変更内容:")
-	fmt.Println(diff)
-	fmt.Println()
+	fmt.Fprintln(messageOut, "\n変更内容:")
+	fmt.Fprintln(messageOut, diff)
+	fmt.Fprintln(messageOut)
 
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("続行しますか？ [y/N]: ")
-	confirm, _ := reader.ReadString('\n')
+	result.doc.Removed = packageNames
 
-	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(confirm)), "y") {
-		fmt.Println("アンインストールをキャンセルしました")
+	if dryRun {
+		printDryRun(cfg, buildCommandLine(cfg), diffClosuresCommandLine)
+		result.doc.Status = statusDryRun
 		return nil
 	}
 
-	fmt.Printf("\nパッケージ '%s' を削除しています...\n", packageName)
-	if err := manager.RemovePackage(packageName); err != nil {
+	fmt.Fprintf(messageOut, "\nパッケージ '%s' を削除しています...\n", strings.Join(packageNames, "', '"))
+	before, err := manager.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err := manager.RemovePackages(packageNames); err != nil {
 		return fmt.Errorf("パッケージの削除に失敗: %w", err)
 	}
 
-	fmt.Println("☑️ focus-packages.nix から削除しました")
+	fmt.Fprintf(messageOut, "☑️ %s から削除しました\n", filepath.Base(target.Path))
 
 	// Flake環境の場合、git addを実行
 	if cfg.UseFlake {
-		if err := gitAddFile(cfg, cfg.PackagesFilePath); err != nil {
+		if err := gitAddFile(cfg, target.Path); err != nil {
 			fmt.Fprintf(os.Stderr, "警告: git addに失敗しました: %v\n", err)
 		}
 	}
 
-	nixClient := nix.NewClient()
+	nixClient := newNixClient(cfg)
 
-	fmt.Println("\nhome-manager switch を実行しています...")
+	// 有効化する前にビルドして、壊れた式や実際の変更内容を確認する
+	if err := buildAndPreview(nixClient, cfg, result, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", err)
+		if revertErr := revertPackagesFile(cfg, manager); revertErr != nil {
+			return revertErr
+		}
+		return fmt.Errorf("home-manager build に失敗しました")
+	}
 
-	var switchErr error
-	if cfg.UseFlake {
-		switchErr = nixClient.(*nix.Client).ApplyHomeManagerWithFlake(cfg.FlakePath, cfg.FlakeConfig)
-	} else {
-		switchErr = nixClient.ApplyHomeManager(cfg.HomeNixPath)
+	ok, err := confirm("この内容で switch しますか？")
+	if err != nil {
+		if revertErr := revertPackagesFile(cfg, manager); revertErr != nil {
+			return revertErr
+		}
+		return err
+	}
+
+	if !ok {
+		if err := revertPackagesFile(cfg, manager); err != nil {
+			return err
+		}
+		fmt.Fprintln(messageOut, "アンインストールをキャンセルしました")
+		result.doc.Status = statusCancelled
+		return nil
+	}
+
+	fmt.Fprintln(messageOut, "\nhome-manager switch を実行しています...")
+
+	switchErr := nixClient.Apply(cfg)
+
+	after, err := manager.Snapshot()
+	if err == nil {
+		recordHistory(cmd, args, manager, before, after, switchErr)
 	}
 
 	if switchErr != nil {
 		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", switchErr)
-		fmt.Println("ロールバックしています...")
+		fmt.Fprintln(messageOut, "ロールバックしています...")
 
 		if rollbackErr := manager.Rollback(); rollbackErr != nil {
 			return fmt.Errorf("ロールバックにも失敗しました: %w\n元のエラー: %v", rollbackErr, switchErr)
 		}
 
-		fmt.Println("☑️ ロールバックが完了しました")
+		fmt.Fprintln(messageOut, "☑️ ロールバックが完了しました")
 		return fmt.Errorf("home-manager switchに失敗しました")
 	}
 
-	fmt.Printf("\n☑️ パッケージ '%s' のアンインストールが完了しました\n", packageName)
+	fmt.Fprintf(messageOut, "\n☑️ パッケージ '%s' のアンインストールが完了しました\n", strings.Join(packageNames, "', '"))
+	result.doc.Status = statusSuccess
 
 	return nil
 }
@@ -2,24 +2,34 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
 
 	"github.com/spf13/cobra"
+	"focus/internal/config"
 	"focus/internal/nix"
-	"focus/internal/nixfile"
+	"focus/internal/safefile"
 )
 
 var updateCmd = &cobra.Command{
 	Use:   "update [package]",
 	Short: "パッケージを更新する",
-	Long: `パッケージを最新版に更新します。
-パッケージ名を指定しない場合は、全てのパッケージを更新します。
+	Long: `nixpkgs を更新し、パッケージを新しいバージョンにします。
 
-注意: Nixではパッケージのバージョンはnixpkgsのバージョンに依存します。
-このコマンドは home-manager switch を実行して更新を適用します。
+Flake環境では flake.lock の nixpkgs 入力を最新にしてから、まずビルドだけを行います。
+ビルドに成功するとクロージャの差分と、focus で管理している全パッケージの更新前後のバージョンを表示し、
+確認の後に home-manager switch を実行します。
+ビルドや switch に失敗した場合、確認でキャンセルした場合は flake.lock を元に戻します。
+
+Flakeを使わない環境では nixpkgs の更新（nix-channel --update など）は行わず、
+home-manager switch だけを実行します。
 
 例:
- focus update		# 全パッケージ更新
- focus update ripgrep	# ripgrepが含まれることを確認してから更新`,
+ focus update		# nixpkgs を更新して全パッケージを更新
+ focus update ripgrep	# ripgrepが含まれることを確認してから更新
+ focus update --dry-run	# 実行されるコマンドを表示する`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runUpdate,
 }
@@ -28,7 +38,21 @@ func init() {
 	rootCmd.AddCommand(updateCmd)
 }
 
-func runUpdate(cmd *cobra.Command, args []string) error {
+func runUpdate(cmd *cobra.Command, args []string) (err error) {
+	result := newCommandResult(cmd, args)
+	defer result.finish(&err)
+
+	// 非対話環境では、ファイルを書き換えてビルドする前に失敗させる
+	if err := ensureCanConfirm(); err != nil {
+		return err
+	}
+
+	lock, err := lockOperation()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
@@ -37,47 +61,209 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 	if len(args) > 0 {
 		packageName := args[0]
 
-		manager := nixfile.NewManager(cfg.PackagesFilePath)
-
-		hasPackage, err := manager.HasPackage(packageName)
+		file, err := findPackageFile(cfg, packageName)
 		if err != nil {
-			return fmt.Errorf("パッケージチェックに失敗: %w", err)
+			return err
 		}
 
-		if !hasPackage {
+		if file == nil {
 			return fmt.Errorf("パッケージ '%s' はインストールされていません", packageName)
 		}
 
-		fmt.Printf("パッケージ '%s' を含む全パッケージを更新します...\n\n", packageName)
+		fmt.Fprintf(messageOut, "パッケージ '%s' を含む全パッケージを更新します...\n\n", packageName)
 	} else {
-		fmt.Println("全パッケージを更新します...")
-		fmt.Println()
+		fmt.Fprintln(messageOut, "全パッケージを更新します...")
+		fmt.Fprintln(messageOut)
 	}
 
-	nixClient := nix.NewClient()
+	nixClient := newNixClient(cfg)
 
-	fmt.Println("home-manager switch を実行しています...")
+	if !cfg.UseFlake {
+		return switchWithoutUpdate(cfg, nixClient, result)
+	}
 
-	var switchErr error
-	if cfg.UseFlake {
-		switchErr = nixClient.(*nix.Client).ApplyHomeManagerWithFlake(cfg.FlakePath, cfg.FlakeConfig)
-	} else {
-		switchErr = nixClient.ApplyHomeManager(cfg.HomeNixPath)
+	attrPaths, err := installedAttrPaths(cfg)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		printDryRun(cfg,
+			fmt.Sprintf("nix flake update nixpkgs --flake %s", cfg.FlakePath),
+			buildCommandLine(cfg),
+			diffClosuresCommandLine,
+		)
+		result.doc.Status = statusDryRun
+		return nil
 	}
 
-	if switchErr != nil {
-		return fmt.Errorf("home-manager switch に失敗: %w", switchErr)
+	oldRevision, err := nixClient.NixpkgsRevision()
+	if err != nil {
+		return fmt.Errorf("nixpkgs のリビジョンの取得に失敗: %w", err)
 	}
 
-	fmt.Println("\n✓ 更新が完了しました")
+	fmt.Fprintln(messageOut, "現在のバージョンを取得しています...")
+	oldVersions := lookupVersions(nixClient, attrPaths)
 
-	if len(args) > 0 {
-		packageName := args[0]
-		version, err := nixClient.GetPackageVersion(packageName)
-		if err == nil && version != "unknown" {
-			fmt.Printf("	%s: %s\n", packageName, version)
-		}
+	lockPath := filepath.Join(cfg.FlakePath, "flake.lock")
+	originalLock, err := os.ReadFile(lockPath)
+	if err != nil {
+		return fmt.Errorf("flake.lock の読み込みに失敗: %w", err)
+	}
+
+	fmt.Fprintln(messageOut, "nixpkgs を更新しています...")
+	if err := nixClient.UpdateFlakeInput(cfg.FlakePath, "nixpkgs"); err != nil {
+		restoreFlakeLock(lockPath, originalLock)
+		return err
+	}
+
+	newRevision, err := nixClient.NixpkgsRevision()
+	if err != nil {
+		restoreFlakeLock(lockPath, originalLock)
+		return fmt.Errorf("nixpkgs のリビジョンの取得に失敗: %w", err)
+	}
+
+	if newRevision == oldRevision {
+		fmt.Fprintln(messageOut, "\nnixpkgs は既に最新です")
+		return nil
+	}
+
+	if err := buildAndPreview(nixClient, cfg, result, nil); err != nil {
+		restoreFlakeLock(lockPath, originalLock)
+		return fmt.Errorf("ビルドに失敗しました: %w", err)
+	}
+
+	fmt.Fprintln(messageOut, "更新後のバージョンを取得しています...")
+	newVersions := lookupVersions(nixClient, attrPaths)
+
+	changes := make([]versionChangeDocument, 0, len(attrPaths))
+	for _, pkg := range attrPaths {
+		changes = append(changes, versionChangeDocument{
+			Name: pkg,
+			Old:  oldVersions[pkg],
+			New:  newVersions[pkg],
+		})
+	}
+	result.doc.Updates = changes
+
+	fmt.Fprintln(messageOut)
+	printVersionTable(changes)
+	fmt.Fprintln(messageOut)
+
+	ok, err := confirm("この内容で switch しますか？")
+	if err != nil {
+		restoreFlakeLock(lockPath, originalLock)
+		return err
 	}
 
+	if !ok {
+		restoreFlakeLock(lockPath, originalLock)
+		fmt.Fprintln(messageOut, "更新をキャンセルしました")
+		result.doc.Status = statusCancelled
+		return nil
+	}
+
+	fmt.Fprintln(messageOut, "\nhome-manager switch を実行しています...")
+
+	if switchErr := nixClient.Apply(cfg); switchErr != nil {
+		fmt.Fprintf(os.Stderr, "\nエラー: %v\n", switchErr)
+		restoreFlakeLock(lockPath, originalLock)
+		return fmt.Errorf("home-manager switch に失敗しました")
+	}
+
+	fmt.Fprintln(messageOut, "\n✓ 更新が完了しました")
+	result.doc.Status = statusSuccess
+
 	return nil
 }
+
+// switchWithoutUpdate はFlakeを使わない環境で home-manager switch だけを実行する
+func switchWithoutUpdate(cfg *config.Config, nixClient nix.NixClient, result *commandResult) error {
+	if dryRun {
+		printDryRun(cfg)
+		result.doc.Status = statusDryRun
+		return nil
+	}
+
+	fmt.Fprintln(messageOut, "home-manager switch を実行しています...")
+
+	if err := nixClient.Apply(cfg); err != nil {
+		return fmt.Errorf("home-manager switch に失敗: %w", err)
+	}
+
+	fmt.Fprintln(messageOut, "\n✓ 更新が完了しました")
+	result.doc.Status = statusSuccess
+
+	return nil
+}
+
+// printVersionTable は更新前後のバージョンを表にして表示する
+func printVersionTable(changes []versionChangeDocument) {
+	if len(changes) == 0 {
+		fmt.Fprintln(messageOut, "focus で管理しているパッケージはありません")
+		return
+	}
+
+	updated := renderVersionTable(changes, "更新後")
+	fmt.Fprintf(messageOut, "\n%d 個中 %d 個のパッケージのバージョンが変わります\n", len(changes), updated)
+}
+
+// renderVersionTable はパッケージ、現在のバージョン、比較先のバージョンの表を表示し、
+// バージョンが異なる（* を付けた）行の数を返す
+func renderVersionTable(changes []versionChangeDocument, newTitle string) int {
+	header := [3]string{"パッケージ", "現在", newTitle}
+	widths := [3]int{}
+	for i, title := range header {
+		widths[i] = displayWidth(title)
+	}
+	for _, change := range changes {
+		widths[0] = max(widths[0], displayWidth(change.Name))
+		widths[1] = max(widths[1], displayWidth(change.Old))
+		widths[2] = max(widths[2], displayWidth(change.New))
+	}
+
+	row := func(cells [3]string, mark string) {
+		line := fmt.Sprintf("  %s  %s  %s  %s", padRight(cells[0], widths[0]), padRight(cells[1], widths[1]), padRight(cells[2], widths[2]), mark)
+		fmt.Fprintln(messageOut, strings.TrimRight(line, " "))
+	}
+
+	row(header, "")
+	changed := 0
+	for _, change := range changes {
+		mark := ""
+		if change.Old != change.New {
+			mark = "*"
+			changed++
+		}
+		row([3]string{change.Name, change.Old, change.New}, mark)
+	}
+
+	return changed
+}
+
+// displayWidth は端末での表示幅を返す（全角文字を2桁として数える）
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r >= 0x1100 && unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r >= 0xFF01 && r <= 0xFF60 || r == 'ー' {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// padRight は表示幅が width になるよう右を空白で埋める
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-displayWidth(s)))
+}
+
+// restoreFlakeLock は flake.lock を更新前の内容に戻す
+func restoreFlakeLock(path string, content []byte) {
+	if err := safefile.WriteFile(path, content, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: flake.lock の復元に失敗しました: %v\n", err)
+		return
+	}
+	fmt.Fprintln(messageOut, "flake.lock を元に戻しました")
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"focus/internal/config"
+	"focus/internal/nix"
+)
+
+// setupUpdateTest はFlake環境の設定と flake.lock を用意する
+func setupUpdateTest(t *testing.T) (*nix.MockClient, string) {
+	t.Helper()
+
+	flakePath := t.TempDir()
+	lockPath := filepath.Join(flakePath, "flake.lock")
+	if err := os.WriteFile(lockPath, []byte(`{"old":true}`), 0644); err != nil {
+		t.Fatalf("Failed to write flake.lock: %v", err)
+	}
+
+	mock := setupCommandTest(t, &config.Config{
+		UseFlake:    true,
+		FlakePath:   flakePath,
+		FlakeConfig: "user",
+	})
+	mock.PackageVersions["fd"] = "10.1.0"
+	mock.UpdatedRevision = "new-revision"
+	mock.UpdatedVersions = map[string]string{"fd": "10.2.0"}
+	mock.UpdatedLock = []byte(`{"new":true}`)
+
+	return mock, lockPath
+}
+
+func readLock(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read flake.lock: %v", err)
+	}
+	return string(data)
+}
+
+// TestUpdateFlake tests updating nixpkgs, building and switching
+func TestUpdateFlake(t *testing.T) {
+	mock, lockPath := setupUpdateTest(t)
+
+	if err := runUpdate(updateCmd, nil); err != nil {
+		t.Fatalf("runUpdate failed: %v", err)
+	}
+
+	if len(mock.UpdatedInputs) != 1 || mock.UpdatedInputs[0] != "nixpkgs" {
+		t.Errorf("Expected nixpkgs to be updated, got %q", mock.UpdatedInputs)
+	}
+	if len(mock.Applied) != 1 {
+		t.Errorf("Expected 1 apply, got %d", len(mock.Applied))
+	}
+	if got := readLock(t, lockPath); got != `{"new":true}` {
+		t.Errorf("flake.lock should keep the update, got %s", got)
+	}
+}
+
+// TestUpdateRestoresLockOnFailure tests that flake.lock is restored when build or switch fails
+func TestUpdateRestoresLockOnFailure(t *testing.T) {
+	t.Run("build", func(t *testing.T) {
+		mock, lockPath := setupUpdateTest(t)
+		mock.ShouldBuildFail = true
+
+		if err := runUpdate(updateCmd, nil); err == nil {
+			t.Fatal("runUpdate should fail when the build fails")
+		}
+		if len(mock.Applied) != 0 {
+			t.Errorf("Switch should not run after a failed build")
+		}
+		if got := readLock(t, lockPath); got != `{"old":true}` {
+			t.Errorf("flake.lock was not restored: %s", got)
+		}
+	})
+
+	t.Run("switch", func(t *testing.T) {
+		mock, lockPath := setupUpdateTest(t)
+		mock.ShouldApplyFail = true
+
+		if err := runUpdate(updateCmd, nil); err == nil {
+			t.Fatal("runUpdate should fail when the switch fails")
+		}
+		if got := readLock(t, lockPath); got != `{"old":true}` {
+			t.Errorf("flake.lock was not restored: %s", got)
+		}
+	})
+}
+
+// TestUpdateAlreadyLatest tests that nothing is switched when the revision does not change
+func TestUpdateAlreadyLatest(t *testing.T) {
+	mock, _ := setupUpdateTest(t)
+	mock.UpdatedRevision = ""
+
+	if err := runUpdate(updateCmd, nil); err != nil {
+		t.Fatalf("runUpdate failed: %v", err)
+	}
+	if len(mock.Applied) != 0 {
+		t.Errorf("Switch should not run when nixpkgs is unchanged")
+	}
+}
+
+// TestUpdatePackageArgument tests that the optional package must be installed
+func TestUpdatePackageArgument(t *testing.T) {
+	mock, _ := setupUpdateTest(t)
+
+	if err := runUpdate(updateCmd, []string{"ripgrep"}); err == nil || !strings.Contains(err.Error(), "インストールされていません") {
+		t.Errorf("update should reject a package that is not installed: %v", err)
+	}
+	if len(mock.UpdatedInputs) != 0 {
+		t.Errorf("nixpkgs should not be updated: %q", mock.UpdatedInputs)
+	}
+
+	if err := runUpdate(updateCmd, []string{"fd"}); err != nil {
+		t.Fatalf("runUpdate failed: %v", err)
+	}
+	if len(mock.UpdatedInputs) != 1 {
+		t.Errorf("Expected nixpkgs to be updated, got %q", mock.UpdatedInputs)
+	}
+}
+
+// TestDisplayWidth tests counting full-width characters as two columns
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"ripgrep", 7},
+		{"パッケージ", 10},
+		{"現在", 4},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := displayWidth(tt.s); got != tt.want {
+			t.Errorf("displayWidth(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
+
+	"focus/internal/safefile"
 )
 
 type Config struct {
@@ -14,6 +19,138 @@ type Config struct {
 	UseFlake         bool   `toml:"use_flake"`
 	FlakePath        string `toml:"flake_path"`
 	FlakeConfig      string `toml:"flake_config"`
+	// BackupExtension を設定すると home-manager switch に -b <ext> を渡し、
+	// 衝突する既存ファイルを退避させる
+	BackupExtension string `toml:"backup_extension,omitempty"`
+	// SwitchArgs は home-manager switch に追加で渡す引数
+	SwitchArgs []string `toml:"switch_args,omitempty"`
+	// OutdatedAgainst は focus outdated の比較先（flake の入力名かフレーク参照）
+	OutdatedAgainst string `toml:"outdated_against,omitempty"`
+	// Groups はグループ名ごとのパッケージファイル（[groups.<name>] テーブル）
+	Groups map[string]Group `toml:"groups,omitempty"`
+	// Pins は focus pin で固定したパッケージ（[pins.<属性パス>] テーブル）
+	Pins map[string]Pin `toml:"pins,omitempty"`
+	// StableBranch は --channel stable で使う nixpkgs のブランチ
+	// 空なら最初に --channel stable を使ったときに、その時点で最新のリリースを保存する
+	StableBranch string `toml:"stable_branch,omitempty"`
+	// StableRev と StableHash は Flakeを使わない環境で pkgs.stable に使う StableBranch のコミットと
+	// そのソースの NAR ハッシュ（最初に --channel stable を使ったときに固定する）
+	StableRev  string `toml:"stable_rev,omitempty"`
+	StableHash string `toml:"stable_hash,omitempty"`
+}
+
+// Group は別の Nix ファイルに書き出すパッケージのグループ
+type Group struct {
+	// Path はグループのパッケージファイル（空なら GroupsDir/<name>.nix）
+	Path string `toml:"path,omitempty"`
+	// Disabled が true のグループは import しない（ファイルとメンバーは残す）
+	Disabled bool `toml:"disabled,omitempty"`
+}
+
+// Pin は focus pin で nixpkgs のリビジョンを固定したパッケージ
+type Pin struct {
+	// Rev は nixpkgs のコミット
+	Rev string `toml:"rev"`
+	// Hash は Rev のソースを展開したものの NAR ハッシュ（builtins.fetchTarball の sha256）
+	Hash string `toml:"hash"`
+	// Version は固定したときのバージョン（表示用）
+	Version string `toml:"version,omitempty"`
+}
+
+// groupNamePattern はグループ名として使える文字（ファイル名になるため制限する）
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// ValidateGroupName はグループ名がファイル名として使えるかを確認する
+func ValidateGroupName(name string) error {
+	if !groupNamePattern.MatchString(name) || name == "default" {
+		return fmt.Errorf("グループ名 '%s' は使えません（英数字、-、_ のみ。default は予約済み）", name)
+	}
+	return nil
+}
+
+// GroupsDir はグループのファイルと、それらを import するモジュールを置くディレクトリ
+func (c *Config) GroupsDir() string {
+	return filepath.Join(filepath.Dir(c.PackagesFilePath), "focus-groups")
+}
+
+// GroupsModulePath は有効なグループを import するモジュール（home.nix から import する）
+func (c *Config) GroupsModulePath() string {
+	return filepath.Join(c.GroupsDir(), "default.nix")
+}
+
+// GroupPath はグループのパッケージファイルのパスを返す
+func (c *Config) GroupPath(name string) string {
+	if group, ok := c.Groups[name]; ok && group.Path != "" {
+		return group.Path
+	}
+	return filepath.Join(c.GroupsDir(), name+".nix")
+}
+
+// GroupNames はグループ名を名前順に返す
+func (c *Config) GroupNames() []string {
+	names := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PinsModulePath は固定したパッケージを提供するモジュール（home.nix から import する）
+func (c *Config) PinsModulePath() string {
+	return filepath.Join(filepath.Dir(c.PackagesFilePath), "focus-pins.nix")
+}
+
+// PinNames は固定したパッケージを名前順に返す
+func (c *Config) PinNames() []string {
+	names := make([]string, 0, len(c.Pins))
+	for name := range c.Pins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HostModulePath は --host の条件で使う hostname 引数を定義するモジュール（home.nix から import する）
+func (c *Config) HostModulePath() string {
+	return filepath.Join(filepath.Dir(c.PackagesFilePath), "focus-host.nix")
+}
+
+// ChannelsModulePath は pkgs.stable を追加するオーバーレイのモジュール（home.nix から import する）
+func (c *Config) ChannelsModulePath() string {
+	return filepath.Join(filepath.Dir(c.PackagesFilePath), "focus-channels.nix")
+}
+
+// StableNixpkgsBranch は --channel stable で使う nixpkgs のブランチを返す
+// まだ決めていなければ（保存する前なら）、今の時点で最新のリリースを返す
+func (c *Config) StableNixpkgsBranch() string {
+	if c.StableBranch != "" {
+		return c.StableBranch
+	}
+	return DefaultStableBranch(time.Now())
+}
+
+// ChooseStableBranch は StableBranch が空なら now の時点で最新のリリースに決める
+// 決めた場合は true を返す（呼び出し側で設定ファイルに保存する）
+func (c *Config) ChooseStableBranch(now time.Time) bool {
+	if c.StableBranch != "" {
+		return false
+	}
+	c.StableBranch = DefaultStableBranch(now)
+	return true
+}
+
+// DefaultStableBranch は now の時点で最新の NixOS リリースのブランチ（例: nixos-25.05）を返す
+// リリースは毎年5月と11月の末なので、翌月からそのリリースを使う
+func DefaultStableBranch(now time.Time) string {
+	year, month := now.Year()%100, now.Month()
+	switch {
+	case month == time.December:
+		return fmt.Sprintf("nixos-%02d.11", year)
+	case month >= time.June:
+		return fmt.Sprintf("nixos-%02d.05", year)
+	}
+	return fmt.Sprintf("nixos-%02d.11", year-1)
 }
 
 func DefaultConfigPath() (string, error) {
@@ -49,6 +186,14 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("packages_file_pathの展開に失敗: %w", err)
 	}
 
+	for name, group := range config.Groups {
+		group.Path, err = expandPath(group.Path)
+		if err != nil {
+			return nil, fmt.Errorf("グループ '%s' の path の展開に失敗: %w", name, err)
+		}
+		config.Groups[name] = group
+	}
+
 	if config.UseFlake && config.FlakePath != "" {
 		config.FlakePath, err = expandPath(config.FlakePath)
 		if err != nil {
@@ -70,18 +215,51 @@ func Save(configPath string, config *Config) error {
 		return fmt.Errorf("設定ディレクトリの作成に失敗: %w", err)
 	}
 
-	data, err := toml.Marshal(config)
+	data, err := toml.Marshal(unexpandPaths(expandedPath, config))
 	if err != nil {
 		return fmt.Errorf("設定のシリアライズに失敗: %w", err)
 	}
 
-	if err := os.WriteFile(expandedPath, data, 0644); err != nil {
+	if err := safefile.WriteFile(expandedPath, data, 0644); err != nil {
 		return fmt.Errorf("設定ファイルの書き込みに失敗: %w", err)
 	}
 
 	return nil
 }
 
+// unexpandPaths は Load で ~ を展開したパスを、設定ファイルに書かれている元の値に戻したコピーを返す
+// 設定ファイルを dotfiles で共有できるよう、~/... のパスを絶対パスに書き換えない
+func unexpandPaths(path string, config *Config) *Config {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config
+	}
+	var saved Config
+	if err := toml.Unmarshal(data, &saved); err != nil {
+		return config
+	}
+
+	raw := func(current, savedValue string) string {
+		if expanded, err := expandPath(savedValue); err == nil && expanded == current {
+			return savedValue
+		}
+		return current
+	}
+
+	result := *config
+	result.HomeNixPath = raw(config.HomeNixPath, saved.HomeNixPath)
+	result.PackagesFilePath = raw(config.PackagesFilePath, saved.PackagesFilePath)
+	result.FlakePath = raw(config.FlakePath, saved.FlakePath)
+	if config.Groups != nil {
+		result.Groups = make(map[string]Group, len(config.Groups))
+		for name, group := range config.Groups {
+			group.Path = raw(group.Path, saved.Groups[name].Path)
+			result.Groups[name] = group
+		}
+	}
+	return &result
+}
+
 func Exists(configPath string) bool {
 	expandedPath, err := expandPath(configPath)
 	if err != nil {
@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestSaveAndLoad tests saving and loading config
@@ -129,3 +131,205 @@ func TestExpandPathWithTilde(t *testing.T) {
 		t.Errorf("Tilde not expanded in PackagesFilePath: got %s, want %s", loadedConfig.PackagesFilePath, expectedPackagesPath)
 	}
 }
+
+// TestSaveKeepsTildePaths tests that saving a loaded config does not expand ~ in the file
+func TestSaveKeepsTildePaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+
+	content := `home_nix_path = "~/.dotfiles/home.nix"
+packages_file_path = "~/.dotfiles/focus-packages.nix"
+use_flake = true
+flake_path = "~/.dotfiles"
+flake_config = "user"
+
+[groups.dev]
+path = "~/.dotfiles/dev.nix"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.FlakePath != filepath.Join(home, ".dotfiles") {
+		t.Fatalf("FlakePath was not expanded: %s", cfg.FlakePath)
+	}
+
+	// グループを追加して保存しても、既存のパスは ~ のまま残す
+	cfg.Groups["work"] = Group{}
+	cfg.StableBranch = "nixos-25.05"
+	if err := Save(configPath, cfg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	for _, want := range []string{"'~/.dotfiles/home.nix'", "'~/.dotfiles/focus-packages.nix'", "'~/.dotfiles'", "'~/.dotfiles/dev.nix'", "[groups.work]", "'nixos-25.05'"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Saved config does not contain %s:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), home) {
+		t.Errorf("Saved config contains expanded paths:\n%s", data)
+	}
+
+	// 値を変えたパスはそのまま保存する
+	cfg.HomeNixPath = "/etc/home.nix"
+	if err := Save(configPath, cfg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.HomeNixPath != "/etc/home.nix" || loaded.PackagesFilePath != filepath.Join(home, ".dotfiles/focus-packages.nix") {
+		t.Errorf("Unexpected paths: %s, %s", loaded.HomeNixPath, loaded.PackagesFilePath)
+	}
+}
+
+// TestGroups tests the groups table and group paths
+func TestGroups(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	testConfig := &Config{
+		HomeNixPath:      "/test/home.nix",
+		PackagesFilePath: "/test/focus-packages.nix",
+		Groups: map[string]Group{
+			"work": {Disabled: true},
+			"dev":  {},
+			"gui":  {Path: "~/gui.nix"},
+		},
+	}
+
+	if err := Save(configPath, testConfig); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loadedConfig, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	names := loadedConfig.GroupNames()
+	if len(names) != 3 || names[0] != "dev" || names[1] != "gui" || names[2] != "work" {
+		t.Errorf("Unexpected group names: %v", names)
+	}
+
+	if !loadedConfig.Groups["work"].Disabled || loadedConfig.Groups["dev"].Disabled {
+		t.Errorf("Disabled flags were not kept: %+v", loadedConfig.Groups)
+	}
+
+	// path を省略したグループは focus-groups/<name>.nix になる
+	if got := loadedConfig.GroupPath("dev"); got != "/test/focus-groups/dev.nix" {
+		t.Errorf("GroupPath(dev) = %s", got)
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	if got := loadedConfig.GroupPath("gui"); got != filepath.Join(homeDir, "gui.nix") {
+		t.Errorf("GroupPath(gui) = %s", got)
+	}
+
+	if got := loadedConfig.GroupsModulePath(); got != "/test/focus-groups/default.nix" {
+		t.Errorf("GroupsModulePath() = %s", got)
+	}
+}
+
+// TestValidateGroupName tests names usable as file names
+func TestValidateGroupName(t *testing.T) {
+	for _, name := range []string{"dev", "work-laptop", "gui_apps", "2024"} {
+		if err := ValidateGroupName(name); err != nil {
+			t.Errorf("ValidateGroupName(%q) failed: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "default", "../x", "a/b", "-dev", "my group"} {
+		if err := ValidateGroupName(name); err == nil {
+			t.Errorf("ValidateGroupName(%q) should fail", name)
+		}
+	}
+}
+
+// TestPins tests saving and loading pinned packages keyed by attribute path
+func TestPins(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	testConfig := &Config{
+		HomeNixPath:      "/test/home.nix",
+		PackagesFilePath: "/test/focus-packages.nix",
+		Pins: map[string]Pin{
+			"terraform":             {Rev: "abc123", Hash: "sha256-AAAA", Version: "1.5.7"},
+			"python3Packages.black": {Rev: "def456", Hash: "sha256-BBBB"},
+		},
+	}
+
+	if err := Save(configPath, testConfig); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loadedConfig, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// . を含む属性パスもキーとして読み書きできる
+	names := loadedConfig.PinNames()
+	if len(names) != 2 || names[0] != "python3Packages.black" || names[1] != "terraform" {
+		t.Errorf("Unexpected pin names: %v", names)
+	}
+
+	if pin := loadedConfig.Pins["terraform"]; pin != testConfig.Pins["terraform"] {
+		t.Errorf("Pin mismatch: got %+v", pin)
+	}
+
+	if got := loadedConfig.PinsModulePath(); got != "/test/focus-pins.nix" {
+		t.Errorf("PinsModulePath() = %s", got)
+	}
+}
+
+// TestDefaultStableBranch tests picking the latest NixOS release for a date
+func TestDefaultStableBranch(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2026-01-15", "nixos-25.11"},
+		{"2026-05-31", "nixos-25.11"},
+		{"2026-06-01", "nixos-26.05"},
+		{"2026-11-30", "nixos-26.05"},
+		{"2026-12-01", "nixos-26.11"},
+	}
+
+	for _, tt := range tests {
+		date, err := time.Parse("2006-01-02", tt.date)
+		if err != nil {
+			t.Fatalf("time.Parse failed: %v", err)
+		}
+		if got := DefaultStableBranch(date); got != tt.want {
+			t.Errorf("DefaultStableBranch(%s) = %s, want %s", tt.date, got, tt.want)
+		}
+	}
+
+	cfg := &Config{StableBranch: "nixpkgs-25.05-darwin"}
+	if got := cfg.StableNixpkgsBranch(); got != "nixpkgs-25.05-darwin" {
+		t.Errorf("StableNixpkgsBranch() = %s", got)
+	}
+
+	// 一度決めたブランチは日付が変わっても変えない
+	now, _ := time.Parse("2006-01-02", "2025-07-01")
+	cfg = &Config{}
+	if !cfg.ChooseStableBranch(now) || cfg.StableBranch != "nixos-25.05" {
+		t.Errorf("ChooseStableBranch should choose nixos-25.05: %q", cfg.StableBranch)
+	}
+	later, _ := time.Parse("2006-01-02", "2026-01-01")
+	if cfg.ChooseStableBranch(later) || cfg.StableNixpkgsBranch() != "nixos-25.05" {
+		t.Errorf("ChooseStableBranch should keep the saved branch: %q", cfg.StableBranch)
+	}
+}
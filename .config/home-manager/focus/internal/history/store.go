@@ -0,0 +1,177 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"focus/internal/safefile"
+	"focus/internal/state"
+)
+
+// Result は変更後の home-manager switch の結果
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultFailed  Result = "failed"
+)
+
+// Entry は focus-packages.nix に対する1回の変更
+// Before/After には変更前後のファイル内容をそのまま保存する
+type Entry struct {
+	ID        int       `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Command   string    `json:"command"`
+	FilePath  string    `json:"file_path"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Result    Result    `json:"result"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+}
+
+// Store は履歴をディレクトリに1エントリ1ファイルで保存する
+type Store struct {
+	dir string
+}
+
+// NewStore は dir に履歴を保存するストアを作成する
+func NewStore(dir string) *Store {
+	return &Store{
+		dir: dir,
+	}
+}
+
+// DefaultStore は状態ディレクトリ配下の history を使うストアを返す
+func DefaultStore() (*Store, error) {
+	dir, err := state.SubDir("history")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStore(dir), nil
+}
+
+// Record は新しいエントリを保存する
+// ID と Timestamp が未設定の場合は割り当てる
+func (s *Store) Record(entry *Entry) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("履歴ディレクトリの作成に失敗: %w", err)
+	}
+
+	if entry.ID == 0 {
+		entries, err := s.List()
+		if err != nil {
+			return err
+		}
+
+		entry.ID = 1
+		if len(entries) > 0 {
+			entry.ID = entries[len(entries)-1].ID + 1
+		}
+	}
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("履歴のシリアライズに失敗: %w", err)
+	}
+
+	if err := safefile.WriteFile(s.entryPath(entry.ID), data, 0644); err != nil {
+		return fmt.Errorf("履歴の書き込みに失敗: %w", err)
+	}
+
+	return nil
+}
+
+// List は全エントリを ID の昇順で返す
+func (s *Store) List() ([]*Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Entry{}, nil
+		}
+		return nil, fmt.Errorf("履歴ディレクトリの読み込みに失敗: %w", err)
+	}
+
+	entries := make([]*Entry, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		id, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+
+		entry, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
+
+// Get は指定された ID のエントリを返す
+func (s *Store) Get(id int) (*Entry, error) {
+	data, err := os.ReadFile(s.entryPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("履歴 #%d は存在しません", id)
+		}
+		return nil, fmt.Errorf("履歴の読み込みに失敗: %w", err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("履歴 #%d の解析に失敗: %w", id, err)
+	}
+
+	return &entry, nil
+}
+
+func (s *Store) entryPath(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d.json", id))
+}
+
+// Delta は変更前後のパッケージ一覧から追加・削除されたものを返す
+func Delta(before, after []string) (added, removed []string) {
+	beforeSet := make(map[string]bool, len(before))
+	for _, pkg := range before {
+		beforeSet[pkg] = true
+	}
+	afterSet := make(map[string]bool, len(after))
+	for _, pkg := range after {
+		afterSet[pkg] = true
+	}
+
+	for _, pkg := range after {
+		if !beforeSet[pkg] {
+			added = append(added, pkg)
+		}
+	}
+	for _, pkg := range before {
+		if !afterSet[pkg] {
+			removed = append(removed, pkg)
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,116 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRecordAndList tests recording entries with sequential IDs
+func TestRecordAndList(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "history"))
+
+	first := &Entry{
+		Command: "focus install ripgrep",
+		Added:   []string{"ripgrep"},
+		Result:  ResultSuccess,
+		Before:  "before",
+		After:   "after",
+	}
+	if err := store.Record(first); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	second := &Entry{
+		Command: "focus uninstall ripgrep",
+		Removed: []string{"ripgrep"},
+		Result:  ResultFailed,
+	}
+	if err := store.Record(second); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	if first.ID != 1 || second.ID != 2 {
+		t.Errorf("Unexpected IDs: %d, %d", first.ID, second.ID)
+	}
+
+	if first.Timestamp.IsZero() {
+		t.Error("Timestamp should be set by Record")
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+
+	if entries[0].Command != "focus install ripgrep" || entries[0].After != "after" {
+		t.Errorf("Entry #1 mismatch: %+v", entries[0])
+	}
+
+	if entries[1].Result != ResultFailed || entries[1].Removed[0] != "ripgrep" {
+		t.Errorf("Entry #2 mismatch: %+v", entries[1])
+	}
+}
+
+// TestListEmpty tests listing a store whose directory does not exist yet
+func TestListEmpty(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "missing"))
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries, got %d", len(entries))
+	}
+}
+
+// TestGetMissing tests getting a non-existent entry
+func TestGetMissing(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	if _, err := store.Get(42); err == nil {
+		t.Error("Get should fail for a missing entry")
+	}
+}
+
+// TestListIgnoresOtherFiles tests that unrelated files in the directory are skipped
+func TestListIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if err := store.Record(&Entry{Command: "focus install fd", Result: ResultSuccess}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(entries))
+	}
+}
+
+// TestDelta tests computing added and removed packages
+func TestDelta(t *testing.T) {
+	added, removed := Delta([]string{"fd", "ripgrep"}, []string{"fd", "jq", "bat"})
+
+	if len(added) != 2 || added[0] != "jq" || added[1] != "bat" {
+		t.Errorf("Unexpected added: %v", added)
+	}
+
+	if len(removed) != 1 || removed[0] != "ripgrep" {
+		t.Errorf("Unexpected removed: %v", removed)
+	}
+}
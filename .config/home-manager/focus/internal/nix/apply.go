@@ -0,0 +1,78 @@
+package nix
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"focus/internal/config"
+)
+
+// home-manager switch の実行モード
+const (
+	ApplyModeFile  = "file"
+	ApplyModeFlake = "flake"
+)
+
+// ApplyMode は cfg で使われる home-manager switch のモードを返す
+func ApplyMode(cfg *config.Config) string {
+	if cfg.UseFlake {
+		return ApplyModeFlake
+	}
+	return ApplyModeFile
+}
+
+// SwitchArgs は cfg に対応する home-manager の引数を返す（先頭は "switch"）
+func SwitchArgs(cfg *config.Config) []string {
+	args := append([]string{"switch"}, configArgs(cfg)...)
+
+	if cfg.BackupExtension != "" {
+		args = append(args, "-b", cfg.BackupExtension)
+	}
+
+	return append(args, cfg.SwitchArgs...)
+}
+
+// BuildArgs は有効化せずにビルドだけを行う home-manager の引数を返す
+// ビルドした世代へのリンク result はカレントディレクトリに作られる
+func BuildArgs(cfg *config.Config) []string {
+	args := append([]string{"build"}, configArgs(cfg)...)
+	return append(args, cfg.SwitchArgs...)
+}
+
+// configArgs は設定の指定方法（--flake または -f）の引数を返す
+func configArgs(cfg *config.Config) []string {
+	if ApplyMode(cfg) == ApplyModeFlake {
+		return []string{"--flake", fmt.Sprintf("%s#%s", cfg.FlakePath, cfg.FlakeConfig)}
+	}
+	return []string{"-f", cfg.HomeNixPath}
+}
+
+// HostEnv は home-manager の実行時にホスト名を渡す環境変数
+// extraSpecialArgs で hostname を渡していない場合、--host の条件はこの値と比較する
+const HostEnv = "FOCUS_HOSTNAME"
+
+// homeManagerCommand は home-manager を実行するコマンドを作る
+// --host の条件を評価できるよう、ホスト名を HostEnv で渡す
+func homeManagerCommand(args []string) *exec.Cmd {
+	cmd := exec.Command("home-manager", args...)
+	cmd.Env = append(os.Environ(), HostEnv+"="+HostName())
+	return cmd
+}
+
+// HostName は --host の条件と比較するホスト名を返す
+// 環境変数 FOCUS_HOSTNAME があればそれを、なければドメインを除いたホスト名を返す
+func HostName() string {
+	if host := os.Getenv(HostEnv); host != "" {
+		return host
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	host, _, _ = strings.Cut(host, ".")
+	return host
+}
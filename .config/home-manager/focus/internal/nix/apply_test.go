@@ -0,0 +1,88 @@
+package nix
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"focus/internal/config"
+)
+
+// TestSwitchArgs tests building home-manager arguments for both modes
+func TestSwitchArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		{
+			name: "file",
+			cfg:  config.Config{HomeNixPath: "/home/u/home.nix"},
+			want: []string{"switch", "-f", "/home/u/home.nix"},
+		},
+		{
+			name: "flake",
+			cfg:  config.Config{UseFlake: true, FlakePath: "/home/u/hm", FlakeConfig: "u@host"},
+			want: []string{"switch", "--flake", "/home/u/hm#u@host"},
+		},
+		{
+			name: "backup and extra args",
+			cfg: config.Config{
+				UseFlake:        true,
+				FlakePath:       "/home/u/hm",
+				FlakeConfig:     "u",
+				BackupExtension: "bak",
+				SwitchArgs:      []string{"--impure", "-L"},
+			},
+			want: []string{"switch", "--flake", "/home/u/hm#u", "-b", "bak", "--impure", "-L"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := SwitchArgs(&tt.cfg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: SwitchArgs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestMockClientRecordsApplyMode tests that the mock records which mode was used
+func TestMockClientRecordsApplyMode(t *testing.T) {
+	mock := NewMockClient()
+
+	if err := mock.Apply(&config.Config{UseFlake: true, FlakePath: "/hm", FlakeConfig: "u"}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if err := mock.ApplyHomeManager("/hm/home.nix"); err != nil {
+		t.Fatalf("ApplyHomeManager failed: %v", err)
+	}
+
+	if len(mock.Applied) != 2 {
+		t.Fatalf("Expected 2 recorded calls, got %d", len(mock.Applied))
+	}
+	if mock.Applied[0].Mode != ApplyModeFlake || mock.Applied[1].Mode != ApplyModeFile {
+		t.Errorf("Unexpected modes: %q, %q", mock.Applied[0].Mode, mock.Applied[1].Mode)
+	}
+
+	// 失敗する場合も呼び出しは記録する
+	mock.ShouldApplyFail = true
+	if err := mock.ApplyHomeManagerWithFlake("/hm", "u"); err == nil {
+		t.Error("Apply should fail when ShouldApplyFail is set")
+	}
+	if len(mock.Applied) != 3 || mock.Applied[2].Mode != ApplyModeFlake {
+		t.Errorf("Failed call was not recorded: %+v", mock.Applied)
+	}
+}
+
+// TestHostName tests that FOCUS_HOSTNAME overrides the system hostname
+func TestHostName(t *testing.T) {
+	t.Setenv("FOCUS_HOSTNAME", "work-laptop")
+	if got := HostName(); got != "work-laptop" {
+		t.Errorf("HostName() = %q, want work-laptop", got)
+	}
+
+	// 未設定ならドメインを除いたホスト名を返す
+	t.Setenv("FOCUS_HOSTNAME", "")
+	if got := HostName(); strings.Contains(got, ".") {
+		t.Errorf("HostName() should not contain the domain: %q", got)
+	}
+}
@@ -0,0 +1,116 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PackageStatus は属性の評価結果
+type PackageStatus string
+
+const (
+	PackageAvailable PackageStatus = "available"
+	PackageNotFound  PackageStatus = "not_found"
+	PackageEvalError PackageStatus = "eval_error"
+	PackageBroken    PackageStatus = "broken"
+	PackageUnfree    PackageStatus = "unfree"
+)
+
+// PackageCheck は CheckPackage の結果
+// Detail には評価エラーのメッセージなど、理由の補足が入る
+type PackageCheck struct {
+	Name   string
+	Status PackageStatus
+	Detail string
+}
+
+// Installable はインストールを続けてよいかを返す
+// unfree は flake 側で allowUnfree が設定されていればビルドできるので、警告に留める
+func (c *PackageCheck) Installable() bool {
+	return c.Status == PackageAvailable || c.Status == PackageUnfree
+}
+
+// Reason は状態を表示用の文章にする
+func (c *PackageCheck) Reason() string {
+	var reason string
+	switch c.Status {
+	case PackageAvailable:
+		reason = "利用可能"
+	case PackageNotFound:
+		reason = "nixpkgs に存在しません"
+	case PackageEvalError:
+		reason = "評価に失敗しました"
+	case PackageBroken:
+		reason = "broken とマークされています"
+	case PackageUnfree:
+		reason = "unfree ライセンスです（allowUnfree が必要です）"
+	default:
+		reason = string(c.Status)
+	}
+
+	if c.Detail != "" {
+		return fmt.Sprintf("%s: %s", reason, c.Detail)
+	}
+	return reason
+}
+
+// checkExpression は属性を評価し、derivation かどうかと meta の情報だけを取り出す
+// drvPath を評価しないので、broken や unfree のパッケージでも評価エラーにならない
+const checkExpression = `p: let
+  meta = if builtins.isAttrs p then p.meta or {} else {};
+  license = meta.license or [];
+  licenses = if builtins.isList license then license else [ license ];
+in {
+  isDerivation = builtins.isAttrs p && (p.type or null) == "derivation";
+  broken = meta.broken or false;
+  unfree = builtins.any (l: builtins.isAttrs l && !(l.free or true)) licenses;
+}`
+
+type checkOutput struct {
+	IsDerivation bool `json:"isDerivation"`
+	Broken       bool `json:"broken"`
+	Unfree       bool `json:"unfree"`
+}
+
+// parseCheckOutput は checkExpression を適用した nix eval --json の出力を解釈する
+func parseCheckOutput(name string, data []byte) (*PackageCheck, error) {
+	var out checkOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("評価結果の解析に失敗: %w", err)
+	}
+
+	check := &PackageCheck{Name: name, Status: PackageAvailable}
+	switch {
+	case !out.IsDerivation:
+		check.Status = PackageNotFound
+		check.Detail = "パッケージではない属性です"
+	case out.Broken:
+		check.Status = PackageBroken
+	case out.Unfree:
+		check.Status = PackageUnfree
+	}
+
+	return check, nil
+}
+
+// classifyEvalError は nix eval の失敗を「存在しない」と「評価エラー」に振り分ける
+func classifyEvalError(name, stderr string) *PackageCheck {
+	if strings.Contains(stderr, "does not provide attribute") {
+		return &PackageCheck{Name: name, Status: PackageNotFound}
+	}
+
+	return &PackageCheck{Name: name, Status: PackageEvalError, Detail: lastErrorLine(stderr)}
+}
+
+// lastErrorLine は nix のエラー出力から要点の1行を取り出す
+func lastErrorLine(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "error:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "error:"))
+		}
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
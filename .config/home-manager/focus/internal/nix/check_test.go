@@ -0,0 +1,54 @@
+package nix
+
+import "testing"
+
+// TestParseCheckOutput tests classifying evaluated attributes
+func TestParseCheckOutput(t *testing.T) {
+	tests := []struct {
+		data string
+		want PackageStatus
+	}{
+		{`{"broken":false,"isDerivation":true,"unfree":false}`, PackageAvailable},
+		{`{"broken":true,"isDerivation":true,"unfree":true}`, PackageBroken},
+		{`{"broken":false,"isDerivation":true,"unfree":true}`, PackageUnfree},
+		{`{"broken":false,"isDerivation":false,"unfree":false}`, PackageNotFound},
+	}
+
+	for _, tt := range tests {
+		check, err := parseCheckOutput("pkg", []byte(tt.data))
+		if err != nil {
+			t.Fatalf("parseCheckOutput(%s) failed: %v", tt.data, err)
+		}
+		if check.Status != tt.want {
+			t.Errorf("parseCheckOutput(%s) = %s, want %s", tt.data, check.Status, tt.want)
+		}
+	}
+
+	if _, err := parseCheckOutput("pkg", []byte("not json")); err == nil {
+		t.Error("parseCheckOutput should fail for invalid JSON")
+	}
+}
+
+// TestClassifyEvalError tests telling a missing attribute apart from an evaluation error
+func TestClassifyEvalError(t *testing.T) {
+	missing := "error: flake 'flake:nixpkgs' does not provide attribute 'packages.x86_64-linux.rg', 'legacyPackages.x86_64-linux.rg' or 'rg'\n"
+	if check := classifyEvalError("rg", missing); check.Status != PackageNotFound {
+		t.Errorf("Expected not_found, got %s", check.Status)
+	}
+
+	failed := `error:
+       … while evaluating the attribute 'foo'
+
+       error: Package 'foo' is marked as insecure
+`
+	check := classifyEvalError("foo", failed)
+	if check.Status != PackageEvalError {
+		t.Fatalf("Expected eval_error, got %s", check.Status)
+	}
+	if check.Detail != "Package 'foo' is marked as insecure" {
+		t.Errorf("Unexpected detail: %q", check.Detail)
+	}
+	if check.Installable() {
+		t.Error("An evaluation error should not be installable")
+	}
+}
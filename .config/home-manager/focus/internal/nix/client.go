@@ -2,29 +2,102 @@ package nix
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+
+	"focus/internal/config"
+	"focus/internal/nixast"
 )
 
 // NixClient はNix操作のインターフェース
 type NixClient interface {
 	Search(keyword string) ([]SearchResult, error)
 	PackageExists(packageName string) (bool, error)
+	CheckPackage(packageName string) (*PackageCheck, error)
+	Apply(cfg *config.Config) error
 	ApplyHomeManager(homeNixPath string) error
+	ApplyHomeManagerWithFlake(flakePath, configName string) error
+	Build(cfg *config.Config) (string, error)
+	CurrentGeneration() (string, error)
+	DiffClosures(oldPath, newPath string) ([]ClosureChange, error)
+	UpdateFlakeInput(flakePath, inputName string) error
 	GetPackageVersion(packageName string) (string, error)
+	NixpkgsRevision() (string, error)
+	AttributeNames() ([]string, error)
+	WithNixpkgs(ref string) NixClient
+	PackageInfo(packageName string) (*PackageInfo, error)
+	OutPath(packageName string) (string, error)
+	PathInfo(paths []string, recursive bool) (map[string]StorePathInfo, error)
+	PrefetchTarball(url string) (string, error)
 }
 
 // Client は実際のNixコマンドを実行するクライアント
-type Client struct{}
+type Client struct {
+	// flakePath が設定されていれば、nixpkgs をレジストリではなく
+	// そのflakeの flake.lock にロックされた入力から解決する
+	flakePath string
+	// nixpkgsRef が設定されていれば、nixpkgs の代わりにこのフレーク参照を使う
+	nixpkgsRef string
+	// out は home-manager の build/switch の出力を流す先（nil なら標準出力）
+	out io.Writer
+}
 
 // NewClient は新しいNixクライアントを作成する
 func NewClient() NixClient {
 	return &Client{}
 }
 
+// NewClientForConfig は設定に応じたNixクライアントを作成する
+// Flake環境では home-manager が実際にビルドする nixpkgs と同じリビジョンを参照する
+// home-manager の build/switch の出力は out に流す
+func NewClientForConfig(cfg *config.Config, out io.Writer) NixClient {
+	if cfg.UseFlake && cfg.FlakePath != "" {
+		return &Client{flakePath: cfg.FlakePath, out: out}
+	}
+	return &Client{out: out}
+}
+
+// output は home-manager の出力を流す先を返す
+func (c *Client) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
+}
+
+// WithNixpkgs は nixpkgs の代わりに ref（例: github:NixOS/nixpkgs/nixos-unstable）を
+// 参照するクライアントを返す
+func (c *Client) WithNixpkgs(ref string) NixClient {
+	return &Client{nixpkgsRef: ref}
+}
+
+// nixpkgs は評価や検索に使うフレーク参照を返す
+func (c *Client) nixpkgs() string {
+	if c.nixpkgsRef != "" {
+		return c.nixpkgsRef
+	}
+	return "nixpkgs"
+}
+
+// nixCommand は nix <subcommand> のコマンドを作成する
+// Flake環境では --inputs-from でflakeのロック済み入力をレジストリとして使う
+func (c *Client) nixCommand(subcommand string, args ...string) *exec.Cmd {
+	cmdArgs := []string{subcommand}
+	if c.flakePath != "" {
+		cmdArgs = append(cmdArgs, "--inputs-from", c.flakePath)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	return exec.Command("nix", cmdArgs...)
+}
+
 func (c *Client) Search(keyword string) ([]SearchResult, error) {
-	cmd := exec.Command("nix", "search", "nixpkgs", keyword, "--json")
+	cmd := c.nixCommand("search", c.nixpkgs(), keyword, "--json")
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -34,71 +107,227 @@ func (c *Client) Search(keyword string) ([]SearchResult, error) {
 		return nil, fmt.Errorf("nix search の実行に失敗: %s\n%s", err, stderr.String())
 	}
 
-	output := stdout.String()
+	return parseSearchOutput(stdout.Bytes())
+}
+
+// searchEntry は nix search --json の各エントリ
+type searchEntry struct {
+	Pname       string `json:"pname"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// parseSearchOutput は nix search --json の出力を SearchResult に変換する
+func parseSearchOutput(data []byte) ([]SearchResult, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	var entries map[string]searchEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("nix search の出力の解析に失敗: %w", err)
+	}
 
-	results := []SearchResult{
-		{
-			Name:        keyword,
-			Description: output,
-		},
+	results := make([]SearchResult, 0, len(entries))
+	for attrPath, entry := range entries {
+		results = append(results, SearchResult{
+			Name:        trimAttrPrefix(attrPath),
+			Pname:       entry.Pname,
+			Description: entry.Description,
+			Version:     entry.Version,
+		})
 	}
 
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
 	return results, nil
 }
 
+// trimAttrPrefix は "legacyPackages.<system>." や "packages.<system>." の接頭辞を取り除く
+func trimAttrPrefix(attrPath string) string {
+	for _, prefix := range []string{"legacyPackages.", "packages."} {
+		if strings.HasPrefix(attrPath, prefix) {
+			rest := attrPath[len(prefix):]
+			if idx := strings.Index(rest, "."); idx != -1 {
+				return rest[idx+1:]
+			}
+		}
+	}
+	return attrPath
+}
+
+// PackageExists は属性パス（例: python3Packages.black）が nixpkgs に存在するかを返す
 func (c *Client) PackageExists(packageName string) (bool, error) {
-	cmd := exec.Command("nix", "search", "nixpkgs", packageName, "--json")
+	check, err := c.CheckPackage(packageName)
+	if err != nil {
+		return false, err
+	}
+	return check.Status != PackageNotFound, nil
+}
 
-	var stdout bytes.Buffer
+// CheckPackage は nixpkgs#<属性パス> を評価し、インストールできるかを調べる
+// 存在しない属性と評価エラーを区別し、broken や unfree も理由として返す
+func (c *Client) CheckPackage(packageName string) (*PackageCheck, error) {
+	if !nixast.IsAttrPath(packageName) {
+		return &PackageCheck{Name: packageName, Status: PackageNotFound, Detail: "属性パスではありません"}, nil
+	}
+
+	cmd := c.nixCommand("eval", c.nixpkgs()+"#"+packageName, "--json", "--apply", checkExpression)
+
+	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return false, nil
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("nix eval の実行に失敗: %w", err)
+		}
+		return classifyEvalError(packageName, stderr.String()), nil
 	}
 
-	output := stdout.String()
-
-	return len(strings.TrimSpace(output)) > 0 && output != "{}", nil
+	return parseCheckOutput(packageName, stdout.Bytes())
 }
 
-func (c *Client) ApplyHomeManager(homeNixPath string) error {
-	// Note: homeNixPath is kept for backward compatibility but not used when flake is detected
-	// For flake support, use ApplyHomeManagerWithConfig instead
-	cmd := exec.Command("home-manager", "switch", "-f", homeNixPath)
+// PackageInfo は nixpkgs#<属性パス> を評価し、meta などの詳細情報を返す
+func (c *Client) PackageInfo(packageName string) (*PackageInfo, error) {
+	if !nixast.IsAttrPath(packageName) {
+		return nil, fmt.Errorf("'%s' は属性パスではありません", packageName)
+	}
+
+	cmd := c.nixCommand("eval", c.nixpkgs()+"#"+packageName, "--json", "--apply", infoExpression)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("home-manager switch の実行に失敗: %s\n%s\n%s", err, stdout.String(), stderr.String())
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("nix eval の実行に失敗: %w", err)
+		}
+		check := classifyEvalError(packageName, stderr.String())
+		return nil, fmt.Errorf("パッケージ '%s' の情報を取得できません: %s", packageName, check.Reason())
 	}
 
-	fmt.Print(stdout.String())
+	return parsePackageInfo(stdout.Bytes())
+}
 
-	return nil
+// Apply は cfg に従って home-manager switch を実行する
+// Flake環境なら --flake、そうでなければ -f で home.nix を指定する
+// 出力はそのまま表示しながら、状態ディレクトリ配下のログファイルにも書き出す
+func (c *Client) Apply(cfg *config.Config) error {
+	return runStreaming(homeManagerCommand(SwitchArgs(cfg)), "switch", c.output())
 }
 
-// ApplyHomeManagerWithFlake はFlake環境でhome-manager switchを実行
-func (c *Client) ApplyHomeManagerWithFlake(flakePath, configName string) error {
-	flakeRef := fmt.Sprintf("%s#%s", flakePath, configName)
-	cmd := exec.Command("home-manager", "switch", "--flake", flakeRef)
+// Build は cfg の設定を有効化せずにビルドし、新しい世代のストアパスを返す
+// result リンクは一時ディレクトリに作り、呼び出し元のディレクトリを汚さない
+func (c *Client) Build(cfg *config.Config) (string, error) {
+	dir, err := os.MkdirTemp("", "focus-build-")
+	if err != nil {
+		return "", fmt.Errorf("一時ディレクトリの作成に失敗: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 一時ディレクトリで実行するので、相対パスは絶対パスにしておく
+	abs := *cfg
+	if abs.FlakePath != "" {
+		if abs.FlakePath, err = filepath.Abs(abs.FlakePath); err != nil {
+			return "", err
+		}
+	}
+	if abs.HomeNixPath != "" {
+		if abs.HomeNixPath, err = filepath.Abs(abs.HomeNixPath); err != nil {
+			return "", err
+		}
+	}
+
+	cmd := homeManagerCommand(BuildArgs(&abs))
+	cmd.Dir = dir
+	if err := runStreaming(cmd, "build", c.output()); err != nil {
+		return "", err
+	}
+
+	path, err := os.Readlink(filepath.Join(dir, "result"))
+	if err != nil {
+		return "", fmt.Errorf("ビルド結果が見つかりません: %w", err)
+	}
+
+	return path, nil
+}
+
+// CurrentGeneration は現在有効な home-manager の世代のストアパスを返す
+func (c *Client) CurrentGeneration() (string, error) {
+	for _, profile := range homeManagerProfiles() {
+		if path, err := filepath.EvalSymlinks(profile); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("home-manager の世代が見つかりません")
+}
+
+// homeManagerProfiles は home-manager のプロファイルの候補を新しい配置から順に返す
+func homeManagerProfiles() []string {
+	var profiles []string
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		profiles = append(profiles, filepath.Join(xdg, "nix", "profiles", "home-manager"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		profiles = append(profiles, filepath.Join(home, ".local", "state", "nix", "profiles", "home-manager"))
+	}
+	if user := os.Getenv("USER"); user != "" {
+		profiles = append(profiles, filepath.Join("/nix/var/nix/profiles/per-user", user, "home-manager"))
+	}
+	return profiles
+}
+
+// DiffClosures は2つの世代のクロージャの差分を nix store diff-closures で求める
+func (c *Client) DiffClosures(oldPath, newPath string) ([]ClosureChange, error) {
+	cmd := exec.Command("nix", "store", "diff-closures", oldPath, newPath)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("home-manager switch の実行に失敗: %s\n%s\n%s", err, stdout.String(), stderr.String())
+		return nil, fmt.Errorf("nix store diff-closures の実行に失敗: %s\n%s", err, stderr.String())
 	}
 
-	fmt.Print(stdout.String())
+	return parseDiffClosures(stdout.String()), nil
+}
+
+// UpdateFlakeInput は flakePath の flake.lock で inputName の入力だけを最新にする
+func (c *Client) UpdateFlakeInput(flakePath, inputName string) error {
+	cmd := exec.Command("nix", "flake", "update", inputName, "--flake", flakePath)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("nix flake update の実行に失敗: %s\n%s", err, stderr.String())
+	}
 
 	return nil
 }
 
+// ApplyHomeManager は home.nix を指定して home-manager switch を実行する
+func (c *Client) ApplyHomeManager(homeNixPath string) error {
+	return c.Apply(&config.Config{HomeNixPath: homeNixPath})
+}
+
+// ApplyHomeManagerWithFlake はFlake環境でhome-manager switchを実行
+func (c *Client) ApplyHomeManagerWithFlake(flakePath, configName string) error {
+	return c.Apply(&config.Config{UseFlake: true, FlakePath: flakePath, FlakeConfig: configName})
+}
+
 func (c *Client) GetPackageVersion(packageName string) (string, error) {
-	cmd := exec.Command("nix", "eval", "nixpkgs#"+packageName+".version", "--raw")
+	// (callPackage ./x {}) のような式は nixpkgs の属性ではないので評価しない
+	if !nixast.IsAttrPath(packageName) {
+		return "unknown", nil
+	}
+
+	cmd := c.nixCommand("eval", c.nixpkgs()+"#"+packageName+".version", "--raw")
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -116,8 +345,157 @@ func (c *Client) GetPackageVersion(packageName string) (string, error) {
 	return version, nil
 }
 
+// NixpkgsRevision はバージョン取得に使う nixpkgs のロック済みリビジョンを返す
+func (c *Client) NixpkgsRevision() (string, error) {
+	if c.flakePath != "" {
+		locked, err := ReadLockedInput(c.flakePath, "nixpkgs")
+		if err != nil {
+			return "", err
+		}
+		return locked.Revision(), nil
+	}
+
+	cmd := exec.Command("nix", "flake", "metadata", c.nixpkgs(), "--json")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("nix flake metadata の実行に失敗: %s\n%s", err, stderr.String())
+	}
+
+	return parseFlakeRevision(stdout.Bytes())
+}
+
+// flakeMetadata は nix flake metadata --json のうち必要な部分
+type flakeMetadata struct {
+	Revision string `json:"revision"`
+	Locked   struct {
+		Rev     string `json:"rev"`
+		NarHash string `json:"narHash"`
+	} `json:"locked"`
+}
+
+// parseFlakeRevision は nix flake metadata --json の出力からリビジョンを取り出す
+// git 以外の入力で rev がない場合は narHash を使う
+func parseFlakeRevision(data []byte) (string, error) {
+	var metadata flakeMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return "", fmt.Errorf("nix flake metadata の出力の解析に失敗: %w", err)
+	}
+
+	switch {
+	case metadata.Locked.Rev != "":
+		return metadata.Locked.Rev, nil
+	case metadata.Revision != "":
+		return metadata.Revision, nil
+	case metadata.Locked.NarHash != "":
+		return metadata.Locked.NarHash, nil
+	}
+
+	return "", fmt.Errorf("nixpkgs のリビジョンが取得できません")
+}
+
+// PrefetchTarball は url のアーカイブを取得して展開し、その NAR ハッシュ（SRI形式）を返す
+// builtins.fetchTarball の sha256 にそのまま使える
+func (c *Client) PrefetchTarball(url string) (string, error) {
+	cmd := exec.Command("nix", "store", "prefetch-file", "--unpack", "--json", url)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("nix store prefetch-file の実行に失敗: %s\n%s", err, stderr.String())
+	}
+
+	return parsePrefetchOutput(stdout.Bytes())
+}
+
+// parsePrefetchOutput は nix store prefetch-file --json の出力からハッシュを取り出す
+func parsePrefetchOutput(data []byte) (string, error) {
+	var result struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("nix store prefetch-file の出力の解析に失敗: %w", err)
+	}
+	if result.Hash == "" {
+		return "", fmt.Errorf("nix store prefetch-file の出力にハッシュがありません")
+	}
+	return result.Hash, nil
+}
+
+// SearchResult は検索結果の1エントリ
+// Name は legacyPackages.<system>. を除いた属性パス（例: python3Packages.black）
 type SearchResult struct {
 	Name        string
+	Pname       string
 	Description string
 	Version     string
 }
+
+// AttributeNames は nixpkgs のトップレベルの属性名を返す
+func (c *Client) AttributeNames() ([]string, error) {
+	var system bytes.Buffer
+	systemCmd := exec.Command("nix", "eval", "--impure", "--raw", "--expr", "builtins.currentSystem")
+	systemCmd.Stdout = &system
+	if err := systemCmd.Run(); err != nil {
+		return nil, fmt.Errorf("システムの取得に失敗: %w", err)
+	}
+
+	cmd := c.nixCommand("eval", c.nixpkgs()+"#legacyPackages."+system.String(), "--json", "--apply", "builtins.attrNames")
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("属性名の取得に失敗: %w", err)
+	}
+
+	var names []string
+	if err := json.Unmarshal(stdout.Bytes(), &names); err != nil {
+		return nil, fmt.Errorf("属性名の解析に失敗: %w", err)
+	}
+
+	return names, nil
+}
+
+// OutPath はパッケージの出力パスを返す（ビルドはしない）
+func (c *Client) OutPath(packageName string) (string, error) {
+	if !nixast.IsAttrPath(packageName) {
+		return "", fmt.Errorf("'%s' は属性パスではありません", packageName)
+	}
+
+	cmd := c.nixCommand("eval", c.nixpkgs()+"#"+packageName+".outPath", "--raw")
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("出力パスの取得に失敗: %w", err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// PathInfo は nix path-info -S でストアパスのサイズと参照を返す
+// recursive が true ならクロージャ全体のパスを返す
+func (c *Client) PathInfo(paths []string, recursive bool) (map[string]StorePathInfo, error) {
+	args := []string{"path-info", "--json", "-S"}
+	if recursive {
+		args = append(args, "-r")
+	}
+	cmd := exec.Command("nix", append(args, paths...)...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("nix path-info の実行に失敗: %s\n%s", err, stderr.String())
+	}
+
+	return parsePathInfo(stdout.Bytes())
+}
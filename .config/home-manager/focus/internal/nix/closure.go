@@ -0,0 +1,112 @@
+package nix
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ClosureChange は nix store diff-closures の1行
+// バージョンが空のものは、その世代にパッケージが含まれないことを表す
+type ClosureChange struct {
+	Name        string
+	OldVersions []string
+	NewVersions []string
+	// SizeDelta はバイト単位のサイズの増減
+	SizeDelta int64
+}
+
+var (
+	ansiEscape   = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	sizeDeltaRe  = regexp.MustCompile(`^([+-][0-9]+(?:\.[0-9]+)?) KiB$`)
+	noVersionSet = "∅"
+)
+
+// parseDiffClosures は nix store diff-closures の出力を解釈する
+// 例: "ripgrep: ∅ → 14.1.1, +4521.3 KiB"、"glibc: +12.0 KiB"
+func parseDiffClosures(output string) []ClosureChange {
+	var changes []ClosureChange
+
+	for _, line := range strings.Split(ansiEscape.ReplaceAllString(output, ""), "\n") {
+		line = strings.TrimSpace(line)
+		name, rest, ok := strings.Cut(line, ": ")
+		if !ok || name == "" {
+			continue
+		}
+
+		change := ClosureChange{Name: name}
+
+		// サイズは最後の項目として ", " の後に付く（バージョンの変化がなければ単独）
+		versionPart, sizePart := "", rest
+		if idx := strings.LastIndex(rest, ", "); idx != -1 {
+			versionPart, sizePart = rest[:idx], rest[idx+2:]
+		}
+		if m := sizeDeltaRe.FindStringSubmatch(sizePart); m != nil {
+			kib, _ := strconv.ParseFloat(m[1], 64)
+			change.SizeDelta = int64(kib * 1024)
+			rest = versionPart
+		}
+
+		if oldPart, newPart, ok := strings.Cut(rest, " → "); ok {
+			change.OldVersions = parseVersionSet(oldPart)
+			change.NewVersions = parseVersionSet(newPart)
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// parseVersionSet は "1.0, 1.1" のようなバージョンの集合を解釈する
+// ∅ は空集合、ε は空のバージョン文字列を表す
+func parseVersionSet(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == noVersionSet || s == "" {
+		return nil
+	}
+
+	versions := strings.Split(s, ", ")
+	for i, version := range versions {
+		if version == "ε" {
+			versions[i] = ""
+		}
+	}
+	return versions
+}
+
+// FormatVersions はバージョンの集合を表示用にする（空集合は ∅）
+func FormatVersions(versions []string) string {
+	if len(versions) == 0 {
+		return noVersionSet
+	}
+	return strings.Join(versions, ", ")
+}
+
+// FormatSizeDelta はサイズの増減を符号付きの読みやすい単位で返す
+func FormatSizeDelta(bytes int64) string {
+	sign := "+"
+	if bytes < 0 {
+		sign = "-"
+		bytes = -bytes
+	}
+	return sign + FormatSize(bytes)
+}
+
+// FormatSize はバイト数を KiB/MiB/GiB で返す
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes) / unit
+	for _, suffix := range []string{"KiB", "MiB", "GiB"} {
+		if value < unit || suffix == "GiB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package nix
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseDiffClosures tests parsing version changes and size deltas
+func TestParseDiffClosures(t *testing.T) {
+	output := "\x1b[1mripgrep\x1b[0m: ∅ → \x1b[32;1m14.1.1\x1b[0m, \x1b[31;1m+4521.3 KiB\x1b[0m\n" +
+		"fd: 10.1.0 → ∅, -3072.0 KiB\n" +
+		"python3: 3.12.7, 3.11.10 → 3.12.8, +12.5 KiB\n" +
+		"glibc: +1.0 KiB\n" +
+		"hm-session-vars.sh: ε → ∅\n"
+
+	want := []ClosureChange{
+		{Name: "ripgrep", NewVersions: []string{"14.1.1"}, SizeDelta: 4629811},
+		{Name: "fd", OldVersions: []string{"10.1.0"}, SizeDelta: -3145728},
+		{Name: "python3", OldVersions: []string{"3.12.7", "3.11.10"}, NewVersions: []string{"3.12.8"}, SizeDelta: 12800},
+		{Name: "glibc", SizeDelta: 1024},
+		{Name: "hm-session-vars.sh", OldVersions: []string{""}},
+	}
+
+	got := parseDiffClosures(output)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDiffClosures mismatch\ngot:  %+v\nwant: %+v", got, want)
+	}
+
+	if got := parseDiffClosures(""); len(got) != 0 {
+		t.Errorf("Expected no changes for empty output, got %+v", got)
+	}
+}
+
+// TestFormatSize tests human-readable sizes
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{512, "512 B"},
+		{2048, "2.0 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatSize(tt.bytes); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+
+	if got := FormatSizeDelta(-2048); got != "-2.0 KiB" {
+		t.Errorf("FormatSizeDelta(-2048) = %q", got)
+	}
+}
@@ -0,0 +1,183 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// flakeLock は flake.lock (version 7) のうち必要な部分
+type flakeLock struct {
+	Root  string                   `json:"root"`
+	Nodes map[string]flakeLockNode `json:"nodes"`
+}
+
+type flakeLockNode struct {
+	// Inputs の値はノード名（文字列）か、follows の場合は入力名のパス（配列）
+	Inputs   map[string]json.RawMessage `json:"inputs"`
+	Locked   *LockedInput               `json:"locked"`
+	Original *LockedInput               `json:"original"`
+}
+
+// LockedInput は flake.lock に記録されたロック済み入力
+// original（flake.nix に書かれた参照）も同じ形で表す
+type LockedInput struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	Repo    string `json:"repo"`
+	Ref     string `json:"ref"`
+	Rev     string `json:"rev"`
+	NarHash string `json:"narHash"`
+	URL     string `json:"url"`
+	Path    string `json:"path"`
+}
+
+// ReadLockedInput は flakePath/flake.lock からルートの入力 inputName のロック情報を返す
+func ReadLockedInput(flakePath, inputName string) (*LockedInput, error) {
+	node, err := readLockNode(flakePath, inputName)
+	if err != nil {
+		return nil, err
+	}
+	return node.Locked, nil
+}
+
+// ReadOriginalInput は flakePath/flake.lock からルートの入力 inputName の
+// ロック前の参照（例: github:NixOS/nixpkgs/nixos-unstable）を返す
+func ReadOriginalInput(flakePath, inputName string) (*LockedInput, error) {
+	node, err := readLockNode(flakePath, inputName)
+	if err != nil {
+		return nil, err
+	}
+	if node.Original == nil {
+		return nil, fmt.Errorf("flake.lock に入力 '%s' の参照がありません", inputName)
+	}
+	return node.Original, nil
+}
+
+func readLockNode(flakePath, inputName string) (*flakeLockNode, error) {
+	data, err := os.ReadFile(filepath.Join(flakePath, "flake.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("flake.lock の読み込みに失敗: %w", err)
+	}
+
+	return parseLockNode(data, inputName)
+}
+
+func parseLockedInput(data []byte, inputName string) (*LockedInput, error) {
+	node, err := parseLockNode(data, inputName)
+	if err != nil {
+		return nil, err
+	}
+	return node.Locked, nil
+}
+
+// parseLockNode はルートの入力 inputName が指すロック済みのノードを返す
+func parseLockNode(data []byte, inputName string) (*flakeLockNode, error) {
+	var lock flakeLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("flake.lock の解析に失敗: %w", err)
+	}
+
+	root := lock.Root
+	if root == "" {
+		root = "root"
+	}
+
+	nodeName, err := lock.resolve(root, []string{inputName})
+	if err != nil {
+		return nil, err
+	}
+
+	node, ok := lock.Nodes[nodeName]
+	if !ok || node.Locked == nil {
+		return nil, fmt.Errorf("flake.lock に入力 '%s' のロック情報がありません", inputName)
+	}
+
+	return &node, nil
+}
+
+// resolve は from ノードから入力名のパスをたどり、ノード名を返す
+// follows（配列）はルートからのパスとして解決する
+func (l *flakeLock) resolve(from string, path []string) (string, error) {
+	current := from
+	for _, name := range path {
+		node, ok := l.Nodes[current]
+		if !ok {
+			return "", fmt.Errorf("flake.lock にノード '%s' がありません", current)
+		}
+
+		raw, ok := node.Inputs[name]
+		if !ok {
+			return "", fmt.Errorf("flake.lock に入力 '%s' がありません", name)
+		}
+
+		var nodeName string
+		if err := json.Unmarshal(raw, &nodeName); err == nil {
+			current = nodeName
+			continue
+		}
+
+		var follows []string
+		if err := json.Unmarshal(raw, &follows); err != nil {
+			return "", fmt.Errorf("flake.lock の入力 '%s' を解析できません", name)
+		}
+
+		root := l.Root
+		if root == "" {
+			root = "root"
+		}
+		resolved, err := l.resolve(root, follows)
+		if err != nil {
+			return "", err
+		}
+		current = resolved
+	}
+
+	return current, nil
+}
+
+// Revision はキャッシュのキーなどに使うリビジョンを返す
+func (i *LockedInput) Revision() string {
+	if i.Rev != "" {
+		return i.Rev
+	}
+	return i.NarHash
+}
+
+// FlakeRef は nix コマンドに渡せるフレーク参照を返す
+// rev があればそのリビジョンに固定し、なければ ref（ブランチなど）を使う
+func (i *LockedInput) FlakeRef() string {
+	version := i.Rev
+	if version == "" {
+		version = i.Ref
+	}
+
+	switch i.Type {
+	case "github", "gitlab", "sourcehut":
+		ref := fmt.Sprintf("%s:%s/%s", i.Type, i.Owner, i.Repo)
+		if version != "" {
+			ref += "/" + version
+		}
+		return ref
+	case "indirect":
+		ref := "flake:" + i.ID
+		if version != "" {
+			ref += "/" + version
+		}
+		return ref
+	case "path":
+		return "path:" + i.Path
+	case "git":
+		ref := "git+" + i.URL
+		if i.Rev != "" {
+			ref += "?rev=" + i.Rev
+		} else if i.Ref != "" {
+			ref += "?ref=" + i.Ref
+		}
+		return ref
+	}
+
+	return i.URL
+}
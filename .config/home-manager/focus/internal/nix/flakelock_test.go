@@ -0,0 +1,124 @@
+package nix
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReadLockedInput tests resolving the root nixpkgs input from a real flake.lock
+func TestReadLockedInput(t *testing.T) {
+	// testdata/flake.lock では root の nixpkgs は nixpkgs_2 ノードを指す
+	locked, err := ReadLockedInput("testdata", "nixpkgs")
+	if err != nil {
+		t.Fatalf("ReadLockedInput failed: %v", err)
+	}
+
+	if locked.Revision() != "00c21e4c93d963c50d4c0c89bfa84ed6e0694df2" {
+		t.Errorf("Unexpected revision: %s", locked.Revision())
+	}
+	if locked.Owner != "nixos" || locked.Repo != "nixpkgs" {
+		t.Errorf("Unexpected source: %s/%s", locked.Owner, locked.Repo)
+	}
+
+	if _, err := ReadLockedInput("testdata", "nonexistent"); err == nil {
+		t.Error("ReadLockedInput should fail for a missing input")
+	}
+
+	if _, err := ReadLockedInput(t.TempDir(), "nixpkgs"); err == nil {
+		t.Error("ReadLockedInput should fail without flake.lock")
+	}
+}
+
+// TestParseLockedInputFollows tests inputs that follow another input
+func TestParseLockedInputFollows(t *testing.T) {
+	data := []byte(`{
+  "root": "root",
+  "version": 7,
+  "nodes": {
+    "root": {"inputs": {"home-manager": "home-manager", "nixpkgs": ["home-manager", "nixpkgs"]}},
+    "home-manager": {"inputs": {"nixpkgs": "nixpkgs"}, "locked": {"type": "github", "rev": "hm"}},
+    "nixpkgs": {"locked": {"type": "path", "path": "/nix/store/x", "narHash": "sha256-abc"}}
+  }
+}`)
+
+	locked, err := parseLockedInput(data, "nixpkgs")
+	if err != nil {
+		t.Fatalf("parseLockedInput failed: %v", err)
+	}
+
+	// rev がない場合は narHash をリビジョンとして使う
+	if locked.Revision() != "sha256-abc" {
+		t.Errorf("Unexpected revision: %s", locked.Revision())
+	}
+
+	if _, err := parseLockedInput([]byte("not json"), "nixpkgs"); err == nil {
+		t.Error("parseLockedInput should fail for invalid JSON")
+	}
+}
+
+// TestNixpkgsRevisionFromFlake tests that a flake-mode client reads the lock file
+func TestNixpkgsRevisionFromFlake(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "flake.lock"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "flake.lock"), data, 0644); err != nil {
+		t.Fatalf("Failed to write flake.lock: %v", err)
+	}
+
+	client := &Client{flakePath: dir}
+	revision, err := client.NixpkgsRevision()
+	if err != nil {
+		t.Fatalf("NixpkgsRevision failed: %v", err)
+	}
+	if revision != "00c21e4c93d963c50d4c0c89bfa84ed6e0694df2" {
+		t.Errorf("Unexpected revision: %s", revision)
+	}
+
+	args := client.nixCommand("eval", "nixpkgs#hello.version", "--raw").Args
+	want := []string{"nix", "eval", "--inputs-from", dir, "nixpkgs#hello.version", "--raw"}
+	if len(args) != len(want) {
+		t.Fatalf("Unexpected args: %v", args)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("args[%d] = %q, want %q", i, args[i], want[i])
+		}
+	}
+}
+
+// TestReadOriginalInput tests building flake references from the lock file
+func TestReadOriginalInput(t *testing.T) {
+	original, err := ReadOriginalInput("testdata", "nixpkgs")
+	if err != nil {
+		t.Fatalf("ReadOriginalInput failed: %v", err)
+	}
+	if got := original.FlakeRef(); got != "github:nixos/nixpkgs/nixos-unstable" {
+		t.Errorf("Unexpected original ref: %s", got)
+	}
+
+	locked, err := ReadLockedInput("testdata", "nixpkgs")
+	if err != nil {
+		t.Fatalf("ReadLockedInput failed: %v", err)
+	}
+	if got := locked.FlakeRef(); got != "github:nixos/nixpkgs/00c21e4c93d963c50d4c0c89bfa84ed6e0694df2" {
+		t.Errorf("Unexpected locked ref: %s", got)
+	}
+
+	tests := []struct {
+		input LockedInput
+		want  string
+	}{
+		{LockedInput{Type: "indirect", ID: "nixpkgs"}, "flake:nixpkgs"},
+		{LockedInput{Type: "path", Path: "/nix/store/x"}, "path:/nix/store/x"},
+		{LockedInput{Type: "git", URL: "https://example.com/n.git", Ref: "main"}, "git+https://example.com/n.git?ref=main"},
+		{LockedInput{Type: "tarball", URL: "https://example.com/n.tar.gz"}, "https://example.com/n.tar.gz"},
+	}
+	for _, tt := range tests {
+		if got := tt.input.FlakeRef(); got != tt.want {
+			t.Errorf("FlakeRef() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// PackageInfo はパッケージの meta などの詳細情報
+type PackageInfo struct {
+	Name                 string   `json:"name"`
+	Pname                string   `json:"pname"`
+	Version              string   `json:"version"`
+	Description          string   `json:"description"`
+	Homepage             string   `json:"homepage"`
+	Licenses             []string `json:"licenses"`
+	Maintainers          []string `json:"maintainers"`
+	Platforms            []string `json:"platforms"`
+	MainProgram          string   `json:"mainProgram"`
+	Broken               bool     `json:"broken"`
+	Insecure             bool     `json:"insecure"`
+	KnownVulnerabilities []string `json:"knownVulnerabilities"`
+	Outputs              []string `json:"outputs"`
+	Position             string   `json:"position"`
+}
+
+// infoExpression はパッケージから表示に必要な情報だけを JSON にできる形で取り出す
+// license や homepage は単体とリストのどちらもありうるので揃える
+const infoExpression = `p: let
+  meta = p.meta or {};
+  toList = x: if builtins.isList x then x else [ x ];
+  homepages = toList (meta.homepage or []);
+  licenseName = l: if builtins.isAttrs l then l.spdxId or l.shortName or l.fullName or "unknown" else toString l;
+  maintainerName = m: m.github or m.name or m.email or "unknown";
+in {
+  name = p.name or "";
+  pname = p.pname or "";
+  version = p.version or "";
+  description = meta.description or "";
+  homepage = if homepages == [] then "" else builtins.head homepages;
+  licenses = map licenseName (toList (meta.license or []));
+  maintainers = map maintainerName (meta.maintainers or []);
+  platforms = builtins.filter builtins.isString (meta.platforms or []);
+  mainProgram = meta.mainProgram or "";
+  broken = meta.broken or false;
+  insecure = meta.insecure or false;
+  knownVulnerabilities = meta.knownVulnerabilities or [];
+  outputs = p.outputs or [ "out" ];
+  position = meta.position or "";
+}`
+
+// parsePackageInfo は infoExpression を適用した nix eval --json の出力を解釈する
+func parsePackageInfo(data []byte) (*PackageInfo, error) {
+	var info PackageInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("パッケージ情報の解析に失敗: %w", err)
+	}
+
+	return &info, nil
+}
+
+var storeSourcePrefix = regexp.MustCompile(`^/nix/store/[^/]+/`)
+
+// RelativePosition は定義位置をストアパスを除いた nixpkgs 内のパスで返す
+// 例: pkgs/by-name/ri/ripgrep/package.nix:67
+func (i *PackageInfo) RelativePosition() string {
+	return storeSourcePrefix.ReplaceAllString(i.Position, "")
+}
@@ -0,0 +1,39 @@
+package nix
+
+import "testing"
+
+// TestParsePackageInfo tests decoding evaluated meta and trimming the store path
+func TestParsePackageInfo(t *testing.T) {
+	data := []byte(`{
+  "name": "ripgrep-14.1.1",
+  "pname": "ripgrep",
+  "version": "14.1.1",
+  "description": "Utility that combines the usability of The Silver Searcher with the raw speed of grep",
+  "homepage": "https://github.com/BurntSushi/ripgrep",
+  "licenses": ["MIT", "Unlicense"],
+  "maintainers": ["globin", "ma27"],
+  "platforms": ["x86_64-linux", "aarch64-darwin"],
+  "mainProgram": "rg",
+  "broken": false,
+  "insecure": false,
+  "knownVulnerabilities": [],
+  "outputs": ["out"],
+  "position": "/nix/store/0123abcd-source/pkgs/by-name/ri/ripgrep/package.nix:67"
+}`)
+
+	info, err := parsePackageInfo(data)
+	if err != nil {
+		t.Fatalf("parsePackageInfo failed: %v", err)
+	}
+
+	if info.MainProgram != "rg" || len(info.Licenses) != 2 || info.Licenses[1] != "Unlicense" {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+	if got := info.RelativePosition(); got != "pkgs/by-name/ri/ripgrep/package.nix:67" {
+		t.Errorf("RelativePosition() = %q", got)
+	}
+
+	if _, err := parsePackageInfo([]byte("[]")); err == nil {
+		t.Error("parsePackageInfo should fail for a non-object")
+	}
+}
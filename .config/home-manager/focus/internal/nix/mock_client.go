@@ -1,15 +1,60 @@
 package nix
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"focus/internal/config"
+)
 
 // MockClient はテスト用のNixクライアント
 type MockClient struct {
 	// PackageExistsの戻り値を制御
 	ShouldPackageExist bool
+	// PackageStatuses はパッケージごとの CheckPackage の結果（未設定なら ShouldPackageExist に従う）
+	PackageStatuses map[string]PackageStatus
 	// ApplyHomeManagerが失敗するかを制御
 	ShouldApplyFail bool
 	// GetPackageVersionの戻り値
 	PackageVersions map[string]string
+	// NixpkgsRevisionの戻り値
+	Revision string
+	// Attributes は AttributeNames の戻り値
+	Attributes []string
+	// ShouldBuildFail は Build が失敗するかを制御
+	ShouldBuildFail bool
+	// ClosureChanges は DiffClosures の戻り値
+	ClosureChanges []ClosureChange
+	// Builds は Build が呼ばれた回数
+	Builds int
+	// ShouldUpdateFail は UpdateFlakeInput が失敗するかを制御
+	ShouldUpdateFail bool
+	// UpdatedRevision, UpdatedVersions, UpdatedLock は UpdateFlakeInput 後の
+	// リビジョン、バージョン、flake.lock の内容（設定されていれば反映する）
+	UpdatedRevision string
+	UpdatedVersions map[string]string
+	UpdatedLock     []byte
+	// UpdatedInputs は UpdateFlakeInput で更新された入力名
+	UpdatedInputs []string
+	// NixpkgsVersions は WithNixpkgs で参照先を変えたときのバージョン（参照ごと）
+	NixpkgsVersions map[string]map[string]string
+	// PackageInfos は PackageInfo の戻り値（未設定なら名前とバージョンだけの情報を返す）
+	PackageInfos map[string]*PackageInfo
+	// PathInfos は PathInfo の戻り値の元になるストアパスの情報
+	PathInfos map[string]StorePathInfo
+	// PrefetchHashes は URL ごとの PrefetchTarball の戻り値（未設定なら URL から作ったダミーを返す）
+	PrefetchHashes map[string]string
+	// ShouldPrefetchFail は PrefetchTarball が失敗するかを制御
+	ShouldPrefetchFail bool
+	// Applied は Apply の呼び出し履歴
+	Applied []ApplyCall
+}
+
+// ApplyCall は MockClient.Apply の1回の呼び出し
+type ApplyCall struct {
+	Mode string
+	Args []string
 }
 
 // NewMockClient は新しいモッククライアントを作成する
@@ -17,7 +62,9 @@ func NewMockClient() *MockClient {
 	return &MockClient{
 		ShouldPackageExist: true,
 		ShouldApplyFail:    false,
+		PackageStatuses:    make(map[string]PackageStatus),
 		PackageVersions:    make(map[string]string),
+		Revision:           "mock-revision",
 	}
 }
 
@@ -34,17 +81,89 @@ func (m *MockClient) Search(keyword string) ([]SearchResult, error) {
 
 // PackageExists は設定に応じてパッケージの存在を返す
 func (m *MockClient) PackageExists(packageName string) (bool, error) {
-	return m.ShouldPackageExist, nil
+	check, err := m.CheckPackage(packageName)
+	if err != nil {
+		return false, err
+	}
+	return check.Status != PackageNotFound, nil
 }
 
-// ApplyHomeManager は設定に応じて成功/失敗を返す（実際には何もしない）
-func (m *MockClient) ApplyHomeManager(homeNixPath string) error {
+// CheckPackage は設定に応じてパッケージの状態を返す
+func (m *MockClient) CheckPackage(packageName string) (*PackageCheck, error) {
+	if status, ok := m.PackageStatuses[packageName]; ok {
+		return &PackageCheck{Name: packageName, Status: status}, nil
+	}
+	if m.ShouldPackageExist {
+		return &PackageCheck{Name: packageName, Status: PackageAvailable}, nil
+	}
+	return &PackageCheck{Name: packageName, Status: PackageNotFound}, nil
+}
+
+// Apply は呼び出されたモードと引数を記録し、設定に応じて成功/失敗を返す（実際には何もしない）
+func (m *MockClient) Apply(cfg *config.Config) error {
+	m.Applied = append(m.Applied, ApplyCall{
+		Mode: ApplyMode(cfg),
+		Args: SwitchArgs(cfg),
+	})
+
 	if m.ShouldApplyFail {
 		return fmt.Errorf("mock: home-manager switch failed")
 	}
 	return nil
 }
 
+// Build は呼び出しを数え、設定に応じて成功/失敗を返す（実際には何もしない）
+func (m *MockClient) Build(cfg *config.Config) (string, error) {
+	m.Builds++
+	if m.ShouldBuildFail {
+		return "", fmt.Errorf("mock: home-manager build failed")
+	}
+	return "/nix/store/mock-new-home-manager-generation", nil
+}
+
+// CurrentGeneration はダミーの世代を返す
+func (m *MockClient) CurrentGeneration() (string, error) {
+	return "/nix/store/mock-current-home-manager-generation", nil
+}
+
+// DiffClosures は設定された差分を返す
+func (m *MockClient) DiffClosures(oldPath, newPath string) ([]ClosureChange, error) {
+	return m.ClosureChanges, nil
+}
+
+// UpdateFlakeInput は入力の更新を記録し、設定された更新後の状態を反映する
+func (m *MockClient) UpdateFlakeInput(flakePath, inputName string) error {
+	if m.ShouldUpdateFail {
+		return fmt.Errorf("mock: nix flake update failed")
+	}
+
+	m.UpdatedInputs = append(m.UpdatedInputs, inputName)
+
+	if m.UpdatedLock != nil {
+		if err := os.WriteFile(filepath.Join(flakePath, "flake.lock"), m.UpdatedLock, 0644); err != nil {
+			return err
+		}
+	}
+	if m.UpdatedRevision != "" {
+		m.Revision = m.UpdatedRevision
+	}
+	for name, version := range m.UpdatedVersions {
+		m.PackageVersions[name] = version
+	}
+
+	return nil
+}
+
+// ApplyHomeManager は home.nix を指定した Apply として扱う
+func (m *MockClient) ApplyHomeManager(homeNixPath string) error {
+	return m.Apply(&config.Config{HomeNixPath: homeNixPath})
+}
+
+// ApplyHomeManagerWithFlake はFlake環境の Apply として扱う
+func (m *MockClient) ApplyHomeManagerWithFlake(flakePath, configName string) error {
+	return m.Apply(&config.Config{UseFlake: true, FlakePath: flakePath, FlakeConfig: configName})
+}
+
 // GetPackageVersion は設定されたバージョンを返す
 func (m *MockClient) GetPackageVersion(packageName string) (string, error) {
 	if version, ok := m.PackageVersions[packageName]; ok {
@@ -52,3 +171,89 @@ func (m *MockClient) GetPackageVersion(packageName string) (string, error) {
 	}
 	return "1.0.0", nil
 }
+
+// NixpkgsRevision は設定されたリビジョンを返す
+func (m *MockClient) NixpkgsRevision() (string, error) {
+	return m.Revision, nil
+}
+
+// AttributeNames は設定された属性名を返す
+func (m *MockClient) AttributeNames() ([]string, error) {
+	return m.Attributes, nil
+}
+
+// WithNixpkgs は ref のバージョンを返すモッククライアントを返す
+// リビジョンには ref をそのまま使う
+func (m *MockClient) WithNixpkgs(ref string) NixClient {
+	other := NewMockClient()
+	other.Revision = ref
+	for name, version := range m.NixpkgsVersions[ref] {
+		other.PackageVersions[name] = version
+	}
+	return other
+}
+
+// PackageInfo は設定されたパッケージ情報を返す
+func (m *MockClient) PackageInfo(packageName string) (*PackageInfo, error) {
+	if info, ok := m.PackageInfos[packageName]; ok {
+		return info, nil
+	}
+
+	check, err := m.CheckPackage(packageName)
+	if err != nil {
+		return nil, err
+	}
+	if check.Status == PackageNotFound {
+		return nil, fmt.Errorf("mock: package '%s' not found", packageName)
+	}
+
+	version, _ := m.GetPackageVersion(packageName)
+	return &PackageInfo{
+		Name:    packageName + "-" + version,
+		Pname:   packageName,
+		Version: version,
+		Outputs: []string{"out"},
+	}, nil
+}
+
+// OutPath はパッケージ名から作ったダミーの出力パスを返す
+func (m *MockClient) OutPath(packageName string) (string, error) {
+	return "/nix/store/mock-" + packageName, nil
+}
+
+// PathInfo は PathInfos から paths（recursive なら参照先も含む）の情報を返す
+func (m *MockClient) PathInfo(paths []string, recursive bool) (map[string]StorePathInfo, error) {
+	infos := make(map[string]StorePathInfo)
+
+	stack := append([]string{}, paths...)
+	for len(stack) > 0 {
+		path := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		info, ok := m.PathInfos[path]
+		if !ok {
+			return nil, fmt.Errorf("mock: path '%s' is not valid", path)
+		}
+		if _, seen := infos[path]; seen {
+			continue
+		}
+		infos[path] = info
+
+		if recursive {
+			stack = append(stack, info.References...)
+		}
+	}
+
+	return infos, nil
+}
+
+// PrefetchTarball は設定されたハッシュを返す
+func (m *MockClient) PrefetchTarball(url string) (string, error) {
+	if m.ShouldPrefetchFail {
+		return "", fmt.Errorf("mock: prefetch of '%s' failed", url)
+	}
+	if hash, ok := m.PrefetchHashes[url]; ok {
+		return hash, nil
+	}
+	return "sha256-mock-" + filepath.Base(url), nil
+}
@@ -0,0 +1,167 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// StorePathInfo は nix path-info --json の1件
+type StorePathInfo struct {
+	NarSize     int64    `json:"narSize"`
+	ClosureSize int64    `json:"closureSize"`
+	References  []string `json:"references"`
+}
+
+// PackageSize はパッケージのクロージャのサイズ
+// Unique はプロファイル中でそのパッケージだけが必要とする部分（削除すれば空く量）で、
+// 求められない場合は -1 になる
+type PackageSize struct {
+	Closure int64
+	Unique  int64
+}
+
+// parsePathInfo は nix path-info --json の出力を解釈する
+// Nix 2.19 以降はパスをキーにしたオブジェクト、それより前は path を持つ配列を返す
+func parsePathInfo(data []byte) (map[string]StorePathInfo, error) {
+	infos := make(map[string]StorePathInfo)
+	if err := json.Unmarshal(data, &infos); err == nil {
+		return infos, nil
+	}
+
+	var list []struct {
+		Path string `json:"path"`
+		StorePathInfo
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("nix path-info の出力の解析に失敗: %w", err)
+	}
+
+	for _, item := range list {
+		infos[item.Path] = item.StorePathInfo
+	}
+	return infos, nil
+}
+
+// LookupOutPaths は複数パッケージの出力パスを並列に取得する
+// 取得できなかったパッケージは結果に含めない
+func LookupOutPaths(client NixClient, packageNames []string) map[string]string {
+	paths := make(map[string]string, len(packageNames))
+	var mu sync.Mutex
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+
+	for range min(maxVersionWorkers, len(packageNames)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				path, err := client.OutPath(name)
+				if err != nil {
+					continue
+				}
+
+				mu.Lock()
+				paths[name] = path
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range packageNames {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+
+	return paths
+}
+
+// ClosureSizes は世代 generation のクロージャの参照グラフから、各パッケージの
+// クロージャのサイズと、そのパッケージだけが必要とするサイズを求める
+// outPaths はパッケージ名から出力パスへの対応で、グラフにないものは結果に含めない
+func ClosureSizes(generation string, outPaths map[string]string, graph map[string]StorePathInfo) map[string]PackageSize {
+	sizes := make(map[string]PackageSize, len(outPaths))
+
+	for name, path := range outPaths {
+		if _, ok := graph[path]; !ok {
+			continue
+		}
+
+		closure := reachable(graph, path, "")
+		// パッケージを経由せずに世代から届くパスは他にも必要とされている
+		others := reachable(graph, generation, path)
+
+		var closureSize, uniqueSize int64
+		for p := range closure {
+			closureSize += graph[p].NarSize
+			if !others[p] {
+				uniqueSize += graph[p].NarSize
+			}
+		}
+
+		sizes[name] = PackageSize{Closure: closureSize, Unique: uniqueSize}
+	}
+
+	return sizes
+}
+
+// reachable は from から参照をたどって届くパスの集合を返す（blocked は通らない）
+func reachable(graph map[string]StorePathInfo, from, blocked string) map[string]bool {
+	seen := map[string]bool{}
+	if from == blocked {
+		return seen
+	}
+
+	stack := []string{from}
+	seen[from] = true
+	for len(stack) > 0 {
+		path := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, ref := range graph[path].References {
+			if ref == blocked || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			stack = append(stack, ref)
+		}
+	}
+
+	return seen
+}
+
+// PackageSizes は世代 generation に含まれるパッケージのサイズを求める
+// 世代のグラフに出力パスがないパッケージは、ストアにあれば nix path-info -S のクロージャのサイズだけを返す
+func PackageSizes(client NixClient, generation string, packageNames []string) (map[string]PackageSize, error) {
+	graph, err := client.PathInfo([]string{generation}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	outPaths := LookupOutPaths(client, packageNames)
+	sizes := ClosureSizes(generation, outPaths, graph)
+
+	names := make([]string, 0, len(outPaths))
+	for name := range outPaths {
+		if _, ok := sizes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// ストアにないパスを含めると nix path-info 全体が失敗するので1つずつ調べる
+	for _, name := range names {
+		infos, err := client.PathInfo([]string{outPaths[name]}, false)
+		if err != nil {
+			continue
+		}
+		if info, ok := infos[outPaths[name]]; ok {
+			sizes[name] = PackageSize{Closure: info.ClosureSize, Unique: -1}
+		}
+	}
+
+	return sizes, nil
+}
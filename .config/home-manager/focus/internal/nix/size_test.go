@@ -0,0 +1,89 @@
+package nix
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParsePathInfo tests both the object (Nix 2.19+) and array JSON formats
+func TestParsePathInfo(t *testing.T) {
+	want := map[string]StorePathInfo{
+		"/nix/store/a-ripgrep": {NarSize: 100, ClosureSize: 300, References: []string{"/nix/store/b-glibc"}},
+	}
+
+	object := `{"/nix/store/a-ripgrep":{"narSize":100,"closureSize":300,"references":["/nix/store/b-glibc"]}}`
+	got, err := parsePathInfo([]byte(object))
+	if err != nil {
+		t.Fatalf("parsePathInfo failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("object format: got %+v, want %+v", got, want)
+	}
+
+	array := `[{"path":"/nix/store/a-ripgrep","narSize":100,"closureSize":300,"references":["/nix/store/b-glibc"]}]`
+	got, err = parsePathInfo([]byte(array))
+	if err != nil {
+		t.Fatalf("parsePathInfo failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("array format: got %+v, want %+v", got, want)
+	}
+
+	if _, err := parsePathInfo([]byte("not json")); err == nil {
+		t.Error("Expected error for invalid output")
+	}
+}
+
+// TestClosureSizes tests closure and unique sizes computed from the reference graph
+func TestClosureSizes(t *testing.T) {
+	graph := map[string]StorePathInfo{
+		"/gen":       {NarSize: 1, References: []string{"/home-path"}},
+		"/home-path": {NarSize: 2, References: []string{"/ripgrep", "/fd"}},
+		"/ripgrep":   {NarSize: 100, References: []string{"/pcre2", "/glibc"}},
+		"/fd":        {NarSize: 50, References: []string{"/glibc"}},
+		"/pcre2":     {NarSize: 30, References: []string{"/glibc"}},
+		"/glibc":     {NarSize: 1000, References: []string{"/glibc"}},
+	}
+
+	got := ClosureSizes("/gen", map[string]string{
+		"ripgrep": "/ripgrep",
+		"fd":      "/fd",
+		"missing": "/missing",
+	}, graph)
+
+	want := map[string]PackageSize{
+		// glibc は fd も使うので ripgrep 固有ではない
+		"ripgrep": {Closure: 1130, Unique: 130},
+		"fd":      {Closure: 1050, Unique: 50},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ClosureSizes = %+v, want %+v", got, want)
+	}
+}
+
+// TestPackageSizesFallsBackToClosureSize tests packages outside the generation graph
+func TestPackageSizesFallsBackToClosureSize(t *testing.T) {
+	mock := NewMockClient()
+	mock.PathInfos = map[string]StorePathInfo{
+		"/gen":                    {NarSize: 1, References: []string{"/nix/store/mock-fd"}},
+		"/nix/store/mock-fd":      {NarSize: 50, ClosureSize: 50},
+		"/nix/store/mock-ripgrep": {NarSize: 100, ClosureSize: 400},
+	}
+
+	sizes, err := PackageSizes(mock, "/gen", []string{"fd", "ripgrep", "unknown"})
+	if err != nil {
+		t.Fatalf("PackageSizes failed: %v", err)
+	}
+
+	if sizes["fd"] != (PackageSize{Closure: 50, Unique: 50}) {
+		t.Errorf("fd = %+v", sizes["fd"])
+	}
+	// 世代にないがストアにはあるパッケージはクロージャのサイズだけ
+	if sizes["ripgrep"] != (PackageSize{Closure: 400, Unique: -1}) {
+		t.Errorf("ripgrep = %+v", sizes["ripgrep"])
+	}
+	// ストアにないパッケージは結果に含めない
+	if _, ok := sizes["unknown"]; ok {
+		t.Errorf("Expected no size for unknown, got %+v", sizes["unknown"])
+	}
+}
@@ -0,0 +1,139 @@
+package nix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"focus/internal/state"
+)
+
+const (
+	// failureTailLines は失敗時にエラーに含める出力の末尾の行数
+	failureTailLines = 20
+	// maxLogFiles は残しておくログファイルの数
+	maxLogFiles = 50
+)
+
+// runStreaming は cmd の標準出力と標準エラー出力を out に流しながら、
+// 状態ディレクトリ配下の logs にも書き出す
+// 失敗した場合は出力の末尾とログファイルのパスをエラーに含める
+func runStreaming(cmd *exec.Cmd, label string, out io.Writer) error {
+	tail := newTailBuffer(failureTailLines)
+	writers := []io.Writer{out, tail}
+
+	logPath, logFile, err := createLogFile(label)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "警告: ログファイルを作成できません: %v\n", err)
+	} else {
+		defer logFile.Close()
+		fmt.Fprintf(logFile, "$ %s\n", strings.Join(cmd.Args, " "))
+		writers = append(writers, logFile)
+	}
+
+	// 同じ Writer を渡すと exec は Write を並行して呼ばない
+	w := io.MultiWriter(writers...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	if err := cmd.Run(); err != nil {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s の実行に失敗: %s", strings.Join(cmd.Args[:min(2, len(cmd.Args))], " "), err)
+		if lines := tail.Lines(); len(lines) > 0 {
+			fmt.Fprintf(&b, "\n--- 出力の末尾 %d 行 ---\n%s", len(lines), strings.Join(lines, "\n"))
+		}
+		if logPath != "" {
+			fmt.Fprintf(&b, "\n完全なログ: %s", logPath)
+		}
+		return fmt.Errorf("%s", b.String())
+	}
+
+	return nil
+}
+
+// createLogFile は実行ごとのログファイルを作成する
+func createLogFile(label string) (string, *os.File, error) {
+	dir, err := state.SubDir("logs")
+	if err != nil {
+		return "", nil, err
+	}
+
+	pruneLogs(dir, maxLogFiles-1)
+
+	name := fmt.Sprintf("%s-%s.log", time.Now().Format("20060102-150405.000"), label)
+	path := filepath.Join(dir, name)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("ログファイルの作成に失敗: %w", err)
+	}
+
+	return path, file, nil
+}
+
+// pruneLogs は古いログファイルを削除し、新しいものを keep 個だけ残す
+// ファイル名は日時で始まるので、名前順が作成順になる
+func pruneLogs(dir string, keep int) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil || len(files) <= keep {
+		return
+	}
+
+	sort.Strings(files)
+	for _, file := range files[:len(files)-keep] {
+		os.Remove(file)
+	}
+}
+
+// tailBuffer は書き込まれた出力の最後の n 行だけを保持する
+type tailBuffer struct {
+	n       int
+	lines   []string
+	partial bytes.Buffer
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{n: n}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.partial.Write(p)
+
+	for {
+		data := t.partial.Bytes()
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			break
+		}
+
+		t.push(string(data[:idx]))
+		t.partial.Next(idx + 1)
+	}
+
+	return len(p), nil
+}
+
+func (t *tailBuffer) push(line string) {
+	t.lines = append(t.lines, strings.TrimRight(line, "\r"))
+	if len(t.lines) > t.n {
+		t.lines = t.lines[len(t.lines)-t.n:]
+	}
+}
+
+// Lines は保持している行を返す（改行で終わっていない最後の行も含む）
+func (t *tailBuffer) Lines() []string {
+	lines := append([]string{}, t.lines...)
+	if t.partial.Len() > 0 {
+		lines = append(lines, t.partial.String())
+		if len(lines) > t.n {
+			lines = lines[len(lines)-t.n:]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,93 @@
+package nix
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunStreamingSuccess tests that output is streamed and written to a log file
+func TestRunStreamingSuccess(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("FOCUS_STATE_DIR", stateDir)
+
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", "echo building; echo warning >&2")
+	if err := runStreaming(cmd, "switch", &out); err != nil {
+		t.Fatalf("runStreaming failed: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "building") || !strings.Contains(out.String(), "warning") {
+		t.Errorf("Output was not streamed: %q", out.String())
+	}
+
+	logs, err := filepath.Glob(filepath.Join(stateDir, "logs", "*-switch.log"))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("Expected one log file, got %v (%v)", logs, err)
+	}
+	data, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(data), "building") || !strings.HasPrefix(string(data), "$ sh -c") {
+		t.Errorf("Unexpected log content: %q", data)
+	}
+}
+
+// TestRunStreamingFailure tests that the error has the output tail and the log path
+func TestRunStreamingFailure(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("FOCUS_STATE_DIR", stateDir)
+
+	script := "for i in $(seq 1 30); do echo line$i; done; echo 'error: collision' >&2; exit 1"
+	err := runStreaming(exec.Command("sh", "-c", script), "build", &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("runStreaming should fail")
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "error: collision") || !strings.Contains(msg, "line30") {
+		t.Errorf("Error should contain the output tail: %s", msg)
+	}
+	if strings.Contains(msg, "line10\n") {
+		t.Errorf("Error should only contain the last %d lines: %s", failureTailLines, msg)
+	}
+	if !strings.Contains(msg, filepath.Join(stateDir, "logs")) {
+		t.Errorf("Error should contain the log path: %s", msg)
+	}
+}
+
+// TestTailBuffer tests keeping only the last lines across partial writes
+func TestTailBuffer(t *testing.T) {
+	tail := newTailBuffer(2)
+	tail.Write([]byte("a\nb"))
+	tail.Write([]byte("c\nd\r\ne"))
+
+	got := tail.Lines()
+	want := []string{"d", "e"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Lines() = %q, want %q", got, want)
+	}
+}
+
+// TestPruneLogs tests that only the newest log files are kept
+func TestPruneLogs(t *testing.T) {
+	dir := t.TempDir()
+	for i := 1; i <= 5; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("2026010%d-000000.000-switch.log", i))
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+	}
+
+	pruneLogs(dir, 2)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(files) != 2 || !strings.Contains(files[0], "20260104") || !strings.Contains(files[1], "20260105") {
+		t.Errorf("Unexpected remaining logs: %v", files)
+	}
+}
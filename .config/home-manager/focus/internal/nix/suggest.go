@@ -0,0 +1,140 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"focus/internal/safefile"
+)
+
+// maxSuggestions は提示する候補の上限
+const maxSuggestions = 5
+
+// LoadAttributeNames は nixpkgs のトップレベルの属性名を返す
+// 一覧の取得には数秒かかるため、dir 配下にリビジョンごとにキャッシュする
+func LoadAttributeNames(client NixClient, dir string) ([]string, error) {
+	revision, err := client.NixpkgsRevision()
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("attrnames-%s.json", unsafeFileChars.ReplaceAllString(revision, "_"))
+	path := filepath.Join(dir, name)
+
+	var names []string
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &names); err == nil {
+			return names, nil
+		}
+	}
+
+	names, err = client.AttributeNames()
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(names); err == nil {
+		// キャッシュの書き込みに失敗しても候補の提示は続ける
+		_ = safefile.WriteFile(path, data, 0644)
+	}
+
+	return names, nil
+}
+
+// Suggest は name に近い属性名を近い順に最大 maxSuggestions 件返す
+// 編集距離が小さいもの（ripgerp → ripgrep）と、name で始まるもの（node → nodejs_22）を候補にする
+func Suggest(name string, candidates []string) []string {
+	type scored struct {
+		name  string
+		score int
+	}
+
+	target := strings.ToLower(name)
+	threshold := max(1, len([]rune(target))/3)
+
+	var matches []scored
+	for _, candidate := range candidates {
+		lower := strings.ToLower(candidate)
+		if lower == target && candidate == name {
+			continue
+		}
+
+		score := -1
+		if lower == target {
+			// 大文字小文字だけが違うものを最優先にする
+			score = 0
+		} else if strings.HasPrefix(lower, target) {
+			score = 1
+		}
+
+		lengthDiff := len(lower) - len(target)
+		if lengthDiff < 0 {
+			lengthDiff = -lengthDiff
+		}
+		if lengthDiff <= threshold {
+			if distance := editDistance(target, lower); distance <= threshold && (score == -1 || distance < score) {
+				score = distance
+			}
+		}
+
+		if score >= 0 {
+			matches = append(matches, scored{name: candidate, score: score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score < matches[j].score
+		}
+		if len(matches[i].name) != len(matches[j].name) {
+			return len(matches[i].name) < len(matches[j].name)
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	suggestions := make([]string, 0, maxSuggestions)
+	for _, match := range matches {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, match.name)
+	}
+
+	return suggestions
+}
+
+// editDistance は隣接文字の入れ替えを1操作と数える編集距離（OSA 距離）を返す
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// d[i][j] は ra[:i] と rb[:j] の距離
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,107 @@
+package nix
+
+import (
+	"slices"
+	"testing"
+)
+
+var attributeNames = []string{
+	"ripgrep", "ripgrep-all", "ripsecrets", "grep", "fd", "fzf",
+	"node2nix", "nodejs", "nodejs_20", "nodejs_22", "nodePackages", "nodo",
+	"bat", "jq", "yq", "yq-go",
+}
+
+// TestSuggest tests ranking by edit distance and prefix
+func TestSuggest(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    string
+		contains []string
+	}{
+		{"ripgerp", "ripgrep", nil},
+		{"node", "nodo", []string{"nodejs_22", "nodejs"}},
+		{"fdz", "fd", nil},
+		{"NodeJS", "nodejs", nil},
+	}
+
+	for _, tt := range tests {
+		got := Suggest(tt.name, attributeNames)
+		if len(got) == 0 || got[0] != tt.first {
+			t.Errorf("Suggest(%q) = %q, want %q first", tt.name, got, tt.first)
+			continue
+		}
+		for _, want := range tt.contains {
+			if !slices.Contains(got, want) {
+				t.Errorf("Suggest(%q) = %q, should contain %q", tt.name, got, want)
+			}
+		}
+		if len(got) > maxSuggestions {
+			t.Errorf("Suggest(%q) returned %d suggestions", tt.name, len(got))
+		}
+	}
+
+	if got := Suggest("zzzzzz", attributeNames); len(got) != 0 {
+		t.Errorf("Suggest should return nothing for unrelated names: %q", got)
+	}
+}
+
+// TestEditDistance tests the OSA distance including transpositions
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"ripgrep", "ripgrep", 0},
+		{"ripgerp", "ripgrep", 1},
+		{"rg", "ripgrep", 5},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// attributeCountingClient は AttributeNames の呼び出し回数を数える
+type attributeCountingClient struct {
+	*MockClient
+	calls int
+}
+
+func (c *attributeCountingClient) AttributeNames() ([]string, error) {
+	c.calls++
+	return c.MockClient.AttributeNames()
+}
+
+// TestLoadAttributeNamesCache tests that attribute names are cached per revision
+func TestLoadAttributeNamesCache(t *testing.T) {
+	dir := t.TempDir()
+	client := &attributeCountingClient{MockClient: NewMockClient()}
+	client.Attributes = attributeNames
+
+	for range 2 {
+		names, err := LoadAttributeNames(client, dir)
+		if err != nil {
+			t.Fatalf("LoadAttributeNames failed: %v", err)
+		}
+		if len(names) != len(attributeNames) {
+			t.Fatalf("Unexpected names: %q", names)
+		}
+	}
+
+	if client.calls != 1 {
+		t.Errorf("AttributeNames should be called once, got %d", client.calls)
+	}
+
+	// リビジョンが変われば取り直す
+	client.Revision = "other-revision"
+	if _, err := LoadAttributeNames(client, dir); err != nil {
+		t.Fatalf("LoadAttributeNames failed: %v", err)
+	}
+	if client.calls != 2 {
+		t.Errorf("AttributeNames should be called again for a new revision, got %d", client.calls)
+	}
+}
@@ -0,0 +1,222 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"focus/internal/safefile"
+)
+
+// maxVersionWorkers は nix eval を同時に実行する上限
+const maxVersionWorkers = 8
+
+// VersionCache は nixpkgs のリビジョンごとにパッケージのバージョンを保存する
+// 同じリビジョンならバージョンは変わらないので、期限は設けない
+type VersionCache struct {
+	path     string
+	mu       sync.Mutex
+	versions map[string]string
+	dirty    bool
+	// refresh なら Get はキャッシュを返さない（取得し直した値で上書きする）
+	refresh bool
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// LoadVersionCache は dir 配下から revision に対応するキャッシュを読み込む
+// ファイルがなければ空のキャッシュを返す
+func LoadVersionCache(dir, revision string) (*VersionCache, error) {
+	name := fmt.Sprintf("versions-%s.json", unsafeFileChars.ReplaceAllString(revision, "_"))
+	cache := &VersionCache{
+		path:     filepath.Join(dir, name),
+		versions: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("バージョンキャッシュの読み込みに失敗: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cache.versions); err != nil {
+		// 壊れたキャッシュは捨てて作り直す
+		cache.versions = make(map[string]string)
+	}
+
+	return cache, nil
+}
+
+// Refresh はキャッシュを読まずに取得し直し、結果でキャッシュを上書きするようにする
+func (c *VersionCache) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh = true
+}
+
+// Get はキャッシュされたバージョンを返す
+func (c *VersionCache) Get(packageName string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refresh {
+		return "", false
+	}
+
+	version, ok := c.versions[packageName]
+	return version, ok
+}
+
+// Set はバージョンを記録する。"unknown" は一時的な失敗の可能性があるため記録しない
+func (c *VersionCache) Set(packageName, version string) {
+	if version == "" || version == "unknown" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.versions[packageName] != version {
+		c.versions[packageName] = version
+		c.dirty = true
+	}
+}
+
+// Save は変更があればキャッシュを書き出す
+func (c *VersionCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.versions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("バージョンキャッシュのシリアライズに失敗: %w", err)
+	}
+
+	if err := safefile.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("バージョンキャッシュの書き込みに失敗: %w", err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// LookupVersions は複数パッケージのバージョンを並列に取得する
+// cache が nil でなければキャッシュを優先し、取得した結果を記録する
+func LookupVersions(client NixClient, packageNames []string, cache *VersionCache) map[string]string {
+	versions := make(map[string]string, len(packageNames))
+	var mu sync.Mutex
+
+	pending := make([]string, 0, len(packageNames))
+	for _, name := range packageNames {
+		if cache != nil {
+			if version, ok := cache.Get(name); ok {
+				versions[name] = version
+				continue
+			}
+		}
+		pending = append(pending, name)
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+
+	workers := min(maxVersionWorkers, len(pending))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				version, err := client.GetPackageVersion(name)
+				if err != nil {
+					version = "unknown"
+				}
+
+				if cache != nil {
+					cache.Set(name, version)
+				}
+
+				mu.Lock()
+				versions[name] = version
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range pending {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+
+	return versions
+}
+
+// CompareVersions は builtins.compareVersions と同じ規則でバージョンを比較する
+// a が古ければ -1、同じなら 0、新しければ 1 を返す
+func CompareVersions(a, b string) int {
+	for a != "" || b != "" {
+		var ca, cb string
+		ca, a = nextVersionComponent(a)
+		cb, b = nextVersionComponent(b)
+
+		if versionComponentLess(ca, cb) {
+			return -1
+		}
+		if versionComponentLess(cb, ca) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// nextVersionComponent は . と - を区切りとして、数字の並びか数字以外の並びを1つ取り出す
+func nextVersionComponent(s string) (string, string) {
+	s = strings.TrimLeft(s, ".-")
+	if s == "" {
+		return "", ""
+	}
+
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	digits := isDigit(s[0])
+
+	end := 0
+	for end < len(s) && s[end] != '.' && s[end] != '-' && isDigit(s[end]) == digits {
+		end++
+	}
+	return s[:end], s[end:]
+}
+
+// versionComponentLess は Nix の componentsLT と同じく、
+// 数字同士は数値で比較し、"pre" は何よりも古く、数字は文字列より新しいとみなす
+func versionComponentLess(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+
+	switch {
+	case errA == nil && errB == nil:
+		return na < nb
+	case a == "" && errB == nil:
+		return true
+	case a == "pre" && b != "pre":
+		return true
+	case b == "pre":
+		return false
+	case errB == nil:
+		return true
+	case errA == nil:
+		return false
+	}
+	return a < b
+}
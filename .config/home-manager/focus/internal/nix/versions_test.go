@@ -0,0 +1,201 @@
+package nix
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingClient は GetPackageVersion の呼び出し回数と同時実行数を記録する
+type countingClient struct {
+	*MockClient
+	calls   atomic.Int32
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (c *countingClient) GetPackageVersion(packageName string) (string, error) {
+	c.calls.Add(1)
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+
+	for {
+		peak := c.peak.Load()
+		if n <= peak || c.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if packageName == "broken" {
+		return "unknown", nil
+	}
+	return "v-" + packageName, nil
+}
+
+// TestLookupVersionsParallel tests that lookups run concurrently but bounded
+func TestLookupVersionsParallel(t *testing.T) {
+	client := &countingClient{MockClient: NewMockClient()}
+
+	names := make([]string, 30)
+	for i := range names {
+		names[i] = fmt.Sprintf("pkg%d", i)
+	}
+
+	versions := LookupVersions(client, names, nil)
+
+	if len(versions) != len(names) {
+		t.Fatalf("Expected %d versions, got %d", len(names), len(versions))
+	}
+	for _, name := range names {
+		if versions[name] != "v-"+name {
+			t.Errorf("Version mismatch for %s: %s", name, versions[name])
+		}
+	}
+
+	if peak := client.peak.Load(); peak < 2 || peak > maxVersionWorkers {
+		t.Errorf("Unexpected concurrency: peak %d, limit %d", peak, maxVersionWorkers)
+	}
+}
+
+// TestLookupVersionsCache tests that cached versions are not looked up again
+func TestLookupVersionsCache(t *testing.T) {
+	dir := t.TempDir()
+	client := &countingClient{MockClient: NewMockClient()}
+
+	cache, err := LoadVersionCache(dir, "abc123")
+	if err != nil {
+		t.Fatalf("LoadVersionCache failed: %v", err)
+	}
+
+	LookupVersions(client, []string{"ripgrep", "fd", "broken"}, cache)
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if client.calls.Load() != 3 {
+		t.Fatalf("Expected 3 lookups, got %d", client.calls.Load())
+	}
+
+	// 同じリビジョンのキャッシュを読み直す
+	cache, err = LoadVersionCache(dir, "abc123")
+	if err != nil {
+		t.Fatalf("LoadVersionCache failed: %v", err)
+	}
+
+	versions := LookupVersions(client, []string{"ripgrep", "fd", "broken"}, cache)
+
+	// unknown はキャッシュされないので broken だけ再取得される
+	if client.calls.Load() != 4 {
+		t.Errorf("Expected only the unknown package to be looked up again, total calls %d", client.calls.Load())
+	}
+	if versions["ripgrep"] != "v-ripgrep" || versions["fd"] != "v-fd" {
+		t.Errorf("Unexpected cached versions: %v", versions)
+	}
+
+	// 別のリビジョンではキャッシュを使わない
+	other, err := LoadVersionCache(dir, "def456")
+	if err != nil {
+		t.Fatalf("LoadVersionCache failed: %v", err)
+	}
+	if _, ok := other.Get("ripgrep"); ok {
+		t.Error("Cache for another revision should be empty")
+	}
+}
+
+// TestVersionCacheRefresh tests that a refreshed lookup ignores and overwrites the cache
+func TestVersionCacheRefresh(t *testing.T) {
+	dir := t.TempDir()
+	client := &countingClient{MockClient: NewMockClient()}
+
+	cache, err := LoadVersionCache(dir, "abc123")
+	if err != nil {
+		t.Fatalf("LoadVersionCache failed: %v", err)
+	}
+	cache.Set("ripgrep", "stale")
+	cache.Set("jq", "1.7")
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	cache, err = LoadVersionCache(dir, "abc123")
+	if err != nil {
+		t.Fatalf("LoadVersionCache failed: %v", err)
+	}
+	cache.Refresh()
+	versions := LookupVersions(client, []string{"ripgrep"}, cache)
+	if client.calls.Load() != 1 || versions["ripgrep"] != "v-ripgrep" {
+		t.Fatalf("Expected ripgrep to be looked up again: %v (%d calls)", versions, client.calls.Load())
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// 取得し直した値で上書きし、他のパッケージの値は残す
+	cache, err = LoadVersionCache(dir, "abc123")
+	if err != nil {
+		t.Fatalf("LoadVersionCache failed: %v", err)
+	}
+	if version, _ := cache.Get("ripgrep"); version != "v-ripgrep" {
+		t.Errorf("Refreshed version was not saved: %s", version)
+	}
+	if version, _ := cache.Get("jq"); version != "1.7" {
+		t.Errorf("Other versions should be kept: %s", version)
+	}
+}
+
+// TestParseFlakeRevision tests extracting the locked revision
+func TestParseFlakeRevision(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{"locked":{"rev":"abc","narHash":"sha256-x"},"revision":"abc"}`, "abc"},
+		{`{"revision":"def"}`, "def"},
+		{`{"locked":{"narHash":"sha256-x"}}`, "sha256-x"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFlakeRevision([]byte(tt.input))
+		if err != nil {
+			t.Errorf("parseFlakeRevision(%s) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseFlakeRevision(%s) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := parseFlakeRevision([]byte(`{}`)); err == nil {
+		t.Error("parseFlakeRevision should fail without a revision")
+	}
+}
+
+// TestCompareVersions tests the builtins.compareVersions ordering
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"14.1.0", "14.1.1", -1},
+		{"1.10", "1.9", 1},
+		{"2.3", "2.3.1", -1},
+		{"2.3a", "2.3.1", -1},
+		{"2.3pre1", "2.3", -1},
+		{"2.3-pre", "2.3", -1},
+		{"0-unstable-2024-05-01", "0-unstable-2024-11-20", -1},
+		{"1.2.3", "1.2.3-rc1", -1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package nixast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AttrSet はソース中の属性セット { ... }
+type AttrSet struct {
+	file *File
+	// open と close は { と } の code 中の位置
+	open  int
+	close int
+}
+
+// FindAttrSet は属性パス（例: "inputs"）に束縛された属性セットを探す
+func (f *File) FindAttrSet(attrPath string) (*AttrSet, error) {
+	parts := strings.Split(attrPath, ".")
+
+	for i := range f.code {
+		if !f.isBindingStart(i) || !f.matchAttrPath(i, parts) {
+			continue
+		}
+
+		j := i + len(parts)*2 - 1
+		if j >= len(f.code) || !f.code[j].Is("=") {
+			continue
+		}
+		j++
+
+		if j >= len(f.code) || !f.code[j].Is("{") {
+			return nil, f.errorf(f.code[i].Start, "%s の値が属性セットではありません", attrPath)
+		}
+
+		return &AttrSet{file: f, open: j, close: f.skipGroup(j) - 1}, nil
+	}
+
+	return nil, fmt.Errorf("%s が見つかりません", attrPath)
+}
+
+// HasAttr は属性セットの直下に name で始まる束縛（name = ... や name.url = ...）か、
+// name を含む inherit があるかを返す
+func (s *AttrSet) HasAttr(name string) bool {
+	code := s.file.code
+	for i := s.open + 1; i < s.close; {
+		tok := code[i]
+		if tok.Kind == TokenIdent && tok.Text == "inherit" {
+			for i++; i < s.close && !code[i].Is(";"); i++ {
+				if code[i].Is("(") {
+					i = s.file.skipGroup(i) - 1
+					continue
+				}
+				if code[i].Kind == TokenIdent && code[i].Text == name {
+					return true
+				}
+			}
+			i++
+			continue
+		}
+		if tok.Kind == TokenIdent && tok.Text == name || tok.Kind == TokenString && tok.Text == fmt.Sprintf("%q", name) {
+			return true
+		}
+
+		// 次の束縛まで読み飛ばす（入れ子の括弧の中の ; は数えない）
+		for i < s.close && !code[i].Is(";") {
+			if code[i].Is("{") || code[i].Is("[") || code[i].Is("(") || code[i].Is("${") {
+				i = s.file.skipGroup(i)
+				continue
+			}
+			i++
+		}
+		i++
+	}
+	return false
+}
+
+// AppendBinding は属性セットの末尾に text（例: foo.url = "...";）を追加したソースを返す
+// インデントは既存の束縛の行に合わせる
+func (s *AttrSet) AppendBinding(text string) []byte {
+	f := s.file
+	src := f.src
+	closeStart := f.code[s.close].Start
+
+	closeLineStart := lineStartOf(src, closeStart)
+	if closeLineStart > f.code[s.open].End && isBlank(src[closeLineStart:closeStart]) {
+		return splice(src, closeLineStart, closeLineStart, s.indent()+text+"\n")
+	}
+
+	return splice(src, closeStart, closeStart, text+" ")
+}
+
+// indent は新しい束縛に使うインデントを返す
+// 最初の束縛の行に合わせ、なければ } の行から1段深くする
+func (s *AttrSet) indent() string {
+	f := s.file
+	src := f.src
+
+	if s.open+1 < s.close {
+		first := f.code[s.open+1]
+		lineStart := lineStartOf(src, first.Start)
+		if isBlank(src[lineStart:first.Start]) {
+			return src[lineStart:first.Start]
+		}
+	}
+
+	closeIndent := leadingBlank(src[lineStartOf(src, f.code[s.close].Start):])
+	if strings.Contains(closeIndent, "\t") {
+		return closeIndent + "\t"
+	}
+	return closeIndent + "  "
+}
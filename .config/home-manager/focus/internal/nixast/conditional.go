@@ -0,0 +1,103 @@
+package nixast
+
+import (
+	"strings"
+)
+
+// Conditional はリストに ++ で続く lib.optionals <条件> [ ... ] のブロック
+type Conditional struct {
+	// Condition は条件式のソース（連続する空白は1つにまとめる）
+	Condition string
+	// Start は ++ のオフセット、End は ] の次のオフセット
+	Start int
+	End   int
+	List  *List
+}
+
+// optionalsFunctions は条件付きリストとして扱う関数
+var optionalsFunctions = [][]string{
+	{"lib", "optionals"},
+	{"pkgs", "lib", "optionals"},
+}
+
+// FindConditionals は list の後に ++ で続く lib.optionals のブロックを返す
+// それ以外の式が続く場合はそこで打ち切る（手書きの式はそのまま残す）
+func (f *File) FindConditionals(list *List) ([]*Conditional, error) {
+	var conditionals []*Conditional
+
+	i := f.codeIndex(list.Close) + 1
+	for i+1 < len(f.code) && f.code[i].Is("++") {
+		plus := i
+		j := f.matchOptionals(i + 1)
+		if j == -1 {
+			break
+		}
+
+		condEnd, err := f.parseSelect(j)
+		if err != nil {
+			return nil, err
+		}
+		if condEnd >= len(f.code) || !f.code[condEnd].Is("[") {
+			break
+		}
+
+		inner, err := f.parseList(condEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		conditionals = append(conditionals, &Conditional{
+			Condition: NormalizeName(f.src[f.code[j].Start:f.code[condEnd-1].End]),
+			Start:     f.code[plus].Start,
+			End:       inner.Close + 1,
+			List:      inner,
+		})
+
+		i = f.codeIndex(inner.Close) + 1
+	}
+
+	return conditionals, nil
+}
+
+// matchOptionals は code[i] から lib.optionals が続けば、その次の位置を返す（なければ -1）
+func (f *File) matchOptionals(i int) int {
+	for _, parts := range optionalsFunctions {
+		if f.matchAttrPath(i, parts) {
+			return i + len(parts)*2 - 1
+		}
+	}
+	return -1
+}
+
+// codeIndex はオフセット offset から始まるトークンの code 中の位置を返す
+func (f *File) codeIndex(offset int) int {
+	for i, tok := range f.code {
+		if tok.Start == offset {
+			return i
+		}
+	}
+	return len(f.code)
+}
+
+// AppendConditional は after の位置に ++ <optionals> <condition> [ text ] を追加したソースを返す
+// after には list の ] の次か、最後のブロックの End を渡す
+// インデントは list の ] の行に合わせる
+func (l *List) AppendConditional(after int, optionals, condition, text string) []byte {
+	src := l.file.src
+	closeIndent := leadingBlank(src[lineStartOf(src, l.Close):])
+
+	block := " ++ " + optionals + " " + condition + " [\n" + l.indent() + text + "\n" + closeIndent + "]"
+	return splice(src, after, after, block)
+}
+
+// RemoveBlock はブロックを ++ の前の空白ごと取り除いたソースを返す
+func (c *Conditional) RemoveBlock() []byte {
+	src := c.List.file.src
+
+	start := c.Start
+	for start > 0 && strings.ContainsRune(" \t\r\n", rune(src[start-1])) {
+		start--
+	}
+
+	return splice(src, start, c.End, "")
+}
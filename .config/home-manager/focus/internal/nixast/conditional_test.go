@@ -0,0 +1,158 @@
+package nixast
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFindConditionals tests locating lib.optionals blocks after home.packages
+func TestFindConditionals(t *testing.T) {
+	file, err := Parse([]byte(handWritten))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	conditionals, err := file.FindConditionals(list)
+	if err != nil {
+		t.Fatalf("FindConditionals failed: %v", err)
+	}
+
+	if len(conditionals) != 1 {
+		t.Fatalf("Expected 1 conditional, got %d", len(conditionals))
+	}
+
+	block := conditionals[0]
+	if block.Condition != "pkgs.stdenv.isDarwin" {
+		t.Errorf("Condition mismatch: got %q", block.Condition)
+	}
+	if names := block.List.Names(); len(names) != 1 || names[0] != "pngpaste" {
+		t.Errorf("Unexpected elements: %v", names)
+	}
+
+	// ブロックを取り除くと ] の直後に ; が続く
+	got := string(block.RemoveBlock())
+	want := strings.Replace(handWritten, "  ] ++ lib.optionals pkgs.stdenv.isDarwin [ pngpaste ];", "  ];", 1)
+	if got != want {
+		t.Errorf("RemoveBlock result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestFindConditionalsStopsAtOtherExpressions tests that hand-written concatenations are left alone
+func TestFindConditionalsStopsAtOtherExpressions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{`{ home.packages = [ fd ]; }`, 0},
+		{`{ home.packages = [ fd ] ++ myTools ++ lib.optionals pkgs.stdenv.isDarwin [ pngpaste ]; }`, 0},
+		{`{ home.packages = [ fd ] ++ lib.optionals (pkgs.stdenv.isLinux && x) [ a ] ++ pkgs.lib.optionals pkgs.stdenv.isDarwin [ b ]; }`, 2},
+		{`{ home.packages = [ fd ] ++ lib.optional pkgs.stdenv.isDarwin pngpaste; }`, 0},
+	}
+
+	for _, tt := range tests {
+		file, err := Parse([]byte(tt.src))
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.src, err)
+		}
+		list, err := file.FindList("home.packages")
+		if err != nil {
+			t.Fatalf("FindList failed: %v", err)
+		}
+		conditionals, err := file.FindConditionals(list)
+		if err != nil {
+			t.Fatalf("FindConditionals(%q) failed: %v", tt.src, err)
+		}
+		if len(conditionals) != tt.want {
+			t.Errorf("FindConditionals(%q) found %d blocks, want %d", tt.src, len(conditionals), tt.want)
+		}
+	}
+}
+
+// TestAppendConditional tests generating a new block with the list's indentation
+func TestAppendConditional(t *testing.T) {
+	file, err := Parse([]byte(handWritten))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	got := string(list.AppendConditional(list.Close+1, "lib.optionals", "pkgs.stdenv.isLinux", "xclip"))
+	want := strings.Replace(handWritten, "  ] ++ lib.optionals", "  ] ++ lib.optionals pkgs.stdenv.isLinux [\n    xclip\n  ] ++ lib.optionals", 1)
+	if got != want {
+		t.Errorf("AppendConditional result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// 生成したブロックも読み直せる
+	reparsed, err := Parse([]byte(got))
+	if err != nil {
+		t.Fatalf("Parse of generated source failed: %v", err)
+	}
+	list, err = reparsed.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+	conditionals, err := reparsed.FindConditionals(list)
+	if err != nil {
+		t.Fatalf("FindConditionals failed: %v", err)
+	}
+	if len(conditionals) != 2 || conditionals[0].Condition != "pkgs.stdenv.isLinux" {
+		t.Errorf("Generated block not found: %+v", conditionals)
+	}
+}
+
+// TestAddFormal tests adding an argument to the module's function
+func TestAddFormal(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		ok   bool
+	}{
+		{"{ pkgs, ... }: { }", "{ pkgs, lib, ... }: { }", true},
+		{"{ pkgs }: { }", "{ pkgs, lib }: { }", true},
+		{"{}: { }", "{ lib }: { }", true},
+		{"args@{ pkgs, ... }: { }", "args@{ pkgs, lib, ... }: { }", true},
+		{"{ pkgs, ... }@args: { }", "{ pkgs, lib, ... }@args: { }", true},
+		{"pkgs: { }", "", false},
+		{"{ home.packages = [ ]; }", "", false},
+	}
+
+	for _, tt := range tests {
+		file, err := Parse([]byte(tt.src))
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.src, err)
+		}
+
+		if file.HasFormal("lib") {
+			t.Errorf("HasFormal(lib) on %q should be false", tt.src)
+		}
+
+		got, ok := file.AddFormal("lib")
+		if ok != tt.ok {
+			t.Errorf("AddFormal on %q returned ok=%v, want %v", tt.src, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("AddFormal on %q = %q, want %q", tt.src, got, tt.want)
+		}
+
+		reparsed, err := Parse(got)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", got, err)
+		}
+		if !reparsed.HasFormal("lib") {
+			t.Errorf("HasFormal(lib) on %q should be true", got)
+		}
+	}
+}
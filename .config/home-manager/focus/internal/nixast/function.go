@@ -0,0 +1,134 @@
+package nixast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Function は属性セットを引数に取る関数 { a, b, ... }: の引数部分
+type Function struct {
+	file *File
+	// open と close は { と } の code 中の位置
+	open  int
+	close int
+}
+
+// Function はファイル全体が属性セットを引数に取る関数なら、その引数部分を返す
+func (f *File) Function() (*Function, bool) {
+	return f.functionAt(0)
+}
+
+// FindFunction は属性パス（例: "outputs"）に束縛された関数の引数部分を返す
+func (f *File) FindFunction(attrPath string) (*Function, error) {
+	parts := strings.Split(attrPath, ".")
+
+	for i := range f.code {
+		if !f.isBindingStart(i) || !f.matchAttrPath(i, parts) {
+			continue
+		}
+
+		j := i + len(parts)*2 - 1
+		if j >= len(f.code) || !f.code[j].Is("=") {
+			continue
+		}
+
+		fn, ok := f.functionAt(j + 1)
+		if !ok {
+			return nil, f.errorf(f.code[i].Start, "%s の値が { ... }: の形の関数ではありません", attrPath)
+		}
+		return fn, nil
+	}
+
+	return nil, fmt.Errorf("%s が見つかりません", attrPath)
+}
+
+// functionAt は code[start] から始まる { ... }: を返す
+// args@{ ... }: と { ... }@args: の形にも対応する
+func (f *File) functionAt(start int) (*Function, bool) {
+	open := start
+	if open+2 < len(f.code) && f.code[open].Kind == TokenIdent && f.code[open+1].Is("@") {
+		open += 2
+	}
+	if open >= len(f.code) || !f.code[open].Is("{") {
+		return nil, false
+	}
+
+	close := f.skipGroup(open) - 1
+	next := close + 1
+	if next+1 < len(f.code) && f.code[next].Is("@") {
+		next += 2
+	}
+	if next >= len(f.code) || !f.code[next].Is(":") {
+		return nil, false
+	}
+
+	return &Function{file: f, open: open, close: close}, true
+}
+
+// HasFormal は引数に name があるかを返す
+func (fn *Function) HasFormal(name string) bool {
+	code := fn.file.code
+	for i := fn.open + 1; i < fn.close; i++ {
+		if code[i].Kind == TokenIdent && code[i].Text == name && (code[i-1].Is("{") || code[i-1].Is(",")) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasEllipsis は引数に ... があるか（任意の引数を受け取るか）を返す
+func (fn *Function) HasEllipsis() bool {
+	for i := fn.open + 1; i < fn.close; i++ {
+		if fn.file.code[i].Is("...") {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFormal は引数に name を加えたソースを返す
+// ... があればその前に、なければ末尾に追加する。1行に1つずつ並んでいれば同じように改行する
+func (fn *Function) AddFormal(name string) []byte {
+	f := fn.file
+	code := f.code
+
+	for i := fn.open + 1; i < fn.close; i++ {
+		if code[i].Is("...") {
+			return splice(f.src, code[i].Start, code[i].Start, name+", ")
+		}
+	}
+
+	if fn.close == fn.open+1 {
+		return splice(f.src, code[fn.close].Start, code[fn.close].Start, " "+name+" ")
+	}
+
+	// 末尾にカンマがあれば、その後に同じ形で追加する
+	last := code[fn.close-1]
+	if last.Is(",") {
+		prev := code[fn.close-2]
+		lineStart := lineStartOf(f.src, prev.Start)
+		if isBlank(f.src[lineStart:prev.Start]) {
+			return splice(f.src, last.End, last.End, "\n"+f.src[lineStart:prev.Start]+name+",")
+		}
+		return splice(f.src, last.End, last.End, " "+name+",")
+	}
+
+	return splice(f.src, last.End, last.End, ", "+name)
+}
+
+// HasFormal はファイル先頭の関数の引数（{ pkgs, ... }:）に name があるかを返す
+// ファイルが属性セットを引数に取る関数でなければ false を返す
+func (f *File) HasFormal(name string) bool {
+	fn, ok := f.Function()
+	return ok && fn.HasFormal(name)
+}
+
+// AddFormal はファイル先頭の関数の引数に name を加えたソースを返す
+// 引数が属性セットでなければ false を返す
+func (f *File) AddFormal(name string) ([]byte, bool) {
+	fn, ok := f.Function()
+	if !ok {
+		return nil, false
+	}
+	return fn.AddFormal(name), true
+}
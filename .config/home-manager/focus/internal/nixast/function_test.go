@@ -0,0 +1,129 @@
+package nixast
+
+import (
+	"strings"
+	"testing"
+)
+
+const flakeSource = `{
+  inputs = {
+    nixpkgs.url = "github:nixos/nixpkgs?ref=nixos-unstable";
+    home-manager = {
+      url = "github:nix-community/home-manager";
+      inputs.nixpkgs.follows = "nixpkgs";
+    };
+  };
+
+  outputs = {
+   self,
+   nixpkgs,
+   home-manager,
+  } @ inputs : {
+    homeConfigurations = { };
+  };
+}
+`
+
+// TestFindFunction tests adding an argument to a function bound to an attribute
+func TestFindFunction(t *testing.T) {
+	file, err := Parse([]byte(flakeSource))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if _, ok := file.Function(); ok {
+		t.Error("The flake itself is not a function")
+	}
+
+	outputs, err := file.FindFunction("outputs")
+	if err != nil {
+		t.Fatalf("FindFunction failed: %v", err)
+	}
+
+	if !outputs.HasFormal("nixpkgs") || outputs.HasFormal("inputs") || outputs.HasEllipsis() {
+		t.Errorf("Unexpected formals in %q", flakeSource)
+	}
+
+	// 1行に1つずつ並んでいれば同じ形で追加する
+	got := string(outputs.AddFormal("nixpkgs-stable"))
+	want := strings.Replace(flakeSource, "   home-manager,\n", "   home-manager,\n   nixpkgs-stable,\n", 1)
+	if got != want {
+		t.Errorf("AddFormal result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := file.FindFunction("inputs"); err == nil {
+		t.Error("FindFunction should fail for an attribute set")
+	}
+	if _, err := file.FindFunction("missing"); err == nil {
+		t.Error("FindFunction should fail for a missing attribute")
+	}
+}
+
+// TestAddFormalTrailingComma tests inline formals ending with a comma
+func TestAddFormalTrailingComma(t *testing.T) {
+	file, err := Parse([]byte("{ outputs = { self, nixpkgs, }: { }; }"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	outputs, err := file.FindFunction("outputs")
+	if err != nil {
+		t.Fatalf("FindFunction failed: %v", err)
+	}
+
+	if got := string(outputs.AddFormal("extra")); got != "{ outputs = { self, nixpkgs, extra, }: { }; }" {
+		t.Errorf("AddFormal = %q", got)
+	}
+}
+
+// TestFindAttrSet tests locating inputs and appending a binding with matching indentation
+func TestFindAttrSet(t *testing.T) {
+	file, err := Parse([]byte(flakeSource))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	inputs, err := file.FindAttrSet("inputs")
+	if err != nil {
+		t.Fatalf("FindAttrSet failed: %v", err)
+	}
+
+	for name, want := range map[string]bool{"nixpkgs": true, "home-manager": true, "url": false, "nixpkgs-stable": false} {
+		if got := inputs.HasAttr(name); got != want {
+			t.Errorf("HasAttr(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	// inherit した名前も束縛として数える（inherit (x) の x は数えない）
+	file, err = Parse([]byte(`{ extraSpecialArgs = { inherit (self) outputs; inherit inputs hostname; }; }`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	args, err := file.FindAttrSet("extraSpecialArgs")
+	if err != nil {
+		t.Fatalf("FindAttrSet failed: %v", err)
+	}
+	for name, want := range map[string]bool{"hostname": true, "inputs": true, "outputs": true, "self": false} {
+		if got := args.HasAttr(name); got != want {
+			t.Errorf("HasAttr(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	got := string(inputs.AppendBinding(`extra.url = "github:o/r";`))
+	want := strings.Replace(flakeSource, "    };\n  };\n", "    };\n    extra.url = \"github:o/r\";\n  };\n", 1)
+	if got != want {
+		t.Errorf("AppendBinding result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	inline, err := Parse([]byte(`{ inputs = { a.url = "x"; }; }`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	inputs, err = inline.FindAttrSet("inputs")
+	if err != nil {
+		t.Fatalf("FindAttrSet failed: %v", err)
+	}
+	if got := string(inputs.AppendBinding(`b.url = "y";`)); got != `{ inputs = { a.url = "x"; b.url = "y"; }; }` {
+		t.Errorf("AppendBinding (inline) = %q", got)
+	}
+}
@@ -0,0 +1,271 @@
+package nixast
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TokenKind はトークンの種類
+type TokenKind int
+
+const (
+	TokenIdent TokenKind = iota
+	TokenNumber
+	TokenString
+	TokenPath
+	TokenURI
+	TokenComment
+	TokenPunct
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenIdent:
+		return "ident"
+	case TokenNumber:
+		return "number"
+	case TokenString:
+		return "string"
+	case TokenPath:
+		return "path"
+	case TokenURI:
+		return "uri"
+	case TokenComment:
+		return "comment"
+	case TokenPunct:
+		return "punct"
+	}
+	return "unknown"
+}
+
+// Token はソース上の1トークン
+// Start/End はソース中のバイトオフセット（End は含まない）
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Start int
+	End   int
+}
+
+// Is はトークンが指定された記号または識別子かを返す
+func (t Token) Is(text string) bool {
+	return (t.Kind == TokenPunct || t.Kind == TokenIdent) && t.Text == text
+}
+
+var (
+	pathPattern       = regexp.MustCompile(`^(~|[a-zA-Z0-9._\-+]*)(/[a-zA-Z0-9._\-+]+)+/?`)
+	searchPathPattern = regexp.MustCompile(`^<[a-zA-Z0-9._\-+]+(/[a-zA-Z0-9._\-+]+)*>`)
+	uriPattern        = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+\-.]*:[a-zA-Z0-9%/?:@&=+$,\-_.!~*']+`)
+	numberPattern     = regexp.MustCompile(`^([0-9]+\.?[0-9]*|\.[0-9]+)([Ee][+-]?[0-9]+)?`)
+	identPattern      = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'\-]*`)
+)
+
+// 長いものから順に照合する
+var punctuations = []string{
+	"...", "${",
+	"++", "//", "==", "!=", "<=", ">=", "&&", "||", "->",
+	"{", "}", "[", "]", "(", ")", ";", ":", ",", ".", "=", "?", "@", "!", "+", "-", "*", "/", "<", ">",
+}
+
+type lexer struct {
+	src    string
+	pos    int
+	tokens []Token
+}
+
+// Lex はNixソースをトークン列に分解する
+// 空白は捨て、コメントは TokenComment として残す
+func Lex(src []byte) ([]Token, error) {
+	l := &lexer{src: string(src)}
+	if err := l.run(len(l.src), false); err != nil {
+		return nil, err
+	}
+	return l.tokens, nil
+}
+
+// run は end まで、または inInterp の場合は対応する } までトークンを読む
+func (l *lexer) run(end int, inInterp bool) error {
+	depth := 0
+
+	for l.pos < end {
+		c := l.src[l.pos]
+
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			l.pos++
+			continue
+		}
+
+		start := l.pos
+		rest := l.src[l.pos:end]
+
+		switch {
+		case c == '#':
+			nl := strings.IndexByte(rest, '\n')
+			if nl == -1 {
+				nl = len(rest)
+			}
+			l.emit(TokenComment, start, start+nl)
+
+		case strings.HasPrefix(rest, "/*"):
+			closeIdx := strings.Index(rest[2:], "*/")
+			if closeIdx == -1 {
+				return l.errorf(start, "コメントが閉じられていません")
+			}
+			l.emit(TokenComment, start, start+2+closeIdx+2)
+
+		case c == '"':
+			if err := l.lexString(end); err != nil {
+				return err
+			}
+
+		case strings.HasPrefix(rest, "''"):
+			if err := l.lexIndentedString(end); err != nil {
+				return err
+			}
+
+		case searchPathPattern.MatchString(rest):
+			l.emit(TokenPath, start, start+len(searchPathPattern.FindString(rest)))
+
+		case pathPattern.MatchString(rest) && !strings.HasPrefix(rest, "//"):
+			l.emit(TokenPath, start, start+len(pathPattern.FindString(rest)))
+
+		case uriPattern.MatchString(rest):
+			l.emit(TokenURI, start, start+len(uriPattern.FindString(rest)))
+
+		case identPattern.MatchString(rest):
+			l.emit(TokenIdent, start, start+len(identPattern.FindString(rest)))
+
+		case numberPattern.MatchString(rest) && (c != '.' || len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9'):
+			l.emit(TokenNumber, start, start+len(numberPattern.FindString(rest)))
+
+		default:
+			punct := ""
+			for _, p := range punctuations {
+				if strings.HasPrefix(rest, p) {
+					punct = p
+					break
+				}
+			}
+			if punct == "" {
+				return l.errorf(start, "予期しない文字 %q", c)
+			}
+
+			if inInterp {
+				switch punct {
+				case "{", "${":
+					depth++
+				case "}":
+					if depth == 0 {
+						// 補間の終わり。呼び出し元が } を消費する
+						return nil
+					}
+					depth--
+				}
+			}
+
+			l.emit(TokenPunct, start, start+len(punct))
+		}
+	}
+
+	if inInterp {
+		return l.errorf(l.pos, "${ が閉じられていません")
+	}
+
+	return nil
+}
+
+func (l *lexer) emit(kind TokenKind, start, end int) {
+	l.tokens = append(l.tokens, Token{
+		Kind:  kind,
+		Text:  l.src[start:end],
+		Start: start,
+		End:   end,
+	})
+	l.pos = end
+}
+
+// lexString は "..." 形式の文字列を1トークンとして読む
+func (l *lexer) lexString(end int) error {
+	start := l.pos
+	l.pos++
+
+	for l.pos < end {
+		switch {
+		case l.src[l.pos] == '\\':
+			l.pos += 2
+		case l.src[l.pos] == '"':
+			l.pos++
+			l.tokens = append(l.tokens, Token{Kind: TokenString, Text: l.src[start:l.pos], Start: start, End: l.pos})
+			return nil
+		case strings.HasPrefix(l.src[l.pos:], "${"):
+			if err := l.skipInterpolation(end); err != nil {
+				return err
+			}
+		default:
+			l.pos++
+		}
+	}
+
+	return l.errorf(start, "文字列が閉じられていません")
+}
+
+// lexIndentedString は二重の単引用符で囲まれたインデント文字列を1トークンとして読む
+func (l *lexer) lexIndentedString(end int) error {
+	start := l.pos
+	l.pos += 2
+
+	for l.pos < end {
+		rest := l.src[l.pos:end]
+		switch {
+		case strings.HasPrefix(rest, "'''"), strings.HasPrefix(rest, "''$"):
+			l.pos += 3
+		case strings.HasPrefix(rest, "''\\"):
+			l.pos += 4
+		case strings.HasPrefix(rest, "''"):
+			l.pos += 2
+			l.tokens = append(l.tokens, Token{Kind: TokenString, Text: l.src[start:l.pos], Start: start, End: l.pos})
+			return nil
+		case strings.HasPrefix(rest, "${"):
+			if err := l.skipInterpolation(end); err != nil {
+				return err
+			}
+		default:
+			l.pos++
+		}
+	}
+
+	return l.errorf(start, "文字列が閉じられていません")
+}
+
+// skipInterpolation は文字列中の ${ ... } を読み飛ばす
+// 中身は通常の式として字句解析するが、トークンは文字列に含めて捨てる
+func (l *lexer) skipInterpolation(end int) error {
+	saved := l.tokens
+	l.pos += 2
+
+	if err := l.run(end, true); err != nil {
+		return err
+	}
+
+	// run は対応する } の位置で止まる
+	l.pos++
+	l.tokens = saved
+	return nil
+}
+
+func (l *lexer) errorf(offset int, format string, args ...any) error {
+	line, col := position(l.src, offset)
+	return fmt.Errorf("%d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+// position はバイトオフセットを 1 始まりの行・列に変換する
+func position(src string, offset int) (int, int) {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	before := src[:offset]
+	line := strings.Count(before, "\n") + 1
+	col := offset - strings.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,107 @@
+package nixast
+
+import (
+	"testing"
+)
+
+// TestLexBasic tests tokenizing a small module
+func TestLexBasic(t *testing.T) {
+	src := `{ pkgs, ... }: {
+  # comment
+  home.packages = with pkgs; [ ripgrep ./local.nix <nixpkgs> ];
+}`
+
+	tokens, err := Lex([]byte(src))
+	if err != nil {
+		t.Fatalf("Lex failed: %v", err)
+	}
+
+	expected := []struct {
+		kind TokenKind
+		text string
+	}{
+		{TokenPunct, "{"}, {TokenIdent, "pkgs"}, {TokenPunct, ","}, {TokenPunct, "..."}, {TokenPunct, "}"},
+		{TokenPunct, ":"}, {TokenPunct, "{"}, {TokenComment, "# comment"},
+		{TokenIdent, "home"}, {TokenPunct, "."}, {TokenIdent, "packages"}, {TokenPunct, "="},
+		{TokenIdent, "with"}, {TokenIdent, "pkgs"}, {TokenPunct, ";"}, {TokenPunct, "["},
+		{TokenIdent, "ripgrep"}, {TokenPath, "./local.nix"}, {TokenPath, "<nixpkgs>"},
+		{TokenPunct, "]"}, {TokenPunct, ";"}, {TokenPunct, "}"},
+	}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("Token count mismatch: got %d, want %d: %v", len(tokens), len(expected), tokens)
+	}
+
+	for i, want := range expected {
+		if tokens[i].Kind != want.kind || tokens[i].Text != want.text {
+			t.Errorf("Token[%d] mismatch: got %s %q, want %s %q", i, tokens[i].Kind, tokens[i].Text, want.kind, want.text)
+		}
+		if src[tokens[i].Start:tokens[i].End] != tokens[i].Text {
+			t.Errorf("Token[%d] offsets do not match its text", i)
+		}
+	}
+}
+
+// TestLexStrings tests strings with interpolation and indented strings
+func TestLexStrings(t *testing.T) {
+	tests := map[string]string{
+		`"plain"`:                       `"plain"`,
+		`"escaped \" quote"`:            `"escaped \" quote"`,
+		`"a ${b "}" + c} d"`:            `"a ${b "}" + c} d"`,
+		`"nested ${ { x = 1; }.x }"`:    `"nested ${ { x = 1; }.x }"`,
+		"''\n  echo ''${HOME} ''' \n''": "''\n  echo ''${HOME} ''' \n''",
+		"''${pkgs.hello}/bin''":         "''${pkgs.hello}/bin''",
+	}
+
+	for src, want := range tests {
+		tokens, err := Lex([]byte(src))
+		if err != nil {
+			t.Errorf("Lex(%q) failed: %v", src, err)
+			continue
+		}
+		if len(tokens) != 1 || tokens[0].Kind != TokenString || tokens[0].Text != want {
+			t.Errorf("Lex(%q) = %v, want single string %q", src, tokens, want)
+		}
+	}
+}
+
+// TestLexOperators tests that operators are not mistaken for paths or URIs
+func TestLexOperators(t *testing.T) {
+	src := `a // b ++ [ 1 ] -> x: x / 2 https://example.com/x`
+
+	tokens, err := Lex([]byte(src))
+	if err != nil {
+		t.Fatalf("Lex failed: %v", err)
+	}
+
+	var texts []string
+	for _, tok := range tokens {
+		texts = append(texts, tok.Text)
+	}
+
+	expected := []string{"a", "//", "b", "++", "[", "1", "]", "->", "x", ":", "x", "/", "2", "https://example.com/x"}
+	if len(texts) != len(expected) {
+		t.Fatalf("Token mismatch: got %q, want %q", texts, expected)
+	}
+	for i := range expected {
+		if texts[i] != expected[i] {
+			t.Errorf("Token[%d] mismatch: got %q, want %q", i, texts[i], expected[i])
+		}
+	}
+}
+
+// TestLexErrors tests unterminated constructs
+func TestLexErrors(t *testing.T) {
+	inputs := []string{
+		`"unterminated`,
+		"''unterminated",
+		"/* unterminated",
+		`"${ a "`,
+	}
+
+	for _, src := range inputs {
+		if _, err := Lex([]byte(src)); err == nil {
+			t.Errorf("Lex(%q) should fail", src)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package nixast
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var attrPathPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'\-]*(\.[a-zA-Z_][a-zA-Z0-9_'\-]*)*$`)
+
+// File は字句解析済みのNixソース
+// ソースは保持したまま、編集は必要な範囲だけを書き換える
+type File struct {
+	src    string
+	tokens []Token
+	// code はコメントを除いたトークン
+	code []Token
+}
+
+// List はソース中のリスト式 [ ... ]
+type List struct {
+	file     *File
+	Open     int // [ のオフセット
+	Close    int // ] のオフセット
+	Elements []Element
+}
+
+// Element はリストの1要素
+// Start/End は要素自体の範囲、Comment は同じ行に続くコメント
+type Element struct {
+	Text       string
+	Start      int
+	End        int
+	Comment    string
+	commentEnd int
+}
+
+// Parse はNixソースを解析する
+// 括弧の対応が取れていない場合はエラーを返す
+func Parse(src []byte) (*File, error) {
+	tokens, err := Lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{src: string(src), tokens: tokens}
+	for _, tok := range tokens {
+		if tok.Kind != TokenComment {
+			f.code = append(f.code, tok)
+		}
+	}
+
+	if err := f.checkBalance(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Source は現在のソースを返す
+func (f *File) Source() []byte {
+	return []byte(f.src)
+}
+
+func (f *File) checkBalance() error {
+	pairs := map[string]string{"{": "}", "${": "}", "[": "]", "(": ")"}
+	var stack []Token
+
+	for _, tok := range f.code {
+		if tok.Kind != TokenPunct {
+			continue
+		}
+		if _, ok := pairs[tok.Text]; ok {
+			stack = append(stack, tok)
+			continue
+		}
+		if tok.Text == "}" || tok.Text == "]" || tok.Text == ")" {
+			if len(stack) == 0 {
+				return f.errorf(tok.Start, "対応する開き括弧がない '%s'", tok.Text)
+			}
+			open := stack[len(stack)-1]
+			if pairs[open.Text] != tok.Text {
+				return f.errorf(tok.Start, "'%s' に対応しない '%s'", open.Text, tok.Text)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if len(stack) > 0 {
+		open := stack[len(stack)-1]
+		return f.errorf(open.Start, "'%s' が閉じられていません", open.Text)
+	}
+
+	return nil
+}
+
+// FindList は属性パス（例: "home.packages"）に束縛されたリストを探す
+// 値の先頭にある "with pkgs;" は読み飛ばす
+func (f *File) FindList(attrPath string) (*List, error) {
+	parts := strings.Split(attrPath, ".")
+
+	for i := range f.code {
+		if !f.isBindingStart(i) || !f.matchAttrPath(i, parts) {
+			continue
+		}
+
+		j := i + len(parts)*2 - 1
+		if j >= len(f.code) || !f.code[j].Is("=") {
+			continue
+		}
+		j++
+
+		for j < len(f.code) && f.code[j].Is("with") {
+			// with <式>; を読み飛ばす
+			for j < len(f.code) && !f.code[j].Is(";") {
+				j++
+			}
+			j++
+		}
+
+		if j >= len(f.code) || !f.code[j].Is("[") {
+			return nil, f.errorf(f.code[i].Start, "%s の値がリストではありません", attrPath)
+		}
+
+		return f.parseList(j)
+	}
+
+	return nil, fmt.Errorf("%s が見つかりません", attrPath)
+}
+
+// isBindingStart はトークン i が属性束縛の先頭に来うる位置かを返す
+func (f *File) isBindingStart(i int) bool {
+	if i == 0 {
+		return false
+	}
+	prev := f.code[i-1]
+	return prev.Is("{") || prev.Is(";") || prev.Is("let")
+}
+
+func (f *File) matchAttrPath(i int, parts []string) bool {
+	for k, part := range parts {
+		idx := i + k*2
+		if idx >= len(f.code) {
+			return false
+		}
+		tok := f.code[idx]
+		if tok.Kind != TokenIdent || tok.Text != part {
+			return false
+		}
+		if k < len(parts)-1 && (idx+1 >= len(f.code) || !f.code[idx+1].Is(".")) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseList は code[open] の [ から始まるリストを解析する
+func (f *File) parseList(open int) (*List, error) {
+	list := &List{file: f, Open: f.code[open].Start}
+
+	i := open + 1
+	for i < len(f.code) {
+		if f.code[i].Is("]") {
+			list.Close = f.code[i].Start
+			return list, nil
+		}
+
+		end, err := f.parseElement(i)
+		if err != nil {
+			return nil, err
+		}
+
+		elem := Element{
+			Start: f.code[i].Start,
+			End:   f.code[end-1].End,
+		}
+		elem.Text = f.src[elem.Start:elem.End]
+		elem.commentEnd = elem.End
+		if comment, ok := f.trailingComment(elem.End); ok {
+			elem.Comment = comment.Text
+			elem.commentEnd = comment.End
+		}
+
+		list.Elements = append(list.Elements, elem)
+		i = end
+	}
+
+	return nil, f.errorf(list.Open, "リストが閉じられていません")
+}
+
+// ValidateElement は text をそのままリストの1要素として書けるかを検査する
+// 空白で区切られた複数の要素、コメント、要素として解釈できないものはエラーにする
+func ValidateElement(text string) error {
+	f, err := Parse([]byte(text))
+	if err != nil {
+		return err
+	}
+
+	if len(f.code) != len(f.tokens) {
+		return fmt.Errorf("コメントは含められません")
+	}
+	if len(f.code) == 0 {
+		return fmt.Errorf("空の要素は追加できません")
+	}
+
+	end, err := f.parseElement(0)
+	if err != nil {
+		return err
+	}
+	if end != len(f.code) {
+		return f.errorf(f.code[end].Start, "1つの要素ではありません: '%s' 以降が別の要素になります", f.code[end].Text)
+	}
+	return nil
+}
+
+// parseElement は code[i] から始まるリスト要素を読み、次の要素の位置を返す
+// 要素は 単項 ( "." 属性名 )* ( "or" 単項 )? の形をとる
+func (f *File) parseElement(i int) (int, error) {
+	end, err := f.parseSelect(i)
+	if err != nil {
+		return 0, err
+	}
+
+	if end < len(f.code) && f.code[end].Kind == TokenIdent && f.code[end].Text == "or" {
+		return f.parseSelect(end + 1)
+	}
+
+	return end, nil
+}
+
+func (f *File) parseSelect(i int) (int, error) {
+	end, err := f.parsePrimary(i)
+	if err != nil {
+		return 0, err
+	}
+
+	for end+1 < len(f.code) && f.code[end].Is(".") {
+		attr := f.code[end+1]
+		switch {
+		case attr.Kind == TokenIdent, attr.Kind == TokenString:
+			end += 2
+		case attr.Is("${"):
+			end = f.skipGroup(end + 1)
+		default:
+			return 0, f.errorf(attr.Start, "属性名が必要です")
+		}
+	}
+
+	return end, nil
+}
+
+func (f *File) parsePrimary(i int) (int, error) {
+	if i >= len(f.code) {
+		return 0, fmt.Errorf("予期しないファイルの終わり")
+	}
+
+	tok := f.code[i]
+	switch {
+	case tok.Is("rec") && i+1 < len(f.code) && f.code[i+1].Is("{"):
+		return f.skipGroup(i + 1), nil
+	case tok.Kind == TokenIdent, tok.Kind == TokenNumber, tok.Kind == TokenString,
+		tok.Kind == TokenPath, tok.Kind == TokenURI:
+		return i + 1, nil
+	case tok.Is("("), tok.Is("["), tok.Is("{"):
+		return f.skipGroup(i), nil
+	}
+
+	return 0, f.errorf(tok.Start, "リストの要素として解釈できません: '%s'", tok.Text)
+}
+
+// skipGroup は code[i] の開き括弧に対応する閉じ括弧の次の位置を返す
+// 括弧の対応は Parse で検査済み
+func (f *File) skipGroup(i int) int {
+	depth := 0
+	for ; i < len(f.code); i++ {
+		tok := f.code[i]
+		if tok.Kind != TokenPunct {
+			continue
+		}
+		switch tok.Text {
+		case "(", "[", "{", "${":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(f.code)
+}
+
+// trailingComment は offset と同じ行で直後に続くコメントを返す
+func (f *File) trailingComment(offset int) (Token, bool) {
+	for _, tok := range f.tokens {
+		if tok.Start < offset {
+			continue
+		}
+		if tok.Kind != TokenComment || strings.ContainsRune(f.src[offset:tok.Start], '\n') {
+			return Token{}, false
+		}
+		return tok, true
+	}
+	return Token{}, false
+}
+
+func (f *File) errorf(offset int, format string, args ...any) error {
+	line, col := position(f.src, offset)
+	return fmt.Errorf("%d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+// Name は比較・表示に使う要素の名前を返す
+// 複数行にわたる式は空白を詰めて1行にする
+func (e Element) Name() string {
+	return NormalizeName(e.Text)
+}
+
+// IsAttrPath は要素が属性パス（例: python3Packages.black）かを返す
+func (e Element) IsAttrPath() bool {
+	return IsAttrPath(e.Text)
+}
+
+// CommentText は同じ行に続くコメントの本文（# や /* */ を除いたもの）を返す
+// コメントがなければ空文字列を返す
+func (e Element) CommentText() string {
+	text := e.Comment
+	if body, ok := strings.CutPrefix(text, "/*"); ok {
+		text = strings.TrimSuffix(body, "*/")
+	} else {
+		text = strings.TrimPrefix(text, "#")
+	}
+	return NormalizeName(text)
+}
+
+// NormalizeName は連続する空白を1つにまとめる
+func NormalizeName(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// IsAttrPath は text が識別子を . でつないだ属性パスかを返す
+func IsAttrPath(text string) bool {
+	return attrPathPattern.MatchString(text)
+}
+
+// Names は各要素の名前を返す
+func (l *List) Names() []string {
+	names := make([]string, 0, len(l.Elements))
+	for _, elem := range l.Elements {
+		names = append(names, elem.Name())
+	}
+	return names
+}
+
+// Index は名前が name と一致する要素の位置を返す。見つからなければ -1
+func (l *List) Index(name string) int {
+	name = NormalizeName(name)
+	for i, elem := range l.Elements {
+		if elem.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// InsertSorted は text を要素として挿入したソースを返す
+// 属性パスは既存の要素が並んでいればアルファベット順の位置に挿入する
+// リスト外のバイト列は変更しない
+func (l *List) InsertSorted(text string) []byte {
+	src := l.file.src
+
+	// 式は並び順の対象外とし、末尾に追加する
+	for _, elem := range l.Elements {
+		if !IsAttrPath(text) {
+			break
+		}
+		if !elem.IsAttrPath() || elem.Text <= text {
+			continue
+		}
+		lineStart := lineStartOf(src, elem.Start)
+		if isBlank(src[lineStart:elem.Start]) {
+			return splice(src, lineStart, lineStart, l.indent()+text+"\n")
+		}
+		return splice(src, elem.Start, elem.Start, text+" ")
+	}
+
+	closeLineStart := lineStartOf(src, l.Close)
+	lastEnd := l.Open + 1
+	if len(l.Elements) > 0 {
+		lastEnd = l.Elements[len(l.Elements)-1].commentEnd
+	}
+
+	if closeLineStart > lastEnd && isBlank(src[closeLineStart:l.Close]) {
+		return splice(src, closeLineStart, closeLineStart, l.indent()+text+"\n")
+	}
+
+	if len(l.Elements) == 0 && isBlank(src[l.Open+1:l.Close]) {
+		return splice(src, l.Open+1, l.Close, " "+text+" ")
+	}
+
+	return splice(src, lastEnd, lastEnd, " "+text)
+}
+
+// Remove は index 番目の要素を取り除いたソースを返す
+// 要素が単独の行にある場合は行ごと削除する
+func (l *List) Remove(index int) []byte {
+	src := l.file.src
+	elem := l.Elements[index]
+
+	lineStart := lineStartOf(src, elem.Start)
+	lineEnd := strings.IndexByte(src[elem.commentEnd:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(src)
+	} else {
+		lineEnd += elem.commentEnd
+	}
+
+	if isBlank(src[lineStart:elem.Start]) && isBlank(src[elem.commentEnd:lineEnd]) {
+		if lineEnd < len(src) {
+			lineEnd++
+		}
+		return splice(src, lineStart, lineEnd, "")
+	}
+
+	end := elem.commentEnd
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	return splice(src, elem.Start, end, "")
+}
+
+// Replace は index 番目の要素を text に置き換えたソースを返す
+// 同じ行に続くコメントはそのまま残す
+func (l *List) Replace(index int, text string) []byte {
+	elem := l.Elements[index]
+	return splice(l.file.src, elem.Start, elem.End, text)
+}
+
+// SetComment は index 番目の要素の同じ行に続くコメントを text に置き換えたソースを返す
+// 要素が行末にあれば # text を、同じ行に他の要素や ] が続けば /* text */ を使う
+// text が空ならコメントを取り除く
+func (l *List) SetComment(index int, text string) []byte {
+	src := l.file.src
+	elem := l.Elements[index]
+
+	if text == "" {
+		return splice(src, elem.End, elem.commentEnd, "")
+	}
+
+	lineEnd := strings.IndexByte(src[elem.commentEnd:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(src)
+	} else {
+		lineEnd += elem.commentEnd
+	}
+
+	comment := "# " + text
+	if !isBlank(src[elem.commentEnd:lineEnd]) {
+		comment = "/* " + text + " */"
+	}
+	return splice(src, elem.End, elem.commentEnd, " "+comment)
+}
+
+// indent は新しい要素に使うインデントを返す
+// 既存の要素やコメントの行に合わせ、なければ ] の行から1段深くする
+func (l *List) indent() string {
+	src := l.file.src
+
+	for _, elem := range l.Elements {
+		lineStart := lineStartOf(src, elem.Start)
+		if isBlank(src[lineStart:elem.Start]) {
+			return src[lineStart:elem.Start]
+		}
+	}
+
+	for _, tok := range l.file.tokens {
+		if tok.Kind != TokenComment || tok.Start <= l.Open || tok.Start >= l.Close {
+			continue
+		}
+		lineStart := lineStartOf(src, tok.Start)
+		if isBlank(src[lineStart:tok.Start]) {
+			return src[lineStart:tok.Start]
+		}
+	}
+
+	closeLineStart := lineStartOf(src, l.Close)
+	closeIndent := leadingBlank(src[closeLineStart:])
+	if strings.Contains(closeIndent, "\t") {
+		return closeIndent + "\t"
+	}
+	return closeIndent + "  "
+}
+
+func lineStartOf(src string, offset int) int {
+	return strings.LastIndexByte(src[:offset], '\n') + 1
+}
+
+func isBlank(s string) bool {
+	return strings.Trim(s, " \t\r") == ""
+}
+
+func leadingBlank(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+func splice(src string, start, end int, text string) []byte {
+	var builder strings.Builder
+	builder.Grow(len(src) + len(text))
+	builder.WriteString(src[:start])
+	builder.WriteString(text)
+	builder.WriteString(src[end:])
+	return []byte(builder.String())
+}
@@ -0,0 +1,349 @@
+package nixast
+
+import (
+	"strings"
+	"testing"
+)
+
+const handWritten = `# 手書きの設定
+{ pkgs, lib, ... }:
+let
+  extra = [ "x" ];
+in {
+  home.sessionVariables.EDITOR = "nvim";
+
+  home.packages = with pkgs; [
+    # よく使うツール
+    fd
+    ripgrep # 検索
+  ] ++ lib.optionals pkgs.stdenv.isDarwin [ pngpaste ];
+
+  /* ブロックコメント */
+  programs.git.enable = true;
+}
+`
+
+// TestFindList tests locating home.packages in a hand-written module
+func TestFindList(t *testing.T) {
+	file, err := Parse([]byte(handWritten))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	names := list.Names()
+	if len(names) != 2 || names[0] != "fd" || names[1] != "ripgrep" {
+		t.Errorf("Unexpected elements: %v", names)
+	}
+
+	if list.Elements[1].Comment != "# 検索" {
+		t.Errorf("Trailing comment mismatch: got %q", list.Elements[1].Comment)
+	}
+
+	if _, err := file.FindList("home.nonexistent"); err == nil {
+		t.Error("FindList should fail for a missing attribute")
+	}
+}
+
+// TestInsertSortedPreservesRest tests that only the list line changes
+func TestInsertSortedPreservesRest(t *testing.T) {
+	file, err := Parse([]byte(handWritten))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	got := string(list.InsertSorted("jq"))
+	want := strings.Replace(handWritten, "    fd\n", "    fd\n    jq\n", 1)
+	if got != want {
+		t.Errorf("InsertSorted result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = string(list.InsertSorted("zoxide"))
+	want = strings.Replace(handWritten, "    ripgrep # 検索\n", "    ripgrep # 検索\n    zoxide\n", 1)
+	if got != want {
+		t.Errorf("InsertSorted (append) result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestRemovePreservesRest tests removing elements together with their inline comment
+func TestRemovePreservesRest(t *testing.T) {
+	file, err := Parse([]byte(handWritten))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	got := string(list.Remove(list.Index("ripgrep")))
+	want := strings.Replace(handWritten, "    ripgrep # 検索\n", "", 1)
+	if got != want {
+		t.Errorf("Remove result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestInlineList tests editing a list written on a single line
+func TestInlineList(t *testing.T) {
+	tests := []struct {
+		src    string
+		insert string
+		want   string
+	}{
+		{"{ home.packages = [ ]; }", "fd", "{ home.packages = [ fd ]; }"},
+		{"{ home.packages = []; }", "fd", "{ home.packages = [ fd ]; }"},
+		{"{ home.packages = [ bat jq ]; }", "fd", "{ home.packages = [ bat fd jq ]; }"},
+		{"{ home.packages = [ bat ]; }", "fd", "{ home.packages = [ bat fd ]; }"},
+	}
+
+	for _, tt := range tests {
+		file, err := Parse([]byte(tt.src))
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.src, err)
+		}
+		list, err := file.FindList("home.packages")
+		if err != nil {
+			t.Fatalf("FindList failed: %v", err)
+		}
+		if got := string(list.InsertSorted(tt.insert)); got != tt.want {
+			t.Errorf("InsertSorted(%q) on %q = %q, want %q", tt.insert, tt.src, got, tt.want)
+		}
+	}
+
+	file, err := Parse([]byte("{ home.packages = [ bat fd jq ]; }"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+	if got := string(list.Remove(1)); got != "{ home.packages = [ bat jq ]; }" {
+		t.Errorf("Remove on inline list = %q", got)
+	}
+}
+
+// TestInsertIntoEmptyList tests indentation for a list with only a comment or nothing
+func TestInsertIntoEmptyList(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			"{ pkgs, ... }: {\n\thome.packages = with pkgs; [\n\t\t# focus\n\t];\n}\n",
+			"{ pkgs, ... }: {\n\thome.packages = with pkgs; [\n\t\t# focus\n\t\tcurl\n\t];\n}\n",
+		},
+		{
+			"{\n  home.packages = with pkgs; [\n  ];\n}\n",
+			"{\n  home.packages = with pkgs; [\n    curl\n  ];\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		file, err := Parse([]byte(tt.src))
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		list, err := file.FindList("home.packages")
+		if err != nil {
+			t.Fatalf("FindList failed: %v", err)
+		}
+		if got := string(list.InsertSorted("curl")); got != tt.want {
+			t.Errorf("InsertSorted mismatch\ngot:\n%q\nwant:\n%q", got, tt.want)
+		}
+	}
+}
+
+// TestParseErrors tests that unbalanced or non-list values are rejected
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{"{ a = [ 1 ; }", "{ a = ( ]; }", "{ a = 1; } }"} {
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Errorf("Parse(%q) should fail", src)
+		}
+	}
+
+	file, err := Parse([]byte("{ home.packages = pkgs.hello; }"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if _, err := file.FindList("home.packages"); err == nil {
+		t.Error("FindList should fail when the value is not a list")
+	}
+}
+
+// TestAttrPathAndExpressionElements tests dotted paths and multi-line expressions
+func TestAttrPathAndExpressionElements(t *testing.T) {
+	src := `{ pkgs, ... }: {
+  home.packages = with pkgs; [
+    claude-code
+    (buildGoModule {
+      pname = "focus";
+      version = "1.0.0";
+      src = ./focus;   
+      vendorHash = "sha256-+D5jLcFWr5djg36xaiHzPFPnZ6XFMPrr+QAj3WA/Yq8="; 
+    })
+    nodePackages.prettier
+    python3Packages.black # formatter
+    vimPlugins.nvim-treesitter.withAllGrammars
+    (callPackage ./x {})
+    pkgs."hello-unfree" or hello
+  ];
+}
+`
+
+	file, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	expected := []string{
+		"claude-code",
+		`(buildGoModule { pname = "focus"; version = "1.0.0"; src = ./focus; vendorHash = "sha256-+D5jLcFWr5djg36xaiHzPFPnZ6XFMPrr+QAj3WA/Yq8="; })`,
+		"nodePackages.prettier",
+		"python3Packages.black",
+		"vimPlugins.nvim-treesitter.withAllGrammars",
+		"(callPackage ./x {})",
+		`pkgs."hello-unfree" or hello`,
+	}
+
+	names := list.Names()
+	if len(names) != len(expected) {
+		t.Fatalf("Element count mismatch: got %d, want %d: %q", len(names), len(expected), names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Element[%d] mismatch: got %q, want %q", i, names[i], expected[i])
+		}
+	}
+
+	attrPaths := []bool{true, false, true, true, true, false, false}
+	for i, want := range attrPaths {
+		if got := list.Elements[i].IsAttrPath(); got != want {
+			t.Errorf("Element[%d].IsAttrPath() = %v, want %v", i, got, want)
+		}
+	}
+
+	// 複数行の式を取り除いても他の行は変わらない
+	got := string(list.Remove(1))
+	start := strings.Index(src, "    (buildGoModule")
+	end := strings.Index(src, "    nodePackages")
+	want := src[:start] + src[end:]
+	if got != want {
+		t.Errorf("Remove of multi-line expression mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestReplaceKeepsComment tests replacing an element while keeping its trailing comment
+func TestReplaceKeepsComment(t *testing.T) {
+	file, err := Parse([]byte(handWritten))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	got := string(list.Replace(list.Index("ripgrep"), "pinned.ripgrep"))
+	want := strings.Replace(handWritten, "    ripgrep # 検索\n", "    pinned.ripgrep # 検索\n", 1)
+	if got != want {
+		t.Errorf("Replace result mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestSetComment tests adding, replacing and removing trailing comments
+func TestSetComment(t *testing.T) {
+	file, err := Parse([]byte(handWritten))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	list, err := file.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+
+	if got := list.Elements[list.Index("ripgrep")].CommentText(); got != "検索" {
+		t.Errorf("CommentText = %q", got)
+	}
+	if got := list.Elements[list.Index("fd")].CommentText(); got != "" {
+		t.Errorf("CommentText without a comment = %q", got)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		old  string
+		new  string
+	}{
+		{"fd", "ファイル検索", "    fd\n", "    fd # ファイル検索\n"},
+		{"ripgrep", "grep の代わり", "    ripgrep # 検索\n", "    ripgrep # grep の代わり\n"},
+		{"ripgrep", "", "    ripgrep # 検索\n", "    ripgrep\n"},
+	}
+
+	for _, tt := range tests {
+		got := string(list.SetComment(list.Index(tt.name), tt.text))
+		want := strings.Replace(handWritten, tt.old, tt.new, 1)
+		if got != want {
+			t.Errorf("SetComment(%s, %q) result mismatch\ngot:\n%s\nwant:\n%s", tt.name, tt.text, got, want)
+		}
+	}
+
+	// 同じ行に続きがあれば行コメントにしない
+	inline, err := Parse([]byte("{ home.packages = [ bat /* 古い */ jq ]; }"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	list, err = inline.FindList("home.packages")
+	if err != nil {
+		t.Fatalf("FindList failed: %v", err)
+	}
+	if got := list.Elements[0].CommentText(); got != "古い" {
+		t.Errorf("CommentText of block comment = %q", got)
+	}
+	if got := string(list.SetComment(1, "json")); got != "{ home.packages = [ bat /* 古い */ jq /* json */ ]; }" {
+		t.Errorf("SetComment on inline list = %q", got)
+	}
+	if got := string(list.SetComment(0, "")); got != "{ home.packages = [ bat jq ]; }" {
+		t.Errorf("SetComment removing block comment = %q", got)
+	}
+}
+
+// TestValidateElement tests accepting exactly one list element without comments
+func TestValidateElement(t *testing.T) {
+	valid := []string{
+		"ripgrep",
+		"python3Packages.black",
+		"(callPackage ./my-tool.nix {})",
+		"pkgs.foo or pkgs.bar",
+		`(writeShellScriptBin "hi" "echo # not a comment")`,
+	}
+	for _, text := range valid {
+		if err := ValidateElement(text); err != nil {
+			t.Errorf("ValidateElement(%q) failed: %v", text, err)
+		}
+	}
+
+	invalid := []string{"", "  ", "foo bar", "hello # x", "hello /* x */", "ripgrep,", "(foo", "foo;"}
+	for _, text := range invalid {
+		if err := ValidateElement(text); err == nil {
+			t.Errorf("ValidateElement(%q) should fail", text)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package nixfile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"focus/internal/nixast"
+	"focus/internal/safefile"
+)
+
+// StableChannel は pkgs.stable として追加する安定版の nixpkgs のチャンネル名
+const StableChannel = "stable"
+
+// StableInput は Flake環境で安定版の nixpkgs に使う flake の入力名
+const StableInput = "nixpkgs-stable"
+
+// ChannelEntry はチャンネルのパッケージの home.packages の要素を返す（例: stable.terraform）
+func ChannelEntry(channel, name string) string {
+	return channel + "." + name
+}
+
+// EntryChannel は要素がチャンネルのパッケージなら、チャンネル名と元の属性パスを返す
+// 既定の nixpkgs のパッケージならチャンネル名は空になる
+func EntryChannel(entry string) (string, string) {
+	name, ok := strings.CutPrefix(entry, StableChannel+".")
+	if !ok || !nixast.IsAttrPath(name) {
+		return "", entry
+	}
+	return StableChannel, name
+}
+
+// StableFlakeURL は branch の nixpkgs の flake の入力の URL を返す
+func StableFlakeURL(branch string) string {
+	return "github:NixOS/nixpkgs/" + branch
+}
+
+// StableSource は Flakeを使わない環境で pkgs.stable に使う nixpkgs のソース
+// focus pin と同じく、ブランチのその時点のコミットとソースのハッシュで固定する
+type StableSource struct {
+	// Branch はリリースのブランチ（例: nixos-25.05）
+	Branch string
+	// Rev は固定した Branch のコミット
+	Rev string
+	// Hash は Rev のソースを展開したものの NAR ハッシュ（builtins.fetchTarball の sha256）
+	Hash string
+}
+
+// ChannelsModule は nixpkgs.overlays で pkgs.stable を追加するモジュールの内容を返す
+// Flake環境では extraSpecialArgs で渡された inputs の nixpkgs-stable を、
+// そうでなければ source のコミットのアーカイブを使う
+func ChannelsModule(flake bool, source StableSource) []byte {
+	var b strings.Builder
+	b.WriteString("# focus が生成するファイルです。focus install --channel stable のパッケージを pkgs.stable で参照できるようにします。\n")
+	b.WriteString("# 直接編集しないでください。\n")
+
+	if flake {
+		b.WriteString("# flake.nix の homeManagerConfiguration で extraSpecialArgs = { inherit inputs; } が必要です。\n")
+		b.WriteString("{ inputs, ... }:\n")
+	} else {
+		fmt.Fprintf(&b, "# %s のコミットに固定しています。更新するには設定ファイルの stable_rev と stable_hash を削除してください。\n", source.Branch)
+		b.WriteString("{ ... }:\n")
+	}
+
+	b.WriteString("{\n  nixpkgs.overlays = [\n    (final: prev: {\n")
+	if flake {
+		fmt.Fprintf(&b, "      %s = import inputs.%s {\n", StableChannel, StableInput)
+	} else {
+		fmt.Fprintf(&b, "      %s = import (builtins.fetchTarball {\n", StableChannel)
+		fmt.Fprintf(&b, "        url = %q;\n", NixpkgsTarballURL(source.Rev))
+		fmt.Fprintf(&b, "        sha256 = %q;\n", source.Hash)
+		b.WriteString("      }) {\n")
+	}
+	b.WriteString("        inherit (prev.stdenv.hostPlatform) system;\n")
+	b.WriteString("        inherit (prev) config;\n")
+	b.WriteString("      };\n    })\n  ];\n}\n")
+	return []byte(b.String())
+}
+
+// WriteChannelsModule は pkgs.stable を追加するモジュールを path に書き込む
+func WriteChannelsModule(path string, flake bool, source StableSource) error {
+	if err := safefile.WriteFile(path, ChannelsModule(flake, source), 0644); err != nil {
+		return fmt.Errorf("%s の書き込みに失敗: %w", path, err)
+	}
+	return nil
+}
+
+// AddFlakeInput は flake.nix の inputs = { ... } に name.url = url; を追加し、
+// outputs の関数が ... を受け取らなければ引数にも name を加える
+// 既に入力があれば何もせず false を返す
+func AddFlakeInput(path, name, url string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
+
+	file, err := nixast.Parse(content)
+	if err != nil {
+		return false, fmt.Errorf("%s の解析に失敗: %w", path, err)
+	}
+
+	inputs, err := file.FindAttrSet("inputs")
+	if err != nil {
+		return false, fmt.Errorf("%s の inputs = { ... } が見つかりません。inputs に %s.url = %q; を追加してください: %w", path, name, url, err)
+	}
+	if inputs.HasAttr(name) {
+		return false, nil
+	}
+
+	file, err = nixast.Parse(inputs.AppendBinding(fmt.Sprintf("%s.url = %q;", name, url)))
+	if err != nil {
+		return false, fmt.Errorf("%s の解析に失敗: %w", path, err)
+	}
+
+	outputs, err := file.FindFunction("outputs")
+	if err != nil {
+		return false, fmt.Errorf("%s の outputs の解析に失敗: %w", path, err)
+	}
+
+	content = file.Source()
+	if !outputs.HasEllipsis() && !outputs.HasFormal(name) {
+		content = outputs.AddFormal(name)
+	}
+
+	if err := safefile.WriteFile(path, content, 0644); err != nil {
+		return false, fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+	}
+
+	return true, nil
+}
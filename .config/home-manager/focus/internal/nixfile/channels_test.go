@@ -0,0 +1,114 @@
+package nixfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEntryChannel tests splitting channel entries into channel and attribute path
+func TestEntryChannel(t *testing.T) {
+	tests := []struct {
+		entry   string
+		channel string
+		name    string
+	}{
+		{"stable.terraform", "stable", "terraform"},
+		{"stable.python3Packages.black", "stable", "python3Packages.black"},
+		{"terraform", "", "terraform"},
+		{"stable.(x)", "", "stable.(x)"},
+	}
+
+	for _, tt := range tests {
+		channel, name := EntryChannel(tt.entry)
+		if channel != tt.channel || name != tt.name {
+			t.Errorf("EntryChannel(%q) = %q, %q; want %q, %q", tt.entry, channel, name, tt.channel, tt.name)
+		}
+	}
+
+	if entry := ChannelEntry(StableChannel, "terraform"); entry != "stable.terraform" {
+		t.Errorf("ChannelEntry = %q", entry)
+	}
+}
+
+// TestChannelsModule tests the overlay source for flake and non-flake setups
+func TestChannelsModule(t *testing.T) {
+	flake := string(ChannelsModule(true, StableSource{Branch: "nixos-26.05"}))
+	for _, want := range []string{"{ inputs, ... }:\n", "      stable = import inputs.nixpkgs-stable {\n", "        inherit (prev) config;\n"} {
+		if !strings.Contains(flake, want) {
+			t.Errorf("Flake module should contain %q:\n%s", want, flake)
+		}
+	}
+
+	// Flakeを使わない環境ではコミットとハッシュで固定する
+	plain := string(ChannelsModule(false, StableSource{Branch: "nixos-26.05", Rev: "abc123", Hash: "sha256-xyz="}))
+	want := "      stable = import (builtins.fetchTarball {\n" +
+		"        url = \"https://github.com/NixOS/nixpkgs/archive/abc123.tar.gz\";\n" +
+		"        sha256 = \"sha256-xyz=\";\n" +
+		"      }) {\n"
+	if strings.Contains(plain, "inputs") || !strings.Contains(plain, want) || !strings.Contains(plain, "nixos-26.05") {
+		t.Errorf("Unexpected non-flake module:\n%s", plain)
+	}
+}
+
+// TestAddFlakeInput tests adding the input and outputs argument once
+func TestAddFlakeInput(t *testing.T) {
+	tmpDir := t.TempDir()
+	flakePath := filepath.Join(tmpDir, "flake.nix")
+
+	initialContent := `{
+  inputs = {
+    nixpkgs.url = "github:nixos/nixpkgs?ref=nixos-unstable";
+  };
+
+  outputs = {
+    self,
+    nixpkgs,
+  }: { };
+}
+`
+	if err := os.WriteFile(flakePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	added, err := AddFlakeInput(flakePath, StableInput, StableFlakeURL("nixos-26.05"))
+	if err != nil || !added {
+		t.Fatalf("AddFlakeInput = %v, %v", added, err)
+	}
+
+	content, err := os.ReadFile(flakePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	expected := strings.NewReplacer(
+		"nixos-unstable\";\n", "nixos-unstable\";\n    nixpkgs-stable.url = \"github:NixOS/nixpkgs/nixos-26.05\";\n",
+		"    nixpkgs,\n", "    nixpkgs,\n    nixpkgs-stable,\n",
+	).Replace(initialContent)
+	if string(content) != expected {
+		t.Errorf("Unexpected content\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+
+	// 2回目は何もしない
+	added, err = AddFlakeInput(flakePath, StableInput, StableFlakeURL("nixos-26.05"))
+	if err != nil || added {
+		t.Errorf("Second AddFlakeInput = %v, %v", added, err)
+	}
+
+	// outputs が ... を受け取るなら引数は増やさない
+	ellipsis := "{\n  inputs = { };\n  outputs = { self, ... }@inputs: { };\n}\n"
+	if err := os.WriteFile(flakePath, []byte(ellipsis), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := AddFlakeInput(flakePath, StableInput, "github:o/r"); err != nil {
+		t.Fatalf("AddFlakeInput failed: %v", err)
+	}
+	content, err = os.ReadFile(flakePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if want := "{\n  inputs = { nixpkgs-stable.url = \"github:o/r\"; };\n  outputs = { self, ... }@inputs: { };\n}\n"; string(content) != want {
+		t.Errorf("Unexpected content with ellipsis:\n%s", content)
+	}
+}
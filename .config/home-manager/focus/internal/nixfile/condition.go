@@ -0,0 +1,178 @@
+package nixfile
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"focus/internal/nixast"
+	"focus/internal/safefile"
+)
+
+// HostArg は --host の条件で比較するホスト名のモジュール引数
+// Flake環境では extraSpecialArgs で渡す。そうでなければ HostModule が定義する
+const HostArg = "hostname"
+
+// Condition はパッケージをインストールする条件（ゼロ値なら常にインストールする）
+type Condition struct {
+	// Platform は darwin, linux, または aarch64-darwin のようなシステム名
+	Platform string
+	// Host はホスト名
+	Host string
+	// Raw は focus が生成した形ではない条件式（表示と比較にだけ使う）
+	Raw string
+}
+
+var (
+	systemPattern = regexp.MustCompile(`^[a-z0-9_]+-(linux|darwin)$`)
+	hostPattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*$`)
+
+	platformExprPattern = regexp.MustCompile(`^pkgs\.stdenv\.is(Darwin|Linux)$`)
+	systemExprPattern   = regexp.MustCompile(`^pkgs\.stdenv\.hostPlatform\.system == "([^"]+)"$`)
+	hostExprPattern     = regexp.MustCompile(`^` + HostArg + ` == "([^"]+)"$`)
+)
+
+// ValidatePlatform は --only に指定できる値かを確認する
+func ValidatePlatform(platform string) error {
+	if platform == "darwin" || platform == "linux" || systemPattern.MatchString(platform) {
+		return nil
+	}
+	return fmt.Errorf("プラットフォーム '%s' は指定できません（darwin, linux, または aarch64-darwin のようなシステム名）", platform)
+}
+
+// ValidateHost は --host に指定できるホスト名かを確認する
+func ValidateHost(host string) error {
+	if hostPattern.MatchString(host) {
+		return nil
+	}
+	return fmt.Errorf("ホスト名 '%s' は指定できません", host)
+}
+
+// IsZero は条件がない（常にインストールする）かを返す
+func (c Condition) IsZero() bool {
+	return c == Condition{}
+}
+
+// Expression は lib.optionals に渡す条件式を返す
+func (c Condition) Expression() string {
+	if c.Raw != "" {
+		return c.Raw
+	}
+
+	var parts []string
+	switch c.Platform {
+	case "":
+	case "darwin":
+		parts = append(parts, "pkgs.stdenv.isDarwin")
+	case "linux":
+		parts = append(parts, "pkgs.stdenv.isLinux")
+	default:
+		parts = append(parts, fmt.Sprintf("pkgs.stdenv.hostPlatform.system == %q", c.Platform))
+	}
+	if c.Host != "" {
+		parts = append(parts, fmt.Sprintf("%s == %q", HostArg, c.Host))
+	}
+
+	// 属性の参照だけなら括弧は不要
+	if len(parts) == 1 && !strings.Contains(parts[0], " ") {
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, " && ") + ")"
+}
+
+// String は表示用の条件を返す（例: "darwin", "host=work-laptop"）
+func (c Condition) String() string {
+	if c.Raw != "" {
+		return c.Raw
+	}
+
+	var parts []string
+	if c.Platform != "" {
+		parts = append(parts, c.Platform)
+	}
+	if c.Host != "" {
+		parts = append(parts, "host="+c.Host)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseCondition は lib.optionals の条件式を解釈する
+// focus が生成する形でなければ Raw に式をそのまま入れる
+func ParseCondition(expr string) Condition {
+	inner := strings.TrimSpace(expr)
+	if strings.HasPrefix(inner, "(") && strings.HasSuffix(inner, ")") {
+		inner = strings.TrimSpace(inner[1 : len(inner)-1])
+	}
+
+	var c Condition
+	for _, part := range strings.Split(inner, " && ") {
+		part = strings.TrimSpace(part)
+		switch {
+		case c.Platform == "" && platformExprPattern.MatchString(part):
+			c.Platform = strings.ToLower(platformExprPattern.FindStringSubmatch(part)[1])
+		case c.Platform == "" && systemExprPattern.MatchString(part):
+			c.Platform = systemExprPattern.FindStringSubmatch(part)[1]
+		case c.Host == "" && hostExprPattern.MatchString(part):
+			c.Host = hostExprPattern.FindStringSubmatch(part)[1]
+		default:
+			return Condition{Raw: expr}
+		}
+	}
+
+	return c
+}
+
+// Entry はパッケージとインストールする条件
+// Note は要素の同じ行に続くコメント（focus install --note で書いたメモ）
+type Entry struct {
+	Name      string
+	Condition Condition
+	Note      string
+}
+
+// FlakePassesHostArg は flake.nix の extraSpecialArgs で hostname 引数を渡しているかを返す
+// Flake環境の純粋な評価では環境変数を読めないので、--host には extraSpecialArgs の hostname が必要になる
+func FlakePassesHostArg(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
+
+	file, err := nixast.Parse(content)
+	if err != nil {
+		return false, fmt.Errorf("%s の解析に失敗: %w", path, err)
+	}
+
+	args, err := file.FindAttrSet("extraSpecialArgs")
+	if err != nil {
+		return false, nil
+	}
+	return args.HasAttr(HostArg), nil
+}
+
+// HostModule は Flakeを使わない環境で --host の条件に使う hostname 引数を定義するモジュールの内容を返す
+// extraSpecialArgs で hostname を渡していればそちらが優先される。渡していなければ
+// 環境変数 env（focus が home-manager の実行時に設定する）を使い、空なら評価時にエラーにする
+func HostModule(env string) []byte {
+	var b strings.Builder
+	b.WriteString("# focus が生成するファイルです。focus install --host の条件で比較する hostname 引数を定義します。\n")
+	b.WriteString("# 直接編集しないでください。extraSpecialArgs で hostname を渡していればそちらが使われます。\n")
+	b.WriteString("{ lib, ... }:\n")
+	b.WriteString("let\n")
+	fmt.Fprintf(&b, "  hostname = builtins.getEnv %q;\n", env)
+	b.WriteString("in\n{\n")
+	fmt.Fprintf(&b, "  _module.args.%s = lib.mkDefault (\n", HostArg)
+	b.WriteString("    if hostname != \"\" then hostname\n")
+	fmt.Fprintf(&b, "    else throw %q\n", "focus: ホスト名がわかりません。homeManagerConfiguration の extraSpecialArgs で hostname を渡すか、focus から実行してください")
+	b.WriteString("  );\n}\n")
+	return []byte(b.String())
+}
+
+// WriteHostModule は hostname 引数を定義するモジュールを path に書き込む
+func WriteHostModule(path, env string) error {
+	if err := safefile.WriteFile(path, HostModule(env), 0644); err != nil {
+		return fmt.Errorf("%s の書き込みに失敗: %w", path, err)
+	}
+	return nil
+}
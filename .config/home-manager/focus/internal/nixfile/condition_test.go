@@ -0,0 +1,273 @@
+package nixfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestConditionRoundTrip tests that generated expressions parse back to the same condition
+func TestConditionRoundTrip(t *testing.T) {
+	tests := []struct {
+		condition Condition
+		expr      string
+		str       string
+	}{
+		{Condition{Platform: "darwin"}, "pkgs.stdenv.isDarwin", "darwin"},
+		{Condition{Platform: "linux"}, "pkgs.stdenv.isLinux", "linux"},
+		{Condition{Platform: "aarch64-darwin"}, `(pkgs.stdenv.hostPlatform.system == "aarch64-darwin")`, "aarch64-darwin"},
+		{Condition{Host: "work-laptop"}, `(hostname == "work-laptop")`, "host=work-laptop"},
+		{Condition{Platform: "darwin", Host: "mbp"}, `(pkgs.stdenv.isDarwin && hostname == "mbp")`, "darwin, host=mbp"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.condition.Expression(); got != tt.expr {
+			t.Errorf("Expression() of %+v = %q, want %q", tt.condition, got, tt.expr)
+		}
+		if got := tt.condition.String(); got != tt.str {
+			t.Errorf("String() of %+v = %q, want %q", tt.condition, got, tt.str)
+		}
+		if got := ParseCondition(tt.expr); got != tt.condition {
+			t.Errorf("ParseCondition(%q) = %+v, want %+v", tt.expr, got, tt.condition)
+		}
+	}
+}
+
+// TestParseConditionRaw tests that hand-written conditions are kept as-is
+func TestParseConditionRaw(t *testing.T) {
+	for _, expr := range []string{"config.programs.foo.enable", "(pkgs.stdenv.isDarwin || pkgs.stdenv.isLinux)"} {
+		got := ParseCondition(expr)
+		if got.Raw != expr || got.Platform != "" || got.Host != "" {
+			t.Errorf("ParseCondition(%q) = %+v, want Raw only", expr, got)
+		}
+		if got.String() != expr || got.Expression() != expr {
+			t.Errorf("Raw condition should be shown unchanged: %q", got.String())
+		}
+	}
+}
+
+// TestValidatePlatformAndHost tests the values accepted by --only and --host
+func TestValidatePlatformAndHost(t *testing.T) {
+	for _, platform := range []string{"darwin", "linux", "aarch64-darwin", "x86_64-linux"} {
+		if err := ValidatePlatform(platform); err != nil {
+			t.Errorf("ValidatePlatform(%q) failed: %v", platform, err)
+		}
+	}
+	for _, platform := range []string{"", "windows", "Darwin", "x86_64-windows", `darwin" || true`} {
+		if err := ValidatePlatform(platform); err == nil {
+			t.Errorf("ValidatePlatform(%q) should fail", platform)
+		}
+	}
+
+	for _, host := range []string{"work-laptop", "mbp.local", "host1"} {
+		if err := ValidateHost(host); err != nil {
+			t.Errorf("ValidateHost(%q) failed: %v", host, err)
+		}
+	}
+	for _, host := range []string{"", "-x", "a b", `x"`} {
+		if err := ValidateHost(host); err == nil {
+			t.Errorf("ValidateHost(%q) should fail", host)
+		}
+	}
+}
+
+// TestConditionalPackages tests adding, listing and removing packages in lib.optionals blocks
+func TestConditionalPackages(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, ... }:
+
+{
+  home.packages = with pkgs; [
+    ripgrep
+  ];
+}
+`
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	darwin := Condition{Platform: "darwin"}
+	host := Condition{Host: "work-laptop"}
+
+	if err := manager.AddConditionalPackages([]string{"pngpaste"}, darwin); err != nil {
+		t.Fatalf("AddConditionalPackages failed: %v", err)
+	}
+	if err := manager.AddConditionalPackages([]string{"slack"}, host); err != nil {
+		t.Fatalf("AddConditionalPackages failed: %v", err)
+	}
+	// 同じ条件のブロックがあればそこに追加する
+	if err := manager.AddConditionalPackages([]string{"darwin.trash"}, darwin); err != nil {
+		t.Fatalf("AddConditionalPackages failed: %v", err)
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	expected := `{ pkgs, lib, hostname, ... }:
+
+{
+  home.packages = with pkgs; [
+    ripgrep
+  ] ++ lib.optionals pkgs.stdenv.isDarwin [
+    darwin.trash
+    pngpaste
+  ] ++ lib.optionals (hostname == "work-laptop") [
+    slack
+  ];
+}
+`
+	if string(content) != expected {
+		t.Errorf("Unexpected content\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+
+	entries, err := manager.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	want := []Entry{
+		{Name: "ripgrep"},
+		{Name: "darwin.trash", Condition: darwin},
+		{Name: "pngpaste", Condition: darwin},
+		{Name: "slack", Condition: host},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("ListEntries = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("ListEntries[%d] = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	// 条件付きのパッケージもインストール済みとして扱う
+	if hasPackage, err := manager.HasPackage("slack"); err != nil || !hasPackage {
+		t.Errorf("HasPackage(slack) = %v, %v", hasPackage, err)
+	}
+	if err := manager.AddPackages([]string{"slack"}); err == nil {
+		t.Error("AddPackages should fail for a package in a conditional block")
+	}
+
+	// ブロックが空になればブロックごと取り除く
+	if err := manager.RemovePackages([]string{"slack", "darwin.trash"}); err != nil {
+		t.Fatalf("RemovePackages failed: %v", err)
+	}
+
+	content, err = os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	// 追加した引数は残す
+	expected = strings.Replace(initialContent, "{ pkgs, ... }", "{ pkgs, lib, hostname, ... }", 1)
+	expected = strings.Replace(expected, "    ripgrep\n  ];", "    ripgrep\n  ] ++ lib.optionals pkgs.stdenv.isDarwin [\n    pngpaste\n  ];", 1)
+	if string(content) != expected {
+		t.Errorf("Unexpected content after RemovePackages\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+
+	if err := manager.RemovePackage("pngpaste"); err != nil {
+		t.Fatalf("RemovePackage failed: %v", err)
+	}
+
+	packages, err := manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if len(packages) != 1 || packages[0] != "ripgrep" {
+		t.Errorf("Unexpected packages: %v", packages)
+	}
+}
+
+// TestConditionalPackagesWithoutLibFormal tests falling back to pkgs.lib when lib can't be added
+func TestConditionalPackagesWithoutLibFormal(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := "pkgs: {\n  home.packages = [\n    pkgs.ripgrep\n  ];\n}\n"
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	if err := manager.AddConditionalPackages([]string{"pkgs.xclip"}, Condition{Platform: "linux"}); err != nil {
+		t.Fatalf("AddConditionalPackages failed: %v", err)
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	expected := "pkgs: {\n  home.packages = [\n    pkgs.ripgrep\n  ] ++ pkgs.lib.optionals pkgs.stdenv.isLinux [\n    pkgs.xclip\n  ];\n}\n"
+	if string(content) != expected {
+		t.Errorf("Unexpected content\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+}
+
+// TestHostConditionRequiresFormals tests that host conditions need an argument set for hostname
+func TestHostConditionRequiresFormals(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := "pkgs: {\n  home.packages = [\n    pkgs.ripgrep\n  ];\n}\n"
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	if err := manager.AddConditionalPackages([]string{"pkgs.slack"}, Condition{Host: "work-laptop"}); err == nil {
+		t.Error("AddConditionalPackages should fail when hostname can't be added")
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != initialContent {
+		t.Errorf("File should not change:\n%s", content)
+	}
+}
+
+// TestHostModule tests that the module defines hostname from the environment and fails when it is unset
+func TestHostModule(t *testing.T) {
+	module := string(HostModule("FOCUS_HOSTNAME"))
+	for _, want := range []string{
+		"{ lib, ... }:\n",
+		`  hostname = builtins.getEnv "FOCUS_HOSTNAME";` + "\n",
+		"  _module.args.hostname = lib.mkDefault (\n",
+		"    else throw \"focus: ",
+	} {
+		if !strings.Contains(module, want) {
+			t.Errorf("Module should contain %q:\n%s", want, module)
+		}
+	}
+}
+
+// TestFlakePassesHostArg tests detecting hostname in extraSpecialArgs of flake.nix
+func TestFlakePassesHostArg(t *testing.T) {
+	tests := map[string]bool{
+		`{ outputs = { ... }: { extraSpecialArgs = { inherit inputs; hostname = "work"; }; }; }`: true,
+		`{ outputs = { ... }: { extraSpecialArgs = { inherit inputs hostname; }; }; }`:           true,
+		`{ outputs = { ... }: { extraSpecialArgs = { inherit inputs; }; }; }`:                    false,
+		`{ outputs = { ... }: { }; }`: false,
+	}
+
+	for content, want := range tests {
+		path := filepath.Join(t.TempDir(), "flake.nix")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write flake.nix: %v", err)
+		}
+		got, err := FlakePassesHostArg(path)
+		if err != nil {
+			t.Fatalf("FlakePassesHostArg failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("FlakePassesHostArg(%s) = %v, want %v", content, got, want)
+		}
+	}
+}
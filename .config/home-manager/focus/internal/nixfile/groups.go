@@ -0,0 +1,77 @@
+package nixfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"focus/internal/safefile"
+)
+
+// emptyPackagesFile はグループを作るときのパッケージファイルの内容
+const emptyPackagesFile = `{ pkgs, ... }:
+{
+  home.packages = with pkgs; [
+  ];
+}
+`
+
+// CreatePackagesFile は空の home.packages を持つパッケージファイルを作る
+// 既にファイルがあれば何もしない
+func CreatePackagesFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("ディレクトリの作成に失敗: %w", err)
+	}
+
+	if err := safefile.WriteFile(path, []byte(emptyPackagesFile), 0644); err != nil {
+		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+	}
+
+	return nil
+}
+
+// GroupsModule は files を import するモジュールの内容を返す
+// パスはモジュールを置く dir からの相対パスにする
+func GroupsModule(dir string, files []string) []byte {
+	var b strings.Builder
+	b.WriteString("# focus が生成するファイルです。有効なグループのパッケージファイルを import します。\n")
+	b.WriteString("# 直接編集せず、focus group enable/disable を使ってください。\n")
+	b.WriteString("{ ... }:\n{\n  imports = [\n")
+	for _, file := range files {
+		fmt.Fprintf(&b, "    %s\n", nixPathLiteral(dir, file))
+	}
+	b.WriteString("  ];\n}\n")
+	return []byte(b.String())
+}
+
+// WriteGroupsModule は files を import するモジュールを path に書き込む
+func WriteGroupsModule(path string, files []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("ディレクトリの作成に失敗: %w", err)
+	}
+
+	if err := safefile.WriteFile(path, GroupsModule(filepath.Dir(path), files), 0644); err != nil {
+		return fmt.Errorf("%s の書き込みに失敗: %w", path, err)
+	}
+
+	return nil
+}
+
+// nixPathLiteral は dir から file を指す Nix のパスリテラルを返す
+func nixPathLiteral(dir, file string) string {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || strings.ContainsAny(rel, " \"") {
+		return fmt.Sprintf("(/. + %q)", file)
+	}
+
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel
+}
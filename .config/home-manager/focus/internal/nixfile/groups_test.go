@@ -0,0 +1,58 @@
+package nixfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGroupsModule tests the generated import list
+func TestGroupsModule(t *testing.T) {
+	got := string(GroupsModule("/home/u/.config/home-manager/focus-groups", []string{
+		"/home/u/.config/home-manager/focus-groups/dev.nix",
+		"/home/u/.config/home-manager/work.nix",
+		"/tmp/my groups/x.nix",
+	}))
+
+	for _, want := range []string{
+		"{ ... }:\n{\n  imports = [\n",
+		"    ./dev.nix\n",
+		"    ../work.nix\n",
+		`    (/. + "/tmp/my groups/x.nix")` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in module:\n%s", want, got)
+		}
+	}
+}
+
+// TestCreatePackagesFile tests that a new group file accepts packages
+func TestCreatePackagesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "focus-groups", "dev.nix")
+	if err := CreatePackagesFile(path); err != nil {
+		t.Fatalf("CreatePackagesFile failed: %v", err)
+	}
+
+	manager := NewManager(path)
+	if err := manager.AddPackages([]string{"ripgrep", "fd"}); err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+
+	packages, err := manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if strings.Join(packages, ",") != "fd,ripgrep" {
+		t.Errorf("Unexpected packages: %v", packages)
+	}
+
+	// 既存のファイルは上書きしない
+	if err := CreatePackagesFile(path); err != nil {
+		t.Fatalf("CreatePackagesFile failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "ripgrep") {
+		t.Error("CreatePackagesFile overwrote an existing file")
+	}
+}
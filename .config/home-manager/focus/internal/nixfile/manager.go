@@ -3,9 +3,11 @@ package nixfile
 import (
 	"fmt"
 	"os"
-	"regexp"
-	"sort"
+	"path/filepath"
 	"strings"
+
+	"focus/internal/nixast"
+	"focus/internal/safefile"
 )
 
 type Manager struct {
@@ -18,17 +20,45 @@ func NewManager(filePath string) *Manager {
 	}
 }
 
+// Path は管理しているファイルのパスを返す
+func (m *Manager) Path() string {
+	return m.filePath
+}
+
 func (m *Manager) ListPackages() ([]string, error) {
 	content, err := os.ReadFile(m.filePath)
 	if err != nil {
 		return nil, fmt.Errorf("ファイルの読み込みに失敗: %w", err)
 	}
 
-	packages := m.parsePackages(string(content))
-	return packages, nil
+	return m.parsePackages(content)
 }
 
 func (m *Manager) AddPackage(packageName string) error {
+	return m.AddPackages([]string{packageName})
+}
+
+// AddPackages は複数のパッケージを1回の書き込みで追加する
+// バックアップは1つだけ作成されるため、Rollbackで全て元に戻る
+func (m *Manager) AddPackages(packageNames []string) error {
+	return m.AddConditionalPackages(packageNames, Condition{})
+}
+
+// AddConditionalPackages は condition を満たす場合だけインストールするパッケージを追加する
+// 同じ条件の lib.optionals のブロックがあればそこに、なければ新しいブロックを作って追加する
+func (m *Manager) AddConditionalPackages(packageNames []string, condition Condition) error {
+	return m.AddPackagesWithNote(packageNames, condition, "")
+}
+
+// AddPackagesWithNote は condition でパッケージを追加し、各要素の行末に note をコメントとして書く
+// note が空ならコメントは書かない
+func (m *Manager) AddPackagesWithNote(packageNames []string, condition Condition, note string) error {
+	for _, packageName := range packageNames {
+		if err := ValidatePackage(packageName); err != nil {
+			return err
+		}
+	}
+
 	if err := m.backup(); err != nil {
 		return fmt.Errorf("バックアップの作成に失敗: %w", err)
 	}
@@ -38,28 +68,66 @@ func (m *Manager) AddPackage(packageName string) error {
 		return fmt.Errorf("ファイルの読み込みに失敗: %w", err)
 	}
 
-	contentStr := string(content)
-	packages := m.parsePackages(contentStr)
+	content, err = m.addTo(content, packageNames, condition, note)
+	if err != nil {
+		return err
+	}
 
-	for _, pkg := range packages {
-		if pkg == packageName {
-			return fmt.Errorf("パッケージ '%s' は既にインストールされています", packageName)
-		}
+	if err := safefile.WriteFile(m.filePath, content, 0644); err != nil {
+		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
 	}
 
-	packages = append(packages, packageName)
-	sort.Strings(packages)
+	return nil
+}
 
-	newContent := m.generateContent(packages)
+// addTo は content にパッケージを追加したソースを返す
+func (m *Manager) addTo(content []byte, packageNames []string, condition Condition, note string) ([]byte, error) {
+	for _, packageName := range packageNames {
+		lists, err := m.findPackageLists(content)
+		if err != nil {
+			return nil, err
+		}
 
-	if err := os.WriteFile(m.filePath, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+		if lists.contains(packageName) {
+			return nil, fmt.Errorf("パッケージ '%s' は既にインストールされています", packageName)
+		}
+
+		content, err = lists.insert(packageName, condition)
+		if err != nil {
+			return nil, err
+		}
+
+		if note != "" {
+			lists, err := m.findPackageLists(content)
+			if err != nil {
+				return nil, err
+			}
+
+			noted, ok := lists.setNote(packageName, note)
+			if !ok {
+				return nil, fmt.Errorf("追加したパッケージ '%s' の要素が見つからないため、メモを書けません", packageName)
+			}
+			content = noted
+		}
 	}
 
+	return content, nil
+}
+
+// ValidatePackage は packageName を home.packages の1要素としてそのまま書けるかを検査する
+func ValidatePackage(packageName string) error {
+	if err := nixast.ValidateElement(packageName); err != nil {
+		return fmt.Errorf("'%s' はパッケージとして追加できません: %w", packageName, err)
+	}
 	return nil
 }
 
 func (m *Manager) RemovePackage(packageName string) error {
+	return m.RemovePackages([]string{packageName})
+}
+
+// RemovePackages は複数のパッケージを1回の書き込みで削除する
+func (m *Manager) RemovePackages(packageNames []string) error {
 	if err := m.backup(); err != nil {
 		return fmt.Errorf("バックアップの作成に失敗: %w", err)
 	}
@@ -69,30 +137,41 @@ func (m *Manager) RemovePackage(packageName string) error {
 		return fmt.Errorf("ファイルの読み込みに失敗: %w", err)
 	}
 
-	contentStr := string(content)
-	packages := m.parsePackages(contentStr)
+	content, err = m.removeFrom(content, packageNames)
+	if err != nil {
+		return err
+	}
 
-	found := false
-	newPackages := make([]string, 0, len(packages))
-	for _, pkg := range packages {
-		if pkg == packageName {
-			found = true
-			continue
-		}
-		newPackages = append(newPackages, pkg)
+	if err := safefile.WriteFile(m.filePath, content, 0644); err != nil {
+		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+	}
+
+	return nil
+}
+
+// removeFrom は content からパッケージを取り除いたソースを返す
+func (m *Manager) removeFrom(content []byte, packageNames []string) ([]byte, error) {
+	lists, err := m.findPackageLists(content)
+	if err != nil {
+		return nil, err
 	}
 
-	if !found {
-		return fmt.Errorf("パッケージ '%s' は見つかりませんでした", packageName)
+	for _, packageName := range packageNames {
+		if !lists.contains(packageName) {
+			return nil, fmt.Errorf("パッケージ '%s' は見つかりませんでした", packageName)
+		}
 	}
 
-	newContent := m.generateContent(newPackages)
+	for _, packageName := range packageNames {
+		lists, err := m.findPackageLists(content)
+		if err != nil {
+			return nil, err
+		}
 
-	if err := os.WriteFile(m.filePath, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+		content = lists.remove(packageName)
 	}
 
-	return nil
+	return content, nil
 }
 
 func (m *Manager) HasPackage(packageName string) (bool, error) {
@@ -101,70 +180,165 @@ func (m *Manager) HasPackage(packageName string) (bool, error) {
 		return false, err
 	}
 
-	for _, pkg := range packages {
-		if pkg == packageName {
-			return true, nil
-		}
-	}
-
-	return false, nil
+	return containsPackage(packages, packageName), nil
 }
 
 func (m *Manager) GetDiff(packageName string, isAdd bool) (string, error) {
-	packages, err := m.ListPackages()
+	return m.GetPackagesDiff([]string{packageName}, isAdd)
+}
+
+// GetPackagesDiff は複数パッケージを追加/削除した場合の差分をまとめて返す
+// 書き込む場合と同じ編集をメモリ上で行い、その結果のリストと比べる（ファイルは変更しない）
+func (m *Manager) GetPackagesDiff(packageNames []string, isAdd bool) (string, error) {
+	if isAdd {
+		return m.GetAddDiff(packageNames, Condition{}, "")
+	}
+
+	content, err := os.ReadFile(m.filePath)
+	if err != nil {
+		return "", fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
+
+	after, err := m.removeFrom(content, packageNames)
 	if err != nil {
 		return "", err
 	}
 
-	var before, after []string
+	return m.listsDiff(content, after)
+}
 
-	if isAdd {
-		before = packages
-		after = append(packages, packageName)
-		sort.Strings(after)
-	} else {
-		before = packages
-		after = make([]string, 0, len(packages))
-		for _, pkg := range packages {
-			if pkg != packageName {
-				after = append(after, pkg)
-			}
+// GetAddDiff は AddPackagesWithNote で追加した場合の差分を返す
+// 追加先の条件付きのブロックや、stable. などの接頭辞、メモのコメントも書き込む内容のまま表示する
+func (m *Manager) GetAddDiff(packageNames []string, condition Condition, note string) (string, error) {
+	for _, packageName := range packageNames {
+		if err := ValidatePackage(packageName); err != nil {
+			return "", err
 		}
 	}
 
-	diff := " home.packages = with pkgs; [\n"
+	content, err := os.ReadFile(m.filePath)
+	if err != nil {
+		return "", fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
+
+	after, err := m.addTo(content, packageNames, condition, note)
+	if err != nil {
+		return "", err
+	}
+
+	return m.listsDiff(content, after)
+}
+
+// listsDiff は before と after の home.packages のリストと条件付きのブロックを比べた差分を返す
+// 条件付きのブロックは条件式で対応させ、なくなったブロックは最後に削除として表示する
+func (m *Manager) listsDiff(before, after []byte) (string, error) {
+	beforeLists, err := m.findPackageLists(before)
+	if err != nil {
+		return "", err
+	}
+	afterLists, err := m.findPackageLists(after)
+	if err != nil {
+		return "", err
+	}
 
-	for _, pkg := range before {
-		found := false
-		for _, p := range after {
-			if p == pkg {
-				found = true
+	var b strings.Builder
+	b.WriteString(" home.packages = with pkgs; [\n")
+	writeElementsDiff(&b, beforeLists.main.Elements, afterLists.main.Elements)
+
+	matched := make(map[*nixast.Conditional]bool)
+	for _, block := range afterLists.conditionals {
+		var old []nixast.Element
+		for _, candidate := range beforeLists.conditionals {
+			if candidate.Condition == block.Condition {
+				old = candidate.List.Elements
+				matched[candidate] = true
 				break
 			}
 		}
-		if !found {
-			diff += fmt.Sprintf("-	%s\n", pkg)
+
+		fmt.Fprintf(&b, " ] %s\n", conditionalHeader(afterLists.file, block))
+		writeElementsDiff(&b, old, block.List.Elements)
+	}
+	for _, block := range beforeLists.conditionals {
+		if matched[block] {
+			continue
 		}
+		fmt.Fprintf(&b, "- ] %s\n", conditionalHeader(beforeLists.file, block))
+		writeElementsDiff(&b, block.List.Elements, nil)
 	}
 
-	for _, pkg := range after {
-		found := false
-		for _, p := range before {
-			if p == pkg {
-				found = true
-				break
-			}
+	b.WriteString(" ];")
+	return b.String(), nil
+}
+
+// conditionalHeader は条件付きのブロックの ++ lib.optionals <条件> [ の部分を1行にして返す
+func conditionalHeader(file *nixast.File, block *nixast.Conditional) string {
+	return nixast.NormalizeName(string(file.Source()[block.Start : block.List.Open+1]))
+}
+
+// writeElementsDiff は before になく after にある要素を +、after にない要素を - として書く
+// 要素の行末のコメント（メモ）も表示する
+func writeElementsDiff(b *strings.Builder, before, after []nixast.Element) {
+	for _, elem := range before {
+		if !containsElement(after, elem.Name()) {
+			fmt.Fprintf(b, "-	%s\n", elementLine(elem))
 		}
-		if found {
-			diff += fmt.Sprintf("	%s\n", pkg)
-		} else {
-			diff += fmt.Sprintf("+	%s\n", pkg)
+	}
+	for _, elem := range after {
+		mark := "+"
+		if containsElement(before, elem.Name()) {
+			mark = ""
 		}
+		fmt.Fprintf(b, "%s	%s\n", mark, elementLine(elem))
 	}
+}
 
-	diff += " ];"
+func containsElement(elements []nixast.Element, name string) bool {
+	for _, elem := range elements {
+		if elem.Name() == name {
+			return true
+		}
+	}
+	return false
+}
 
-	return diff, nil
+func elementLine(elem nixast.Element) string {
+	if elem.Comment == "" {
+		return elem.Name()
+	}
+	return elem.Name() + " " + elem.Comment
+}
+
+// Snapshot は現在のファイル内容を返す
+func (m *Manager) Snapshot() ([]byte, error) {
+	content, err := os.ReadFile(m.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
+	return content, nil
+}
+
+// Restore はファイルを content の内容に戻す
+// 現在の内容はバックアップされるため、Rollbackで取り消せる
+func (m *Manager) Restore(content []byte) error {
+	if _, err := m.parsePackages(content); err != nil {
+		return err
+	}
+
+	if err := m.backup(); err != nil {
+		return fmt.Errorf("バックアップの作成に失敗: %w", err)
+	}
+
+	if err := safefile.WriteFile(m.filePath, content, 0644); err != nil {
+		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+	}
+
+	return nil
+}
+
+// PackagesIn は content に含まれるパッケージ一覧を返す
+func (m *Manager) PackagesIn(content []byte) ([]string, error) {
+	return m.parsePackages(content)
 }
 
 func (m *Manager) Rollback() error {
@@ -175,71 +349,194 @@ func (m *Manager) Rollback() error {
 		return fmt.Errorf("バックアップファイルの読み込みに失敗: %w", err)
 	}
 
-	if err := os.WriteFile(m.filePath, content, 0644); err != nil {
+	if err := safefile.WriteFile(m.filePath, content, 0644); err != nil {
 		return fmt.Errorf("ロールバックに失敗: %w", err)
 	}
 
 	return nil
 }
 
-func (m *Manager) parsePackages(content string) []string {
-	re := regexp.MustCompile(`home\.packages\s*=\s*with\s+pkgs;\s*\[\s*([\s\S]*?)\s*\];`)
-	matches := re.FindStringSubmatch(content)
+// containsPackage は空白の違いを無視してパッケージ名を比較する
+func containsPackage(packages []string, packageName string) bool {
+	packageName = nixast.NormalizeName(packageName)
+	for _, pkg := range packages {
+		if nixast.NormalizeName(pkg) == packageName {
+			return true
+		}
+	}
+	return false
+}
 
-	if len(matches) < 2 {
-		return []string{}
+// parsePackages は home.packages のリスト要素を返す
+// 条件付きのブロック（lib.optionals）の要素も含む
+func (m *Manager) parsePackages(content []byte) ([]string, error) {
+	entries, err := m.parseEntries(content)
+	if err != nil {
+		return nil, err
 	}
 
-	packagesBlock := matches[1]
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names, nil
+}
 
-	lines := strings.Split(packagesBlock, "\n")
-	packages := make([]string, 0)
+// ListEntries はパッケージとインストールする条件の一覧を返す
+// 条件なしのパッケージが先に、条件付きのブロックのパッケージがファイルの順に続く
+func (m *Manager) ListEntries() ([]Entry, error) {
+	content, err := os.ReadFile(m.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	return m.parseEntries(content)
+}
 
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+func (m *Manager) parseEntries(content []byte) ([]Entry, error) {
+	lists, err := m.findPackageLists(content)
+	if err != nil {
+		return nil, err
+	}
 
-		if idx := strings.Index(line, "#"); idx != -1 {
-			line = strings.TrimSpace(line[:idx])
+	var entries []Entry
+	for _, elem := range lists.main.Elements {
+		entries = append(entries, Entry{Name: elem.Name(), Note: elem.CommentText()})
+	}
+	for _, block := range lists.conditionals {
+		condition := ParseCondition(block.Condition)
+		for _, elem := range block.List.Elements {
+			entries = append(entries, Entry{Name: elem.Name(), Condition: condition, Note: elem.CommentText()})
 		}
+	}
+	return entries, nil
+}
 
-		if line != "" {
-			packages = append(packages, line)
-		}
+// packageLists は home.packages のリストと、それに続く条件付きのブロック
+type packageLists struct {
+	path         string
+	file         *nixast.File
+	main         *nixast.List
+	conditionals []*nixast.Conditional
+}
+
+// findPackageLists は content を解析して home.packages のリストと条件付きのブロックを返す
+func (m *Manager) findPackageLists(content []byte) (*packageLists, error) {
+	return parsePackageLists(m.filePath, content)
+}
+
+func parsePackageLists(path string, content []byte) (*packageLists, error) {
+	file, err := nixast.Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s の解析に失敗: %w", path, err)
 	}
 
-	return packages
+	main, err := file.FindList("home.packages")
+	if err != nil {
+		return nil, fmt.Errorf("%s の解析に失敗: %w", path, err)
+	}
+
+	conditionals, err := file.FindConditionals(main)
+	if err != nil {
+		return nil, fmt.Errorf("%s の解析に失敗: %w", path, err)
+	}
+
+	return &packageLists{path: path, file: file, main: main, conditionals: conditionals}, nil
 }
 
-func (m *Manager) generateContent(packages []string) string {
-	var builder strings.Builder
+// contains はいずれかのリストに packageName があるかを返す
+func (l *packageLists) contains(packageName string) bool {
+	if l.main.Index(packageName) != -1 {
+		return true
+	}
+	for _, block := range l.conditionals {
+		if block.List.Index(packageName) != -1 {
+			return true
+		}
+	}
+	return false
+}
 
-	builder.WriteString("{ pkgs, ... }: {\n")
-	builder.WriteString("	home.packages = with pkgs; [\n")
+// insert は condition に対応するリストに packageName を追加したソースを返す
+func (l *packageLists) insert(packageName string, condition Condition) ([]byte, error) {
+	if condition.IsZero() {
+		return l.main.InsertSorted(packageName), nil
+	}
 
-	if len(packages) == 0 {
-		builder.WriteString("	# focus でインストールしたパッケージ\n")
-	} else {
-		for _, pkg := range packages {
-			builder.WriteString(fmt.Sprintf("	%s\n", pkg))
+	for _, block := range l.conditionals {
+		if ParseCondition(block.Condition) == condition {
+			return block.List.InsertSorted(packageName), nil
 		}
 	}
 
-	builder.WriteString("	];\n")
-	builder.WriteString("}\n")
+	// ホスト名の条件は hostname 引数と比較するので、引数になければ追加する
+	if condition.Host != "" && !l.file.HasFormal(HostArg) {
+		content, ok := l.file.AddFormal(HostArg)
+		if !ok {
+			return nil, fmt.Errorf("%s は { pkgs, ... }: の形の関数ではないため、%s 引数を追加できません", filepath.Base(l.path), HostArg)
+		}
+		reparsed, err := parsePackageLists(l.path, content)
+		if err != nil {
+			return nil, err
+		}
+		l = reparsed
+	}
+
+	// lib が引数になければ追加する。引数が属性セットでなければ pkgs.lib を使う
+	optionals := "lib.optionals"
+	if !l.file.HasFormal("lib") {
+		content, ok := l.file.AddFormal("lib")
+		if !ok {
+			optionals = "pkgs.lib.optionals"
+		} else {
+			reparsed, err := parsePackageLists(l.path, content)
+			if err != nil {
+				return nil, err
+			}
+			l = reparsed
+		}
+	}
+
+	after := l.main.Close + 1
+	if len(l.conditionals) > 0 {
+		after = l.conditionals[len(l.conditionals)-1].End
+	}
+	return l.main.AppendConditional(after, optionals, condition.Expression(), packageName), nil
+}
 
-	return builder.String()
+// remove は packageName を取り除いたソースを返す
+// 条件付きのブロックが空になる場合はブロックごと取り除く
+func (l *packageLists) remove(packageName string) []byte {
+	if index := l.main.Index(packageName); index != -1 {
+		return l.main.Remove(index)
+	}
+
+	for _, block := range l.conditionals {
+		index := block.List.Index(packageName)
+		if index == -1 {
+			continue
+		}
+		if len(block.List.Elements) == 1 {
+			return block.RemoveBlock()
+		}
+		return block.List.Remove(index)
+	}
+
+	return l.file.Source()
 }
 
 func (m *Manager) backup() error {
+	info, err := os.Stat(m.filePath)
+	if err != nil {
+		return err
+	}
+
 	content, err := os.ReadFile(m.filePath)
 	if err != nil {
 		return err
 	}
 
+	// バックアップも元のファイルと同じ権限で作る
 	backupPath := m.filePath + ".bak"
-	return os.WriteFile(backupPath, content, 0644)
+	return safefile.WriteFile(backupPath, content, info.Mode().Perm())
 }
@@ -3,6 +3,7 @@ package nixfile
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -145,6 +146,42 @@ func TestAddPackageDuplicate(t *testing.T) {
 	}
 }
 
+// TestAddPackageRejectsInvalidElements tests that arguments which aren't a single list element are rejected before writing
+func TestAddPackageRejectsInvalidElements(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, ... }: {
+  home.packages = with pkgs; [
+    ripgrep
+  ];
+}
+`
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	for _, name := range []string{"foo bar", "hello # x", "ripgrep,"} {
+		if err := manager.AddPackage(name); err == nil {
+			t.Errorf("AddPackage(%q) should fail", name)
+		}
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != initialContent {
+		t.Errorf("File should not change:\n%s", content)
+	}
+
+	// バックアップも作らない
+	if _, err := os.Stat(nixFilePath + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("Backup should not be created: %v", err)
+	}
+}
+
 // TestRemovePackage tests removing a package
 func TestRemovePackage(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -321,3 +358,403 @@ func TestRollback(t *testing.T) {
 		t.Errorf("Wrong package after rollback: got %s, want ripgrep", packages[0])
 	}
 }
+
+// TestAddPackagesRollback tests adding several packages and rolling them back together
+func TestAddPackagesRollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, ... }: {
+  home.packages = with pkgs; [
+    ripgrep
+  ];
+}
+`
+	err := os.WriteFile(nixFilePath, []byte(initialContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+
+	err = manager.AddPackages([]string{"jq", "fd", "bat"})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+
+	packages, err := manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+
+	expected := []string{"bat", "fd", "jq", "ripgrep"}
+	if len(packages) != len(expected) {
+		t.Fatalf("Package count mismatch: got %d, want %d", len(packages), len(expected))
+	}
+	for i, pkg := range expected {
+		if packages[i] != pkg {
+			t.Errorf("Package[%d] mismatch: got %s, want %s", i, packages[i], pkg)
+		}
+	}
+
+	// 1回のRollbackで全て元に戻る
+	if err := manager.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	packages, err = manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if len(packages) != 1 || packages[0] != "ripgrep" {
+		t.Errorf("Unexpected packages after rollback: %v", packages)
+	}
+}
+
+// TestRemovePackagesMissing tests that nothing is removed when one package is missing
+func TestRemovePackagesMissing(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, ... }: {
+  home.packages = with pkgs; [
+    fd
+    ripgrep
+  ];
+}
+`
+	err := os.WriteFile(nixFilePath, []byte(initialContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+
+	if err := manager.RemovePackages([]string{"fd", "nonexistent"}); err == nil {
+		t.Error("RemovePackages should fail when a package is missing")
+	}
+
+	packages, err := manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if len(packages) != 2 {
+		t.Errorf("File should be unchanged, got %v", packages)
+	}
+}
+
+// TestGetPackagesDiff tests the combined diff for several packages
+func TestGetPackagesDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	content := `{ pkgs, ... }: {
+  home.packages = with pkgs; [
+    ripgrep
+  ];
+}
+`
+	err := os.WriteFile(nixFilePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+
+	diff, err := manager.GetPackagesDiff([]string{"fd", "jq"}, true)
+	if err != nil {
+		t.Fatalf("GetPackagesDiff failed: %v", err)
+	}
+
+	for _, line := range []string{"+	fd", "+	jq", "	ripgrep"} {
+		if !strings.Contains(diff, line) {
+			t.Errorf("Diff should contain %q:\n%s", line, diff)
+		}
+	}
+}
+
+// TestGetAddDiffShowsWrittenEntries tests that the preview shows the block, prefix and note that will be written
+func TestGetAddDiffShowsWrittenEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	content := `{ pkgs, lib, ... }: {
+  home.packages = with pkgs; [
+    ripgrep
+  ] ++ lib.optionals pkgs.stdenv.isDarwin [
+    pngpaste
+  ];
+}
+`
+	if err := os.WriteFile(nixFilePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+
+	diff, err := manager.GetAddDiff([]string{"stable.terraform"}, Condition{Platform: "darwin"}, "インフラ")
+	if err != nil {
+		t.Fatalf("GetAddDiff failed: %v", err)
+	}
+	want := " home.packages = with pkgs; [\n\tripgrep\n ] ++ lib.optionals pkgs.stdenv.isDarwin [\n\tpngpaste\n+\tstable.terraform # インフラ\n ];"
+	if diff != want {
+		t.Errorf("Unexpected diff\ngot:\n%s\nwant:\n%s", diff, want)
+	}
+
+	diff, err = manager.GetAddDiff([]string{"slack"}, Condition{Host: "work-laptop"}, "")
+	if err != nil {
+		t.Fatalf("GetAddDiff failed: %v", err)
+	}
+	if !strings.Contains(diff, " ] ++ lib.optionals (hostname == \"work-laptop\") [\n+\tslack\n") {
+		t.Errorf("New block should be shown:\n%s", diff)
+	}
+
+	// ブロックが空になる削除はブロックごと削除として表示する
+	diff, err = manager.GetPackagesDiff([]string{"pngpaste"}, false)
+	if err != nil {
+		t.Fatalf("GetPackagesDiff failed: %v", err)
+	}
+	want = " home.packages = with pkgs; [\n\tripgrep\n- ] ++ lib.optionals pkgs.stdenv.isDarwin [\n-\tpngpaste\n ];"
+	if diff != want {
+		t.Errorf("Unexpected diff\ngot:\n%s\nwant:\n%s", diff, want)
+	}
+
+	// プレビューではファイルを変更しない
+	if after, _ := os.ReadFile(nixFilePath); string(after) != content {
+		t.Errorf("File should not change:\n%s", after)
+	}
+}
+
+// TestAddRemovePreservesHandWrittenContent tests that edits outside the list are kept
+func TestAddRemovePreservesHandWrittenContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `# 手で書いた設定
+{ pkgs, lib, ... }:
+let
+  myTools = [ pkgs.hello ];
+in {
+  home.packages = with pkgs; [
+    # 開発ツール
+    ripgrep
+  ] ++ myTools ++ lib.optionals pkgs.stdenv.isDarwin [ pngpaste ];
+
+  home.sessionVariables.EDITOR = "nvim";
+}
+`
+	err := os.WriteFile(nixFilePath, []byte(initialContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+
+	if err := manager.AddPackage("fd"); err != nil {
+		t.Fatalf("AddPackage failed: %v", err)
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	expected := strings.Replace(initialContent, "    ripgrep\n", "    fd\n    ripgrep\n", 1)
+	if string(content) != expected {
+		t.Errorf("Unexpected content after AddPackage\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+
+	if err := manager.RemovePackage("fd"); err != nil {
+		t.Fatalf("RemovePackage failed: %v", err)
+	}
+
+	content, err = os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	if string(content) != initialContent {
+		t.Errorf("Content not restored byte-for-byte\ngot:\n%s\nwant:\n%s", content, initialContent)
+	}
+}
+
+// TestListPackagesInvalidFile tests that a broken file is reported instead of ignored
+func TestListPackagesInvalidFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	err := os.WriteFile(nixFilePath, []byte("{ pkgs, ... }: {\n  home.packages = with pkgs; [\n    ripgrep\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	if _, err := manager.ListPackages(); err == nil {
+		t.Error("ListPackages should fail for an unbalanced file")
+	}
+}
+
+// TestAttrPathAndExpressionPackages tests round-tripping nested attribute paths and expressions
+func TestAttrPathAndExpressionPackages(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, ... }: {
+  home.packages = with pkgs; [
+    (buildGoModule {
+      pname = "focus";
+      src = ./focus;
+    })
+    ripgrep
+  ];
+}
+`
+	err := os.WriteFile(nixFilePath, []byte(initialContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+
+	if err := manager.AddPackages([]string{"python3Packages.black", "nodePackages.prettier", "(callPackage ./x {})"}); err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+
+	packages, err := manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+
+	expected := []string{
+		`(buildGoModule { pname = "focus"; src = ./focus; })`,
+		"nodePackages.prettier",
+		"python3Packages.black",
+		"ripgrep",
+		"(callPackage ./x {})",
+	}
+	if len(packages) != len(expected) {
+		t.Fatalf("Package count mismatch: got %q, want %q", packages, expected)
+	}
+	for i := range expected {
+		if packages[i] != expected[i] {
+			t.Errorf("Package[%d] mismatch: got %q, want %q", i, packages[i], expected[i])
+		}
+	}
+
+	// 空白の違いは無視して比較される
+	has, err := manager.HasPackage("(buildGoModule {\n pname = \"focus\"; src = ./focus; })")
+	if err != nil {
+		t.Fatalf("HasPackage failed: %v", err)
+	}
+	if !has {
+		t.Error("HasPackage should match a multi-line expression")
+	}
+
+	if err := manager.RemovePackages([]string{"python3Packages.black", expected[0]}); err != nil {
+		t.Fatalf("RemovePackages failed: %v", err)
+	}
+
+	packages, err = manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if len(packages) != 3 || packages[0] != "nodePackages.prettier" {
+		t.Errorf("Unexpected packages after removal: %q", packages)
+	}
+}
+
+// TestRestore tests restoring a snapshot and undoing it with Rollback
+func TestRestore(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, ... }: {
+  home.packages = with pkgs; [
+    ripgrep
+  ];
+}
+`
+	err := os.WriteFile(nixFilePath, []byte(initialContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+
+	snapshot, err := manager.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	if err := manager.AddPackages([]string{"fd", "jq"}); err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+
+	if err := manager.Restore(snapshot); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != initialContent {
+		t.Errorf("Restore did not restore the snapshot:\n%s", content)
+	}
+
+	// Restore自体もRollbackで取り消せる
+	if err := manager.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	packages, err := manager.ListPackages()
+	if err != nil {
+		t.Fatalf("ListPackages failed: %v", err)
+	}
+	if len(packages) != 3 {
+		t.Errorf("Expected 3 packages after undoing Restore, got %v", packages)
+	}
+
+	// 解析できない内容は書き込まない
+	if err := manager.Restore([]byte("{ broken")); err == nil {
+		t.Error("Restore should reject unparsable content")
+	}
+}
+
+// TestWritesPreserveMode tests that add, backup and rollback keep the file's permissions
+func TestWritesPreserveMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	content := "{ pkgs, ... }: {\n  home.packages = with pkgs; [\n    fd\n  ];\n}\n"
+	if err := os.WriteFile(nixFilePath, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	if err := manager.AddPackage("ripgrep"); err != nil {
+		t.Fatalf("AddPackage failed: %v", err)
+	}
+	if err := manager.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	for _, path := range []string{nixFilePath, nixFilePath + ".bak"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s: expected mode 0600, got %o", filepath.Base(path), info.Mode().Perm())
+		}
+	}
+
+	// 一時ファイルが残っていないこと
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Unexpected files left: %v", entries)
+	}
+}
@@ -0,0 +1,64 @@
+package nixfile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"focus/internal/safefile"
+)
+
+// ValidateNote はパッケージのメモとして要素のコメントに書けるかを検査する
+func ValidateNote(note string) error {
+	if strings.ContainsAny(note, "\r\n") {
+		return fmt.Errorf("メモに改行は使えません")
+	}
+	if strings.Contains(note, "*/") {
+		return fmt.Errorf("メモに */ は使えません")
+	}
+	return nil
+}
+
+// SetNote は packageName の要素の行末のコメントを note に書き換える
+// note が空ならコメントを取り除く。条件付きのブロックの要素も対象にする
+func (m *Manager) SetNote(packageName, note string) error {
+	if err := m.backup(); err != nil {
+		return fmt.Errorf("バックアップの作成に失敗: %w", err)
+	}
+
+	content, err := os.ReadFile(m.filePath)
+	if err != nil {
+		return fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
+
+	lists, err := m.findPackageLists(content)
+	if err != nil {
+		return err
+	}
+
+	content, ok := lists.setNote(packageName, note)
+	if !ok {
+		return fmt.Errorf("パッケージ '%s' は見つかりませんでした", packageName)
+	}
+
+	if err := safefile.WriteFile(m.filePath, content, 0644); err != nil {
+		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+	}
+
+	return nil
+}
+
+// setNote は packageName の要素のコメントを note にしたソースを返す
+func (l *packageLists) setNote(packageName, note string) ([]byte, bool) {
+	if index := l.main.Index(packageName); index != -1 {
+		return l.main.SetComment(index, note), true
+	}
+
+	for _, block := range l.conditionals {
+		if index := block.List.Index(packageName); index != -1 {
+			return block.List.SetComment(index, note), true
+		}
+	}
+
+	return nil, false
+}
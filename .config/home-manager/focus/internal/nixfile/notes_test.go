@@ -0,0 +1,139 @@
+package nixfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPackageNotes tests writing notes as trailing comments that survive later edits
+func TestPackageNotes(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, lib, ... }:
+{
+  home.packages = with pkgs; [
+    ripgrep
+  ] ++ lib.optionals pkgs.stdenv.isDarwin [
+    pngpaste
+  ];
+}
+`
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	if err := manager.AddPackagesWithNote([]string{"jq", "fd"}, Condition{}, "デプロイスクリプトで使う"); err != nil {
+		t.Fatalf("AddPackagesWithNote failed: %v", err)
+	}
+	if err := manager.SetNote("pngpaste", "スクリーンショット"); err != nil {
+		t.Fatalf("SetNote failed: %v", err)
+	}
+
+	// 後の追加・削除・固定でもメモは残る
+	if err := manager.AddPackages([]string{"bat"}); err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	if err := manager.RemovePackage("fd"); err != nil {
+		t.Fatalf("RemovePackage failed: %v", err)
+	}
+	if err := manager.PinPackage("jq"); err != nil {
+		t.Fatalf("PinPackage failed: %v", err)
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	expected := `{ pkgs, lib, focusPinned, ... }:
+{
+  home.packages = with pkgs; [
+    bat
+    focusPinned.jq # デプロイスクリプトで使う
+    ripgrep
+  ] ++ lib.optionals pkgs.stdenv.isDarwin [
+    pngpaste # スクリーンショット
+  ];
+}
+`
+	if string(content) != expected {
+		t.Errorf("Unexpected content\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+
+	entries, err := manager.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	notes := map[string]string{}
+	for _, entry := range entries {
+		notes[entry.Name] = entry.Note
+	}
+	if notes["focusPinned.jq"] != "デプロイスクリプトで使う" || notes["pngpaste"] != "スクリーンショット" || notes["bat"] != "" {
+		t.Errorf("Unexpected notes: %v", notes)
+	}
+
+	if err := manager.SetNote("pngpaste", ""); err != nil {
+		t.Fatalf("SetNote failed: %v", err)
+	}
+	content, err = os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if want := strings.Replace(expected, "    pngpaste # スクリーンショット\n", "    pngpaste\n", 1); string(content) != want {
+		t.Errorf("Note was not removed:\n%s", content)
+	}
+
+	if err := manager.SetNote("missing", "x"); err == nil {
+		t.Error("SetNote should fail for a package that is not installed")
+	}
+}
+
+// TestValidateNote tests rejecting notes that can't be written as a comment
+func TestValidateNote(t *testing.T) {
+	if err := ValidateNote("デプロイスクリプトで使う (#123)"); err != nil {
+		t.Errorf("ValidateNote failed: %v", err)
+	}
+	for _, note := range []string{"1行目\n2行目", "閉じる */ 記号"} {
+		if err := ValidateNote(note); err == nil {
+			t.Errorf("ValidateNote(%q) should fail", note)
+		}
+	}
+}
+
+// TestAddPackagesWithNoteMissingEntry tests that the file is kept when the note can't be written
+func TestAddPackagesWithNoteMissingEntry(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := "{ pkgs, ... }:\n{\n  home.packages = with pkgs; [\n    ripgrep\n  ];\n}\n"
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// "foo bar" は2つの要素になるので追加しない
+	manager := NewManager(nixFilePath)
+	if err := manager.AddPackagesWithNote([]string{"foo bar"}, Condition{}, "x"); err == nil {
+		t.Error("AddPackagesWithNote should fail when the entry for the note is missing")
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != initialContent {
+		t.Errorf("File should not change:\n%s", content)
+	}
+
+	// setNote は見つからなければ false を返す
+	lists, err := parsePackageLists(nixFilePath, content)
+	if err != nil {
+		t.Fatalf("parsePackageLists failed: %v", err)
+	}
+	if _, ok := lists.setNote("missing", "x"); ok {
+		t.Error("setNote should report a missing package")
+	}
+}
@@ -0,0 +1,173 @@
+package nixfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"focus/internal/nixast"
+	"focus/internal/safefile"
+)
+
+// PinnedArg は固定したパッケージを渡すモジュールの引数の名前
+const PinnedArg = "focusPinned"
+
+// Pin は nixpkgs のリビジョンを固定したパッケージ
+type Pin struct {
+	// Name は属性パス（例: terraform）
+	Name string
+	// Rev は nixpkgs のコミット
+	Rev string
+	// Hash は Rev のソースを展開したものの NAR ハッシュ
+	Hash string
+}
+
+// NixpkgsTarballURL は rev の nixpkgs のソースの URL を返す
+func NixpkgsTarballURL(rev string) string {
+	return "https://github.com/NixOS/nixpkgs/archive/" + rev + ".tar.gz"
+}
+
+// PinnedEntry は固定したパッケージの home.packages の要素を返す（例: focusPinned.terraform）
+func PinnedEntry(name string) string {
+	return PinnedArg + "." + name
+}
+
+// PinnedName は要素が固定したパッケージなら、元の属性パスを返す
+func PinnedName(entry string) (string, bool) {
+	name, ok := strings.CutPrefix(entry, PinnedArg+".")
+	if !ok || !nixast.IsAttrPath(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// PinsModule は固定したパッケージを _module.args.focusPinned で渡すモジュールの内容を返す
+// 同じリビジョンの nixpkgs は1回だけ import する
+func PinsModule(pins []Pin) []byte {
+	var b strings.Builder
+	b.WriteString("# focus が生成するファイルです。focus pin で固定したパッケージを " + PinnedArg + " 引数で渡します。\n")
+	b.WriteString("# 直接編集せず、focus pin/unpin を使ってください。\n")
+	b.WriteString("{ pkgs, ... }:\n")
+
+	var revs []Pin
+	for _, pin := range pins {
+		if !containsRev(revs, pin.Rev) {
+			revs = append(revs, pin)
+		}
+	}
+
+	if len(revs) > 0 {
+		b.WriteString("let\n")
+		b.WriteString("  nixpkgsAt = rev: sha256: import (builtins.fetchTarball {\n")
+		b.WriteString("    url = \"https://github.com/NixOS/nixpkgs/archive/${rev}.tar.gz\";\n")
+		b.WriteString("    inherit sha256;\n")
+		b.WriteString("  }) {\n")
+		b.WriteString("    inherit (pkgs.stdenv.hostPlatform) system;\n")
+		b.WriteString("    inherit (pkgs) config;\n")
+		b.WriteString("  };\n")
+		for _, pin := range revs {
+			fmt.Fprintf(&b, "  %s = nixpkgsAt %q %q;\n", revBinding(pin.Rev), pin.Rev, pin.Hash)
+		}
+		b.WriteString("in\n")
+	}
+
+	b.WriteString("{\n  _module.args." + PinnedArg + " = {\n")
+	for _, pin := range pins {
+		fmt.Fprintf(&b, "    %s = %s.%s;\n", pin.Name, revBinding(pin.Rev), pin.Name)
+	}
+	b.WriteString("  };\n}\n")
+	return []byte(b.String())
+}
+
+// WritePinsModule は固定したパッケージを渡すモジュールを path に書き込む
+func WritePinsModule(path string, pins []Pin) error {
+	if err := safefile.WriteFile(path, PinsModule(pins), 0644); err != nil {
+		return fmt.Errorf("%s の書き込みに失敗: %w", path, err)
+	}
+	return nil
+}
+
+// revBinding は rev の nixpkgs を束縛する変数名を返す
+func revBinding(rev string) string {
+	return "nixpkgs-" + rev
+}
+
+func containsRev(pins []Pin, rev string) bool {
+	for _, pin := range pins {
+		if pin.Rev == rev {
+			return true
+		}
+	}
+	return false
+}
+
+// PinPackage は packageName の要素を focusPinned.<packageName> に書き換える
+// 条件付きのブロックやコメントはそのまま残し、関数の引数に focusPinned を追加する
+func (m *Manager) PinPackage(packageName string) error {
+	return m.replacePackage(packageName, PinnedEntry(packageName), true)
+}
+
+// UnpinPackage は focusPinned.<packageName> の要素を packageName に戻す
+func (m *Manager) UnpinPackage(packageName string) error {
+	return m.replacePackage(PinnedEntry(packageName), packageName, false)
+}
+
+// replacePackage は oldName の要素を newText に置き換える
+// addFormal なら関数の引数に PinnedArg を追加する
+func (m *Manager) replacePackage(oldName, newText string, addFormal bool) error {
+	if err := m.backup(); err != nil {
+		return fmt.Errorf("バックアップの作成に失敗: %w", err)
+	}
+
+	content, err := os.ReadFile(m.filePath)
+	if err != nil {
+		return fmt.Errorf("ファイルの読み込みに失敗: %w", err)
+	}
+
+	lists, err := m.findPackageLists(content)
+	if err != nil {
+		return err
+	}
+
+	content, ok := lists.replace(oldName, newText)
+	if !ok {
+		return fmt.Errorf("パッケージ '%s' は見つかりませんでした", oldName)
+	}
+
+	if addFormal {
+		file, err := nixast.Parse(content)
+		if err != nil {
+			return fmt.Errorf("%s の解析に失敗: %w", m.filePath, err)
+		}
+
+		if !file.HasFormal(PinnedArg) {
+			added, ok := file.AddFormal(PinnedArg)
+			if !ok {
+				return fmt.Errorf("%s は { pkgs, ... }: の形の関数ではないため、%s 引数を追加できません", filepath.Base(m.filePath), PinnedArg)
+			}
+			content = added
+		}
+	}
+
+	if err := safefile.WriteFile(m.filePath, content, 0644); err != nil {
+		return fmt.Errorf("ファイルの書き込みに失敗: %w", err)
+	}
+
+	return nil
+}
+
+// replace は oldName の要素を newText に置き換えたソースを返す
+func (l *packageLists) replace(oldName, newText string) ([]byte, bool) {
+	if index := l.main.Index(oldName); index != -1 {
+		return l.main.Replace(index, newText), true
+	}
+
+	for _, block := range l.conditionals {
+		if index := block.List.Index(oldName); index != -1 {
+			return block.List.Replace(index, newText), true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,158 @@
+package nixfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPinsModule tests that each revision is imported once and pins are exposed by attribute path
+func TestPinsModule(t *testing.T) {
+	module := string(PinsModule([]Pin{
+		{Name: "terraform", Rev: "abc", Hash: "sha256-A="},
+		{Name: "python3Packages.black", Rev: "def", Hash: "sha256-B="},
+		{Name: "jq", Rev: "abc", Hash: "sha256-A="},
+	}))
+
+	if strings.Count(module, `nixpkgsAt "abc" "sha256-A=";`) != 1 || strings.Count(module, `nixpkgsAt "def" "sha256-B=";`) != 1 {
+		t.Errorf("Each revision should be bound once:\n%s", module)
+	}
+
+	for _, line := range []string{
+		"  _module.args.focusPinned = {\n",
+		"    terraform = nixpkgs-abc.terraform;\n",
+		"    python3Packages.black = nixpkgs-def.python3Packages.black;\n",
+		"    jq = nixpkgs-abc.jq;\n",
+	} {
+		if !strings.Contains(module, line) {
+			t.Errorf("Module should contain %q:\n%s", line, module)
+		}
+	}
+
+	// 固定がなくても focusPinned 引数は定義する
+	empty := string(PinsModule(nil))
+	if strings.Contains(empty, "let") || !strings.Contains(empty, "_module.args.focusPinned = {\n  };") {
+		t.Errorf("Unexpected empty module:\n%s", empty)
+	}
+}
+
+// TestPinnedName tests recognizing rewritten entries
+func TestPinnedName(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+		ok    bool
+	}{
+		{"focusPinned.terraform", "terraform", true},
+		{"focusPinned.python3Packages.black", "python3Packages.black", true},
+		{"terraform", "", false},
+		{"focusPinned.(x)", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := PinnedName(tt.entry)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("PinnedName(%q) = %q, %v; want %q, %v", tt.entry, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if entry := PinnedEntry("terraform"); entry != "focusPinned.terraform" {
+		t.Errorf("PinnedEntry(terraform) = %q", entry)
+	}
+}
+
+// TestPinUnpinPackage tests rewriting entries in place, keeping comments and conditions
+func TestPinUnpinPackage(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := `{ pkgs, lib, ... }:
+{
+  home.packages = with pkgs; [
+    ripgrep
+    terraform # インフラ
+  ] ++ lib.optionals pkgs.stdenv.isDarwin [
+    pngpaste
+  ];
+}
+`
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	if err := manager.PinPackage("terraform"); err != nil {
+		t.Fatalf("PinPackage failed: %v", err)
+	}
+	if err := manager.PinPackage("pngpaste"); err != nil {
+		t.Fatalf("PinPackage failed: %v", err)
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	expected := strings.NewReplacer(
+		"{ pkgs, lib, ... }", "{ pkgs, lib, focusPinned, ... }",
+		"    terraform # インフラ", "    focusPinned.terraform # インフラ",
+		"    pngpaste", "    focusPinned.pngpaste",
+	).Replace(initialContent)
+	if string(content) != expected {
+		t.Errorf("Unexpected content after PinPackage\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+
+	entries, err := manager.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	if entries[2].Name != "focusPinned.pngpaste" || entries[2].Condition.Platform != "darwin" {
+		t.Errorf("Condition should be kept: %+v", entries[2])
+	}
+
+	if err := manager.UnpinPackage("terraform"); err != nil {
+		t.Fatalf("UnpinPackage failed: %v", err)
+	}
+	if err := manager.UnpinPackage("pngpaste"); err != nil {
+		t.Fatalf("UnpinPackage failed: %v", err)
+	}
+
+	content, err = os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	expected = strings.Replace(initialContent, "{ pkgs, lib, ... }", "{ pkgs, lib, focusPinned, ... }", 1)
+	if string(content) != expected {
+		t.Errorf("Unexpected content after UnpinPackage\ngot:\n%s\nwant:\n%s", content, expected)
+	}
+
+	if err := manager.UnpinPackage("ripgrep"); err == nil {
+		t.Error("UnpinPackage should fail for a package that is not pinned")
+	}
+}
+
+// TestPinPackageRequiresFormals tests that files without an argument set are rejected
+func TestPinPackageRequiresFormals(t *testing.T) {
+	tmpDir := t.TempDir()
+	nixFilePath := filepath.Join(tmpDir, "packages.nix")
+
+	initialContent := "pkgs: {\n  home.packages = [\n    pkgs.terraform\n  ];\n}\n"
+	if err := os.WriteFile(nixFilePath, []byte(initialContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manager := NewManager(nixFilePath)
+	if err := manager.PinPackage("pkgs.terraform"); err == nil {
+		t.Error("PinPackage should fail when focusPinned can't be added")
+	}
+
+	content, err := os.ReadFile(nixFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != initialContent {
+		t.Errorf("File should not change:\n%s", content)
+	}
+}
@@ -0,0 +1,114 @@
+package safefile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// テストで失敗を再現するために差し替えられるようにしている
+var (
+	createTemp = os.CreateTemp
+	writeTemp  = func(f *os.File, data []byte) (int, error) { return f.Write(data) }
+	syncTemp   = func(f *os.File) error { return f.Sync() }
+	rename     = os.Rename
+)
+
+// WriteFile は同じディレクトリの一時ファイルに書き込んで fsync し、path に rename する
+// 途中で失敗した場合、path は元の内容のまま残る
+// path が既にあればその権限を引き継ぎ、なければ perm で作成する
+// path がシンボリックリンクならリンク先のファイルを置き換える（リンクはそのまま残す）
+func WriteFile(path string, data []byte, perm os.FileMode) (err error) {
+	target, err := resolve(path)
+	if err != nil {
+		return err
+	}
+
+	mode := perm
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("%s の状態の取得に失敗: %w", path, err)
+	}
+
+	dir := filepath.Dir(target)
+	tmp, err := createTemp(dir, "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("一時ファイルの作成に失敗: %w", err)
+	}
+
+	tmpPath := tmp.Name()
+	closed := false
+	defer func() {
+		if err != nil {
+			if !closed {
+				tmp.Close()
+			}
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := writeTemp(tmp, data); err != nil {
+		return fmt.Errorf("%s の書き込みに失敗: %w", path, err)
+	}
+
+	// CreateTemp は 0600 で作るので、元のファイルの権限に合わせる
+	if err := tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("%s の権限の設定に失敗: %w", path, err)
+	}
+
+	if err := syncTemp(tmp); err != nil {
+		return fmt.Errorf("%s の書き込みに失敗: %w", path, err)
+	}
+
+	closed = true
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s の書き込みに失敗: %w", path, err)
+	}
+
+	if err := rename(tmpPath, target); err != nil {
+		return fmt.Errorf("%s の置き換えに失敗: %w", path, err)
+	}
+
+	// rename 自体を永続化する（失敗してもファイルの内容は正しいので無視する）
+	syncDir(dir)
+
+	return nil
+}
+
+// resolve はシンボリックリンクをたどった実際の書き込み先を返す
+// リンク先がまだ存在しない場合もリンクを置き換えないようにする
+func resolve(path string) (string, error) {
+	for range 40 {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s の状態の取得に失敗: %w", path, err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", fmt.Errorf("%s のリンク先の取得に失敗: %w", path, err)
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		path = link
+	}
+
+	return "", fmt.Errorf("%s のシンボリックリンクが深すぎます", path)
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
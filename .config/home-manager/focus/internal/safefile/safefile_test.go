@@ -0,0 +1,143 @@
+package safefile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWriteFilePreservesMode tests that an existing file keeps its permissions
+func TestWriteFilePreservesMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "focus-packages.nix")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	assertContent(t, path, "new")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600 to be kept, got %o", info.Mode().Perm())
+	}
+
+	// 新しいファイルは perm で作る
+	created := filepath.Join(filepath.Dir(path), "new.nix")
+	if err := WriteFile(created, []byte("x"), 0640); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	info, err = os.Stat(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640 for a new file, got %o", info.Mode().Perm())
+	}
+}
+
+// TestWriteFileFollowsSymlink tests that the link target is replaced and the link is kept
+func TestWriteFileFollowsSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "home.nix")
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(dir, "home.nix")
+	if err := os.Symlink(filepath.Join("dotfiles", "home.nix"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(link, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Symlink should not be replaced by a regular file")
+	}
+	assertContent(t, target, "new")
+}
+
+// TestWriteFileFailures tests that a failure at any step leaves the original file and no temp file
+func TestWriteFileFailures(t *testing.T) {
+	errDiskFull := errors.New("no space left on device")
+
+	tests := []struct {
+		name  string
+		setup func()
+	}{
+		{"create", func() {
+			createTemp = func(string, string) (*os.File, error) { return nil, errDiskFull }
+		}},
+		{"write", func() {
+			// 途中まで書いてから失敗する
+			writeTemp = func(f *os.File, data []byte) (int, error) {
+				n, _ := f.Write(data[:len(data)/2])
+				return n, errDiskFull
+			}
+		}},
+		{"sync", func() {
+			syncTemp = func(*os.File) error { return errDiskFull }
+		}},
+		{"rename", func() {
+			rename = func(string, string) error { return errDiskFull }
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedCreate, savedWrite, savedSync, savedRename := createTemp, writeTemp, syncTemp, rename
+			t.Cleanup(func() {
+				createTemp, writeTemp, syncTemp, rename = savedCreate, savedWrite, savedSync, savedRename
+			})
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "focus-packages.nix")
+			if err := os.WriteFile(path, []byte("original content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.setup()
+
+			err := WriteFile(path, []byte("replacement content"), 0644)
+			if !errors.Is(err, errDiskFull) {
+				t.Fatalf("Expected the simulated error, got %v", err)
+			}
+
+			assertContent(t, path, "original content")
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("Temporary file was left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Errorf("Expected %q, got %q", want, string(data))
+	}
+}
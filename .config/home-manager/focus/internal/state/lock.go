@@ -0,0 +1,122 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockFileName は状態ディレクトリに置くロックファイルの名前
+// home-manager switch はユーザー単位なので、設定ファイルが違っても同じロックを使う
+const lockFileName = "focus.lock"
+
+// LockedError は別のプロセスがロックを持っていることを表す
+type LockedError struct {
+	// PID はロックを持っているプロセスの ID（不明なら 0）
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return "別の focus の操作が実行中です。終わるのを待つには --wait を指定してください"
+	}
+	return fmt.Sprintf("別の focus の操作が実行中です (pid %d)。終わるのを待つには --wait を指定してください", e.PID)
+}
+
+// Lock は focus-packages.nix の読み込みから switch までを他の focus と排他にするアドバイザリロック
+type Lock struct {
+	file *os.File
+}
+
+// AcquireLock は状態ディレクトリのロックを取得する
+// 別のプロセスが持っている場合、wait が false なら *LockedError を返し、
+// true なら onWait を呼んでから解放されるまで待つ
+func AcquireLock(wait bool, onWait func(pid int)) (*Lock, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("状態ディレクトリの作成に失敗: %w", err)
+	}
+
+	return acquireLockFile(filepath.Join(dir, lockFileName), wait, onWait)
+}
+
+func acquireLockFile(path string, wait bool, onWait func(pid int)) (*Lock, error) {
+	// ロックファイルは削除しない（削除と取得が競合すると2つのプロセスが別々のファイルをロックしてしまう）
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("ロックファイルを開けません: %w", err)
+	}
+
+	locked, err := tryLockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ロックの取得に失敗: %w", err)
+	}
+
+	if !locked {
+		pid := readLockPID(path)
+		if !wait {
+			file.Close()
+			return nil, &LockedError{PID: pid}
+		}
+
+		if onWait != nil {
+			onWait(pid)
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("ロックの取得に失敗: %w", err)
+		}
+	}
+
+	// 待っている側や診断のために、ロックを持っているプロセスを書いておく
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Release はロックを解放する（nil なら何もしない）
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	// 次に取得するプロセスが書き直すので、PID は消すだけにする
+	l.file.Truncate(0)
+	err := unlockFile(l.file)
+	l.file.Close()
+	l.file = nil
+
+	if err != nil {
+		return fmt.Errorf("ロックの解放に失敗: %w", err)
+	}
+	return nil
+}
+
+// readLockPID はロックファイルに書かれた PID を返す（読めなければ 0）
+func readLockPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// IsLocked は err が別のプロセスのロックによるものかを返す
+func IsLocked(err error) bool {
+	var lockedErr *LockedError
+	return errors.As(err, &lockedErr)
+}
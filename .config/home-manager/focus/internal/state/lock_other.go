@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package state
+
+import "os"
+
+// LockSupported はファイルロックで focus の同時実行を防げるかどうか
+// この環境ではロックできないので、呼び出し側で警告する
+const LockSupported = false
+
+// tryLockFile はファイルロックのない環境では常に取得できたものとして扱う
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
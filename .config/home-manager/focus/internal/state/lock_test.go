@@ -0,0 +1,96 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestAcquireLockExcludesOthers tests that a held lock is reported with the holder's pid
+func TestAcquireLockExcludesOthers(t *testing.T) {
+	t.Setenv("FOCUS_STATE_DIR", t.TempDir())
+
+	lock, err := AcquireLock(false, nil)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+
+	// 別のファイル記述子からの flock は同じプロセスでも競合する
+	_, err = AcquireLock(false, nil)
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("Expected LockedError, got %v", err)
+	}
+	if lockedErr.PID != os.Getpid() {
+		t.Errorf("Expected pid %d, got %d", os.Getpid(), lockedErr.PID)
+	}
+	if !IsLocked(err) {
+		t.Error("IsLocked should report the lock error")
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// 解放後は取得できる
+	lock, err = AcquireLock(false, nil)
+	if err != nil {
+		t.Fatalf("AcquireLock after release failed: %v", err)
+	}
+	lock.Release()
+}
+
+// TestAcquireLockWaits tests that --wait blocks until the holder releases the lock
+func TestAcquireLockWaits(t *testing.T) {
+	t.Setenv("FOCUS_STATE_DIR", t.TempDir())
+
+	lock, err := AcquireLock(false, nil)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+
+	waiting := make(chan int, 1)
+	acquired := make(chan error, 1)
+	go func() {
+		second, err := AcquireLock(true, func(pid int) { waiting <- pid })
+		if err == nil {
+			second.Release()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case pid := <-waiting:
+		if pid != os.Getpid() {
+			t.Errorf("Expected to wait for pid %d, got %d", os.Getpid(), pid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiter was not notified")
+	}
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("Lock acquired before release: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lock.Release()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("Waiting AcquireLock failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiter did not acquire the lock after release")
+	}
+}
+
+// TestReleaseNilLock tests that releasing a nil lock (dry-run) is a no-op
+func TestReleaseNilLock(t *testing.T) {
+	var lock *Lock
+	if err := lock.Release(); err != nil {
+		t.Errorf("Release on nil lock failed: %v", err)
+	}
+}
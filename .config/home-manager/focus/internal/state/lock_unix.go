@@ -0,0 +1,35 @@
+//go:build linux || darwin
+
+package state
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// LockSupported はファイルロックで focus の同時実行を防げるかどうか
+const LockSupported = true
+
+// tryLockFile は待たずに排他ロックを試み、取得できたかを返す
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockFile は排他ロックを取得できるまで待つ
+func lockFile(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
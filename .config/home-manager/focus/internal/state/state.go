@@ -0,0 +1,67 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir はfocusの状態ディレクトリを返す
+// FOCUS_STATE_DIR が設定されていればそれを使い、
+// なければ $XDG_STATE_HOME/focus（未設定時は ~/.local/state/focus）を使う
+func Dir() (string, error) {
+	if dir := os.Getenv("FOCUS_STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "focus"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("ホームディレクトリの取得に失敗: %w", err)
+	}
+
+	return filepath.Join(homeDir, ".local", "state", "focus"), nil
+}
+
+// SubDir は状態ディレクトリ配下のディレクトリを作成して返す
+func SubDir(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", fmt.Errorf("状態ディレクトリの作成に失敗: %w", err)
+	}
+
+	return path, nil
+}
+
+// CacheDir はfocusのキャッシュディレクトリを作成して返す
+// FOCUS_CACHE_DIR が設定されていればそれを使い、
+// なければ $XDG_CACHE_HOME/focus（未設定時は ~/.cache/focus）を使う
+func CacheDir() (string, error) {
+	dir := os.Getenv("FOCUS_CACHE_DIR")
+
+	if dir == "" {
+		if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+			dir = filepath.Join(xdg, "focus")
+		} else {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("ホームディレクトリの取得に失敗: %w", err)
+			}
+			dir = filepath.Join(homeDir, ".cache", "focus")
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("キャッシュディレクトリの作成に失敗: %w", err)
+	}
+
+	return dir, nil
+}
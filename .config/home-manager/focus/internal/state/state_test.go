@@ -0,0 +1,83 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDirPrecedence tests FOCUS_STATE_DIR, XDG_STATE_HOME and the default
+func TestDirPrecedence(t *testing.T) {
+	t.Setenv("FOCUS_STATE_DIR", "/tmp/focus-state")
+	t.Setenv("XDG_STATE_HOME", "/tmp/xdg")
+
+	dir, err := Dir()
+	if err != nil {
+		t.Fatalf("Dir failed: %v", err)
+	}
+	if dir != "/tmp/focus-state" {
+		t.Errorf("FOCUS_STATE_DIR should win: got %s", dir)
+	}
+
+	t.Setenv("FOCUS_STATE_DIR", "")
+	dir, err = Dir()
+	if err != nil {
+		t.Fatalf("Dir failed: %v", err)
+	}
+	if dir != filepath.Join("/tmp/xdg", "focus") {
+		t.Errorf("XDG_STATE_HOME not used: got %s", dir)
+	}
+
+	t.Setenv("XDG_STATE_HOME", "")
+	dir, err = Dir()
+	if err != nil {
+		t.Fatalf("Dir failed: %v", err)
+	}
+	homeDir, _ := os.UserHomeDir()
+	if dir != filepath.Join(homeDir, ".local", "state", "focus") {
+		t.Errorf("Default state dir mismatch: got %s", dir)
+	}
+}
+
+// TestSubDir tests that SubDir creates the directory
+func TestSubDir(t *testing.T) {
+	t.Setenv("FOCUS_STATE_DIR", t.TempDir())
+
+	dir, err := SubDir("history")
+	if err != nil {
+		t.Fatalf("SubDir failed: %v", err)
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("SubDir did not create %s", dir)
+	}
+}
+
+// TestCacheDir tests FOCUS_CACHE_DIR and XDG_CACHE_HOME
+func TestCacheDir(t *testing.T) {
+	override := filepath.Join(t.TempDir(), "cache")
+	t.Setenv("FOCUS_CACHE_DIR", override)
+
+	dir, err := CacheDir()
+	if err != nil {
+		t.Fatalf("CacheDir failed: %v", err)
+	}
+	if dir != override {
+		t.Errorf("FOCUS_CACHE_DIR should win: got %s", dir)
+	}
+
+	xdg := t.TempDir()
+	t.Setenv("FOCUS_CACHE_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", xdg)
+
+	dir, err = CacheDir()
+	if err != nil {
+		t.Fatalf("CacheDir failed: %v", err)
+	}
+	if dir != filepath.Join(xdg, "focus") {
+		t.Errorf("XDG_CACHE_HOME not used: got %s", dir)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("CacheDir did not create %s", dir)
+	}
+}